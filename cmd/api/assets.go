@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/storage"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	errChunkLength      = errors.New("the request body length does not match the Content-Range header")
+	errChecksumMismatch = errors.New("checksum mismatch")
+)
+
+func (app *application) readAssetIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("asset_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid asset_id parameter")
+	}
+
+	return id, nil
+}
+
+var contentRangeRX = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes start-end/total", where end is inclusive.
+func parseContentRange(header string) (start, end, total int64, err error) {
+	m := contentRangeRX.FindStringSubmatch(strings.TrimSpace(header))
+	if m == nil {
+		return 0, 0, 0, errors.New(`the Content-Range header must be of the form "bytes start-end/total"`)
+	}
+
+	start, err1 := strconv.ParseInt(m[1], 10, 64)
+	end, err2 := strconv.ParseInt(m[2], 10, 64)
+	total, err3 := strconv.ParseInt(m[3], 10, 64)
+	if err := errors.Join(err1, err2, err3); err != nil || start > end || end >= total {
+		return 0, 0, 0, errors.New("the Content-Range header is not a valid byte range")
+	}
+
+	return start, end, total, nil
+}
+
+// chunkReader yields exactly n bytes from r, failing with errChunkLength if r
+// ends early or has bytes to spare.
+type chunkReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.n <= 0 {
+		var extra [1]byte
+		if n, _ := io.ReadFull(cr.r, extra[:]); n > 0 {
+			return 0, errChunkLength
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+
+	n, err := cr.r.Read(p)
+	cr.n -= int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if cr.n > 0 {
+			return n, errChunkLength
+		}
+		err = nil
+	}
+
+	return n, err
+}
+
+// partsReader reads a sequence of blobs as one stream, opening each only when
+// the previous one is exhausted.
+type partsReader struct {
+	ctx   context.Context
+	blobs storage.BlobStore
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.cur == nil {
+			if len(pr.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := pr.blobs.Get(pr.ctx, pr.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("opening part %s: %w", pr.keys[0], err)
+			}
+
+			pr.cur = rc
+			pr.keys = pr.keys[1:]
+		}
+
+		n, err := pr.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			pr.cur.Close()
+			pr.cur = nil
+
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.cur != nil {
+		return pr.cur.Close()
+	}
+	return nil
+}
+
+// assembleAsset concatenates the uploaded parts of asset into its final blob,
+// hashing them on the way. If the digest doesn't match the one the client
+// declared, the blob is removed again and errChecksumMismatch is returned.
+func (app *application) assembleAsset(ctx context.Context, asset *data.MediaAsset) error {
+	parts := &partsReader{ctx: ctx, blobs: app.blobs, keys: asset.PartKeys()}
+	defer parts.Close()
+
+	h := sha256.New()
+
+	err := app.blobs.Put(ctx, asset.StorageKey, io.TeeReader(parts, h), asset.Size, asset.ContentType)
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != asset.SHA256 {
+		app.deleteBlobs(asset.StorageKey)
+		return errChecksumMismatch
+	}
+
+	return nil
+}
+
+// deleteBlobs removes blobs in the background, logging rather than returning
+// failures since the request that made them unreachable has already succeeded.
+func (app *application) deleteBlobs(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		for _, key := range keys {
+			err := app.blobs.Delete(ctx, key)
+			if err != nil {
+				app.logger.Error("deleting blob", "key", key, "error", err)
+			}
+		}
+	})
+}
+
+// extendDeadlines replaces the server-wide read and write timeouts, which are
+// sized for small JSON requests, with -upload-timeout for a request that
+// streams media.
+func (app *application) extendDeadlines(w http.ResponseWriter) error {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.upload.timeout)
+
+	err := rc.SetReadDeadline(deadline)
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	err = rc.SetWriteDeadline(deadline)
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
+
+func (app *application) createAssetHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Kind        string `json:"kind"`
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		SHA256      string `json:"sha256"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	asset := &data.MediaAsset{
+		VideoID:     id,
+		Kind:        input.Kind,
+		Filename:    input.Filename,
+		ContentType: input.ContentType,
+		Size:        input.Size,
+		SHA256:      strings.ToLower(input.SHA256),
+	}
+
+	v := validator.New()
+	if data.ValidateMediaAsset(v, asset, app.config.upload.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.MediaAssets.Insert(r.Context(), asset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/videos/%s/assets/%d", asset.VideoID, asset.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"asset": asset}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAssetsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	assets, err := app.models.MediaAssets.GetAllForVideo(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"assets": assets}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAssetHandler(w http.ResponseWriter, r *http.Request) {
+	asset, ok := app.readAsset(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"asset": asset}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAsset looks up the asset named by the route parameters, sending an
+// error response and returning false if it can't.
+func (app *application) readAsset(w http.ResponseWriter, r *http.Request) (*data.MediaAsset, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	assetID, err := app.readAssetIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	asset, err := app.models.MediaAssets.Get(r.Context(), id, assetID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return asset, true
+}
+
+// uploadAssetChunkHandler stores one chunk of an asset, described by the
+// Content-Range header. Chunks must arrive in order; a client that loses track
+// can read uploaded_bytes from showAssetHandler and resume from there. The
+// final chunk assembles the file and verifies its checksum.
+func (app *application) uploadAssetChunkHandler(w http.ResponseWriter, r *http.Request) {
+	asset, ok := app.readAsset(w, r)
+	if !ok {
+		return
+	}
+
+	if asset.Status == data.AssetStatusComplete {
+		app.assetAlreadyUploadedResponse(w, r)
+		return
+	}
+
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if total != asset.Size {
+		app.badRequestResponse(w, r, fmt.Errorf("the Content-Range total must be the asset size (%d bytes)", asset.Size))
+		return
+	}
+
+	length := end - start + 1
+	if length > app.config.upload.maxChunkSize {
+		app.contentTooLargeResponse(w, r, app.config.upload.maxChunkSize)
+		return
+	}
+
+	if start != asset.UploadedBytes {
+		app.uploadOffsetMismatchResponse(w, r, asset)
+		return
+	}
+
+	err = app.extendDeadlines(w)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.blobs.Put(ctx, asset.PartKey(start), &chunkReader{r: r.Body, n: length}, length, asset.ContentType)
+	if err != nil {
+		switch {
+		case errors.Is(err, errChunkLength):
+			app.badRequestResponse(w, r, errChunkLength)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	asset.UploadedBytes = end + 1
+	asset.Parts = append(asset.Parts, end+1)
+
+	var (
+		staleBlobs []string
+		mismatch   bool
+	)
+
+	if asset.UploadedBytes == asset.Size {
+		err = app.assembleAsset(ctx, asset)
+		switch {
+		case errors.Is(err, errChecksumMismatch):
+			mismatch = true
+			staleBlobs = asset.PartKeys()
+			asset.UploadedBytes = 0
+			asset.Parts = []int64{}
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		default:
+			staleBlobs = asset.PartKeys()
+			asset.Status = data.AssetStatusComplete
+		}
+	}
+
+	if asset.Status == data.AssetStatusComplete {
+		err = app.completeAsset(ctx, asset)
+	} else {
+		err = app.models.MediaAssets.Update(ctx, asset)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteBlobs(staleBlobs...)
+
+	if mismatch {
+		app.checksumMismatchResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"asset": asset}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// completeAsset saves a fully uploaded asset and queues its processing jobs in
+// the same transaction, so every complete asset gets them.
+func (app *application) completeAsset(ctx context.Context, asset *data.MediaAsset) error {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	err = models.MediaAssets.Update(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueAssetJobs(ctx, models.Jobs, asset)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
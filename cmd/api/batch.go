@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/validator"
+)
+
+const maxBatchOperations = 100
+
+type batchOperation struct {
+	Op              string            `json:"op"`
+	VideoID         string            `json:"video_id"`
+	ExpectedVersion *int              `json:"expected_version"`
+	Fields          *videoUpdateInput `json:"fields"`
+}
+
+type batchResult struct {
+	Index   int         `json:"index"`
+	Op      string      `json:"op"`
+	VideoID string      `json:"video_id"`
+	Status  int         `json:"status"`
+	Video   *data.Video `json:"video,omitempty"`
+	Error   any         `json:"error,omitempty"`
+
+	// blobs lists the media asset blobs to delete once a delete operation
+	// has been committed.
+	blobs []string
+}
+
+func (res batchResult) failed() bool {
+	return res.Status >= 400
+}
+
+func validateBatch(v *validator.Validator, mode string, ops []batchOperation) {
+	v.Check(validator.PermittedValue(mode, "atomic", "best_effort"), "mode", "must be either atomic or best_effort")
+	v.Check(len(ops) > 0, "operations", "must contain at least one operation")
+	v.Check(len(ops) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	for i, op := range ops {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		v.Check(validator.PermittedValue(op.Op, "update", "delete"), key+".op", "must be either update or delete")
+		v.Check(op.VideoID != "", key+".video_id", "must be provided")
+
+		if op.Op == "update" {
+			v.Check(op.Fields != nil, key+".fields", "must be provided for update operations")
+		}
+	}
+}
+
+// batchVideosHandler applies a list of updates and deletes in one
+// transaction. In atomic mode the first failing operation rolls back the whole
+// batch; in best_effort mode each operation runs in its own savepoint and
+// failures only undo that operation.
+func (app *application) batchVideosHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string           `json:"mode"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Mode == "" {
+		input.Mode = "atomic"
+	}
+
+	v := validator.New()
+	if validateBatch(v, input.Mode, input.Operations); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	ctx := r.Context()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+	bestEffort := input.Mode == "best_effort"
+
+	results := make([]batchResult, 0, len(input.Operations))
+	failedAt := -1
+
+	for i, op := range input.Operations {
+		if bestEffort {
+			_, err = tx.ExecContext(ctx, "SAVEPOINT batch_operation")
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		res, err := app.runBatchOperation(ctx, models, op)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		res.Index = i
+
+		results = append(results, res)
+
+		if res.failed() {
+			if !bestEffort {
+				failedAt = i
+				break
+			}
+
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_operation")
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if failedAt >= 0 {
+		message := fmt.Sprintf("rolled back because operation %d failed", failedAt)
+
+		for i := range results[:failedAt] {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Video = nil
+			results[i].Error = message
+		}
+
+		for i := failedAt + 1; i < len(input.Operations); i++ {
+			results = append(results, batchResult{
+				Index:   i,
+				Op:      input.Operations[i].Op,
+				VideoID: input.Operations[i].VideoID,
+				Status:  http.StatusFailedDependency,
+				Error:   message,
+			})
+		}
+
+		err = app.writeJSON(w, r, results[failedAt].Status, envelope{"committed": false, "results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var updated []*data.Video
+
+	for _, res := range results {
+		if !res.failed() {
+			app.deleteBlobs(res.blobs...)
+		}
+		if res.Video != nil {
+			updated = append(updated, res.Video)
+		}
+	}
+
+	err = app.setThumbnails(ctx, updated...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"committed": true, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runBatchOperation applies a single batch operation using the same checks as
+// the single-item handlers. Client errors are reported in the result; the
+// returned error is only set for unexpected failures.
+func (app *application) runBatchOperation(ctx context.Context, models data.Models, op batchOperation) (batchResult, error) {
+	res := batchResult{Op: op.Op, VideoID: op.VideoID}
+
+	video, err := models.Videos.GetForUpdate(ctx, op.VideoID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			res.Status = http.StatusNotFound
+			res.Error = "the requested resource could not be found"
+			return res, nil
+		default:
+			return res, err
+		}
+	}
+
+	if op.ExpectedVersion != nil && *op.ExpectedVersion != video.Version {
+		res.Status = http.StatusConflict
+		res.Error = "unable to update the record due to an edit conflict, please try again"
+		return res, nil
+	}
+
+	switch op.Op {
+	case "update":
+		op.Fields.apply(video)
+
+		v := validator.New()
+		if data.ValidateVideo(v, video); !v.Valid() {
+			res.Status = http.StatusUnprocessableEntity
+			res.Error = v.Errors
+			return res, nil
+		}
+
+		err = models.Videos.Update(ctx, video)
+		if err != nil {
+			var fieldErr *data.FieldError
+
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				res.Status = http.StatusConflict
+				res.Error = "unable to update the record due to an edit conflict, please try again"
+				return res, nil
+			case errors.As(err, &fieldErr):
+				res.Status = http.StatusUnprocessableEntity
+				res.Error = map[string]string{fieldErr.Field: fieldErr.Message}
+				return res, nil
+			default:
+				return res, err
+			}
+		}
+
+		res.Status = http.StatusOK
+		res.Video = video
+
+	case "delete":
+		res.blobs, err = videoBlobKeys(ctx, models, op.VideoID)
+		if err != nil {
+			return res, err
+		}
+
+		err = models.Videos.Delete(ctx, op.VideoID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				res.Status = http.StatusNotFound
+				res.Error = "the requested resource could not be found"
+				return res, nil
+			default:
+				return res, err
+			}
+		}
+
+		res.Status = http.StatusOK
+	}
+
+	return res, nil
+}
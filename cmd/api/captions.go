@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/JLL32/thmanyah/internal/captions"
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxCaptionSize bounds an uploaded caption file. A two-hour transcript is
+// typically around 150 KB.
+const maxCaptionSize = 5 << 20
+
+var captionContentTypes = map[string]string{
+	captions.FormatVTT: "text/vtt",
+	captions.FormatSRT: "application/x-subrip",
+}
+
+// captionFormat picks the format of an uploaded caption file from its
+// Content-Type, or from its content when the type is generic. It returns ""
+// for a content type that isn't a caption format.
+func captionFormat(mediaType string, body []byte) string {
+	switch mediaType {
+	case "text/vtt":
+		return captions.FormatVTT
+	case "application/x-subrip", "text/srt":
+		return captions.FormatSRT
+	case "", "text/plain", "application/octet-stream":
+		if strings.HasPrefix(strings.TrimPrefix(string(body), "\ufeff"), "WEBVTT") {
+			return captions.FormatVTT
+		}
+		return captions.FormatSRT
+	default:
+		return ""
+	}
+}
+
+func (app *application) captionURL(videoID, language, format string) string {
+	return fmt.Sprintf("/v1/videos/%s/captions/%s.%s", videoID, language, format)
+}
+
+// captionTrackResponse is a caption track with the URLs it can be fetched
+// from.
+type captionTrackResponse struct {
+	*data.CaptionTrack
+	URLs map[string]string `json:"urls"`
+}
+
+func (app *application) captionTrackResponse(videoID string, track *data.CaptionTrack) captionTrackResponse {
+	return captionTrackResponse{
+		CaptionTrack: track,
+		URLs: map[string]string{
+			captions.FormatVTT: app.captionURL(videoID, track.Language, captions.FormatVTT),
+			captions.FormatSRT: app.captionURL(videoID, track.Language, captions.FormatSRT),
+		},
+	}
+}
+
+// uploadCaptionsHandler replaces the caption track of a video in one
+// language. The request body is a WebVTT or SRT file.
+func (app *application) uploadCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language := httprouter.ParamsFromContext(r.Context()).ByName("lang")
+
+	v := validator.New()
+
+	if data.ValidateCaptionLanguage(v, language); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	_, err = app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCaptionSize))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.contentTooLargeResponse(w, r, maxBytesError.Limit)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	format := captionFormat(requestMediaType(r), body)
+	if format == "" {
+		app.unsupportedCaptionTypeResponse(w, r)
+		return
+	}
+
+	if v.Check(utf8.Valid(body), "captions", "must be UTF-8 encoded"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	cues, err := captions.Parse(format, string(body))
+	if err != nil {
+		var parseErr *captions.ParseError
+
+		switch {
+		case errors.As(err, &parseErr), errors.Is(err, captions.ErrNoCues):
+			v.AddError("captions", err.Error())
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if v.Check(len(cues) <= data.MaxCaptionCues, "captions", fmt.Sprintf("must not have more than %d cues", data.MaxCaptionCues)); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.replaceCaptions(r.Context(), id, language, cues)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	track := &data.CaptionTrack{Language: language, Cues: len(cues)}
+
+	tracks, err := app.models.Captions.GetTracks(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, t := range tracks {
+		if t.Language == language {
+			track = t
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"caption_track": app.captionTrackResponse(id, track)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceCaptions swaps the cues of a track in a transaction.
+func (app *application) replaceCaptions(ctx context.Context, videoID, language string, cues []captions.Cue) error {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	err = models.Captions.Delete(ctx, videoID, language)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+
+	err = models.Captions.Insert(ctx, videoID, language, cues)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (app *application) listCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tracks, err := app.models.Captions.GetTracks(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := make([]captionTrackResponse, len(tracks))
+	for i, track := range tracks {
+		response[i] = app.captionTrackResponse(id, track)
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"caption_tracks": response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language := httprouter.ParamsFromContext(r.Context()).ByName("lang")
+
+	err = app.models.Captions.Delete(r.Context(), id, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "captions successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCaptionsHandler serves a caption track as <lang>.vtt or <lang>.srt,
+// regardless of the format it was uploaded in. The route shares its :lang
+// parameter with the upload route, so here it includes the extension.
+func (app *application) showCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	file := httprouter.ParamsFromContext(r.Context()).ByName("lang")
+	format := strings.TrimPrefix(path.Ext(file), ".")
+	language := strings.TrimSuffix(file, path.Ext(file))
+
+	contentType, ok := captionContentTypes[format]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cues, err := app.models.Captions.Get(r.Context(), id, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	out, err := captions.Write(format, cues)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(out)))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.WriteString(w, out)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// podcastChaptersVersion is the version of the Podcasting 2.0 JSON chapters
+// format that showPodcastChaptersHandler emits.
+const podcastChaptersVersion = "1.2.0"
+
+func (app *application) readChapterIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("chapter_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid chapter_id parameter")
+	}
+
+	return id, nil
+}
+
+// nullableInt is an optional JSON integer that tells a member set to null,
+// which clears the field, apart from a missing one, which leaves it alone.
+type nullableInt struct {
+	Set   bool
+	Value *int
+}
+
+func (n *nullableInt) UnmarshalJSON(b []byte) error {
+	n.Set = true
+
+	if bytes.Equal(b, []byte("null")) {
+		n.Value = nil
+		return nil
+	}
+
+	return json.Unmarshal(b, &n.Value)
+}
+
+// chapterUpdateInput holds the fields of a partial chapter update. Only
+// members present in the request are applied.
+type chapterUpdateInput struct {
+	StartTime *int        `json:"start_time"`
+	EndTime   nullableInt `json:"end_time"`
+	Title     *string     `json:"title"`
+	ImageURL  *string     `json:"image_url"`
+}
+
+func (input chapterUpdateInput) apply(chapter *data.Chapter) {
+	if input.StartTime != nil {
+		chapter.StartTime = *input.StartTime
+	}
+	if input.EndTime.Set {
+		chapter.EndTime = input.EndTime.Value
+	}
+	if input.Title != nil {
+		chapter.Title = *input.Title
+	}
+	if input.ImageURL != nil {
+		chapter.ImageURL = *input.ImageURL
+	}
+}
+
+// saveChapter validates chapter against its video and inserts it, or updates
+// it if it has an ID. The video row stays locked until the write commits, so
+// concurrent writes can't produce overlapping chapters. Validation errors are
+// added to v, and nothing is written.
+func (app *application) saveChapter(ctx context.Context, chapter *data.Chapter, v *validator.Validator) error {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	video, err := models.Videos.GetForUpdate(ctx, chapter.VideoID)
+	if err != nil {
+		return err
+	}
+
+	others, err := models.Chapters.GetAllForVideo(ctx, chapter.VideoID)
+	if err != nil {
+		return err
+	}
+
+	if data.ValidateChapter(v, chapter, video.Length, others); !v.Valid() {
+		return nil
+	}
+
+	if chapter.ID == 0 {
+		err = models.Chapters.Insert(ctx, chapter)
+	} else {
+		err = models.Chapters.Update(ctx, chapter)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// saveChapterResponse sends the response for a saveChapter call that failed
+// or found the chapter invalid, and reports whether it did.
+func (app *application) saveChapterResponse(w http.ResponseWriter, r *http.Request, err error, v *validator.Validator) bool {
+	if err != nil {
+		var fieldErr *data.FieldError
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.As(err, &fieldErr):
+			app.constraintViolationResponse(w, r, fieldErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return true
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return true
+	}
+
+	return false
+}
+
+func (app *application) createChapterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		StartTime *int   `json:"start_time"`
+		EndTime   *int   `json:"end_time"`
+		Title     string `json:"title"`
+		ImageURL  string `json:"image_url"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.StartTime != nil, "start_time", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	chapter := &data.Chapter{
+		VideoID:   id,
+		StartTime: *input.StartTime,
+		EndTime:   input.EndTime,
+		Title:     input.Title,
+		ImageURL:  input.ImageURL,
+	}
+
+	err = app.saveChapter(r.Context(), chapter, v)
+	if app.saveChapterResponse(w, r, err, v) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/videos/%s/chapters/%d", chapter.VideoID, chapter.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"chapter": chapter}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listChaptersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapters, ok := app.readChapters(w, r, id)
+	if !ok {
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"chapters": chapters}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readChapters returns the chapters of a video, or sends a 404 if the video
+// doesn't exist.
+func (app *application) readChapters(w http.ResponseWriter, r *http.Request, videoID string) ([]*data.Chapter, bool) {
+	_, err := app.models.Videos.Get(r.Context(), videoID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	chapters, err := app.models.Chapters.GetAllForVideo(r.Context(), videoID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	return chapters, true
+}
+
+func (app *application) showChapterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapterID, err := app.readChapterIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapter, err := app.models.Chapters.Get(r.Context(), id, chapterID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"chapter": chapter}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateChapterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapterID, err := app.readChapterIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapter, err := app.models.Chapters.Get(r.Context(), id, chapterID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if version := r.Header.Get("X-Expected-Version"); version != "" {
+		if strconv.Itoa(chapter.Version) != version {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	var input chapterUpdateInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.apply(chapter)
+
+	v := validator.New()
+
+	err = app.saveChapter(r.Context(), chapter, v)
+	if app.saveChapterResponse(w, r, err, v) {
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"chapter": chapter}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteChapterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapterID, err := app.readChapterIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Chapters.Delete(r.Context(), id, chapterID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "chapter successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// podcastChapter is a chapter in the Podcasting 2.0 JSON chapters format.
+type podcastChapter struct {
+	StartTime int    `json:"startTime"`
+	EndTime   *int   `json:"endTime,omitempty"`
+	Title     string `json:"title"`
+	Img       string `json:"img,omitempty"`
+}
+
+// showPodcastChaptersHandler serves the chapters of a video as a Podcasting
+// 2.0 JSON chapters file, for the podcast:chapters tag of a feed.
+func (app *application) showPodcastChaptersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	chapters, ok := app.readChapters(w, r, id)
+	if !ok {
+		return
+	}
+
+	out := make([]podcastChapter, len(chapters))
+	for i, chapter := range chapters {
+		out[i] = podcastChapter{
+			StartTime: chapter.StartTime,
+			EndTime:   chapter.EndTime,
+			Title:     chapter.Title,
+			Img:       chapter.ImageURL,
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json+chapters")
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"version": podcastChaptersVersion, "chapters": out}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
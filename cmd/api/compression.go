@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the smallest response body worth compressing; below it
+// the encoding overhead outweighs the savings.
+const minCompressSize = 1024
+
+// supportedEncodings lists the content codings we can produce, in order of
+// preference when a client weights several of them equally.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// negotiateEncoding picks a content coding from an Accept-Encoding header
+// value, following the q-value rules in RFC 9110 section 12.5.3. It returns
+// "identity" when nothing better is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return "identity"
+	}
+
+	weights := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				if f, err := strconv.ParseFloat(value, 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		if coding == "*" {
+			wildcard = q
+		} else {
+			weights[coding] = q
+		}
+	}
+
+	best, bestQ := "identity", 0.0
+	for _, coding := range supportedEncodings {
+		q, ok := weights[coding]
+		if !ok {
+			q = wildcard
+		}
+
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+
+	switch encoding {
+	case "gzip":
+		w, err = gzip.NewWriterLevel(&buf, gzip.DefaultCompression)
+	case "deflate":
+		// HTTP's deflate coding is the zlib format (RFC 9110 section
+		// 8.4.1.2), not raw DEFLATE.
+		w, err = zlib.NewWriterLevel(&buf, zlib.DefaultCompression)
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress.
+func decompress(encoding string, body []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
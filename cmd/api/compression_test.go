@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", "identity"},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"br", "identity"},
+		{"*", "gzip"},
+		{"*, gzip;q=0", "deflate"},
+	}
+
+	for _, tt := range tests {
+		got := negotiateEncoding(tt.acceptEncoding)
+		if got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q; want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := []byte(strings.Repeat(`{"title":"حلقة"}`, 200))
+
+	readers := map[string]func(io.Reader) (io.ReadCloser, error){
+		"gzip": func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		// Clients decode the deflate coding as zlib.
+		"deflate": zlib.NewReader,
+	}
+
+	for encoding, newReader := range readers {
+		compressed, err := compress(encoding, body)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+
+		r, err := newReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+
+		if !bytes.Equal(got, body) {
+			t.Errorf("%s: decoded body differs from the original", encoding)
+		}
+	}
+}
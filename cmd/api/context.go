@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/JLL32/thmanyah/internal/urlsign"
+)
+
+type contextKey string
+
+const (
+	requestStateContextKey = contextKey("requestState")
+	requestIDContextKey    = contextKey("requestID")
+	signedURLContextKey    = contextKey("signedURL")
+)
+
+// requestState is shared between the outer middleware chain and the router so
+// that values only known once a route has matched (such as its pattern) can be
+// read back after the handler returns.
+type requestState struct {
+	route string
+}
+
+func (app *application) contextSetRequestState(r *http.Request, state *requestState) *http.Request {
+	ctx := context.WithValue(r.Context(), requestStateContextKey, state)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestState(r *http.Request) *requestState {
+	state, ok := r.Context().Value(requestStateContextKey).(*requestState)
+	if !ok {
+		return nil
+	}
+
+	return state
+}
+
+// withRequestState returns the request state attached to r, attaching a new
+// one if none of the outer middleware has done so yet.
+func (app *application) withRequestState(r *http.Request) (*http.Request, *requestState) {
+	if state := app.contextGetRequestState(r); state != nil {
+		return r, state
+	}
+
+	state := &requestState{}
+	return app.contextSetRequestState(r, state), state
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, ok := r.Context().Value(requestIDContextKey).(string)
+	if !ok {
+		return ""
+	}
+
+	return id
+}
+
+func (app *application) contextSetSignedURL(r *http.Request, token urlsign.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), signedURLContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetSignedURL returns the token verified by requireSignedURL, or the
+// zero Token for a route without it.
+func (app *application) contextGetSignedURL(r *http.Request) urlsign.Token {
+	token, _ := r.Context().Value(signedURLContextKey).(urlsign.Token)
+	return token
+}
@@ -2,24 +2,127 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	var (
-		method = r.Method
-		uri    = r.URL.RequestURI()
+		method    = r.Method
+		uri       = r.URL.RequestURI()
+		requestID = app.contextGetRequestID(r)
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.Error(err.Error(), "method", method, "uri", uri, "request_id", requestID)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	w.Header().Add("Vary", "Accept")
+
+	if acceptsProblemJSON(r) {
+		app.problemResponse(w, r, status, message)
+		return
+	}
+
+	// The legacy envelope reports one message per field.
+	if v, ok := message.(*validator.Validator); ok {
+		message = v.Errors
+	}
+
 	env := envelope{
 		"error": message,
 	}
 
-	err := app.writeJSON(w, status, env, nil)
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		env["request_id"] = requestID
+	}
+
+	err := app.writeJSON(w, r, status, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// acceptsProblemJSON reports whether the client explicitly asked for RFC 9457
+// problem details. The legacy {"error": ...} envelope stays the default.
+func acceptsProblemJSON(r *http.Request) bool {
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), "application/problem+json") {
+			continue
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+type problemFieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// problemResponse writes an application/problem+json document. A string
+// message becomes the detail; field errors become the errors array, with one
+// entry per message.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	env := envelope{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": r.URL.Path,
+	}
+
+	switch m := message.(type) {
+	case string:
+		env["detail"] = m
+	case map[string]string:
+		env["detail"] = "the request contains invalid fields"
+
+		fieldErrors := make([]problemFieldError, 0, len(m))
+		for _, field := range slices.Sorted(maps.Keys(m)) {
+			fieldErrors = append(fieldErrors, problemFieldError{Field: field, Message: m[field]})
+		}
+		env["errors"] = fieldErrors
+	case *validator.Validator:
+		env["detail"] = "the request contains invalid fields"
+
+		fieldErrors := make([]problemFieldError, 0, len(m.FieldErrors))
+		for _, field := range slices.Sorted(maps.Keys(m.FieldErrors)) {
+			for _, message := range m.FieldErrors[field] {
+				fieldErrors = append(fieldErrors, problemFieldError{Field: field, Message: message})
+			}
+		}
+		env["errors"] = fieldErrors
+	default:
+		env["detail"] = fmt.Sprint(m)
+	}
+
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		env["request_id"] = requestID
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/problem+json")
+
+	err := app.writeJSON(w, r, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -47,8 +150,45 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, v)
+}
+
+func (app *application) constraintViolationResponse(w http.ResponseWriter, r *http.Request, fieldErr *data.FieldError) {
+	v := validator.New()
+	v.AddError(fieldErr.Field, fieldErr.Message)
+
+	app.failedValidationResponse(w, r, v)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, accepted string) {
+	w.Header().Set("Accept-Patch", accepted)
+
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) unsupportedImageTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for images, use one of: %s", r.Header.Get("Content-Type"), strings.Join(imageMediaTypes, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) unsupportedCaptionTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for captions, use one of: text/vtt, application/x-subrip", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+func (app *application) unprocessablePatchResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+}
+
+func (app *application) duplicateVideoIDResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]string{"video_id": "a video with this video_id already exists"}
+	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +196,56 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this idempotency key is already being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this idempotency key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+func (app *application) contentTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	message := fmt.Sprintf("the request body must not be larger than %d bytes", limit)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (app *application) uploadOffsetMismatchResponse(w http.ResponseWriter, r *http.Request, asset *data.MediaAsset) {
+	message := fmt.Sprintf("the upload is at byte %d; send the chunk that starts there", asset.UploadedBytes)
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) assetAlreadyUploadedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this asset has already been uploaded"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) checksumMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]string{"sha256": "does not match the uploaded file; the upload has been reset, please upload it again"}
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+// invalidSignedURLResponse reports a missing, tampered or expired URL
+// signature from urlsign.
+func (app *application) invalidSignedURLResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusForbidden, err.Error())
+}
+
+// providerErrorResponse reports that an external metadata provider failed or
+// couldn't be reached.
+func (app *application) providerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := "the metadata provider could not fulfil the request, please try again later"
+	app.errorResponse(w, r, http.StatusBadGateway, message)
+}
+
+func (app *application) syncInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a sync of this channel is already running, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -1,19 +1,100 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
 )
 
+func (app *application) systemInfo() map[string]string {
+	return map[string]string{
+		"environment": app.config.env,
+		"version":     version,
+		"commit":      commit,
+	}
+}
 
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+// livenessHandler reports whether the process is up and able to serve HTTP.
+// It deliberately doesn't touch the database so that an outage there doesn't
+// get the process restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
-		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
+		"status":      "available",
+		"system_info": app.systemInfo(),
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler reports whether the instance should receive traffic: the
+// server isn't shutting down, the database answers, and its schema is at the
+// version this build expects.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if app.shuttingDown.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	} else {
+		checks["server"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+
+	if err == nil {
+		version, dirty, err := app.models.Schema.Version(ctx)
+		switch {
+		case err != nil:
+			checks["migrations"] = err.Error()
+			ready = false
+		case dirty:
+			checks["migrations"] = fmt.Sprintf("version %d is dirty", version)
+			ready = false
+		case version != data.SchemaVersion:
+			checks["migrations"] = fmt.Sprintf("at version %d, expected %d", version, data.SchemaVersion)
+			ready = false
+		default:
+			checks["migrations"] = "ok"
+		}
+	}
+
+	stats := app.db.Stats()
+
+	env := envelope{
+		"status":      "ready",
+		"checks":      checks,
+		"system_info": app.systemInfo(),
+		"db_pool": map[string]int{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
 		},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	status := http.StatusOK
+	if !ready {
+		env["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	err = app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
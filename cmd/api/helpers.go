@@ -12,6 +12,7 @@ import (
 
 	"maps"
 
+	"github.com/JLL32/thmanyah/internal/tracing"
 	"github.com/JLL32/thmanyah/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -29,24 +30,88 @@ func (app *application) readIDParam(r *http.Request) (string, error) {
 
 type envelope map[string]any
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	jsn, err := json.MarshalIndent(data, "", "  ")
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	_, span := app.tracer.Start(r.Context(), "writeJSON", tracing.SpanKindInternal)
+	defer span.End()
+
+	var (
+		jsn []byte
+		err error
+	)
+
+	if app.config.env == "development" || r.URL.Query().Get("pretty") == "true" {
+		jsn, err = json.MarshalIndent(data, "", "  ")
+	} else {
+		jsn, err = json.Marshal(data)
+	}
 	if err != nil {
+		span.RecordError(err)
 		return err
 	}
 
 	// just to make it easier to view in terminal applications
 	jsn = append(jsn, '\n')
 
-	maps.Copy(w.Header(), headers)
+	span.SetAttributes(tracing.Int("json.bytes", len(jsn)))
 
 	w.Header().Set("Content-Type", "application/json")
+
+	maps.Copy(w.Header(), headers)
+
+	jsn, err = app.compressResponse(w, r, jsn)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+		span.SetAttributes(tracing.String("http.response.content_encoding", encoding))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsn)))
 	w.WriteHeader(status)
 	w.Write(jsn)
 
 	return nil
 }
 
+// compressResponse encodes a response body in the best content coding the
+// client accepts, if it is large enough to be worth it, and sets the
+// Content-Encoding and Vary headers to match.
+func (app *application) compressResponse(w http.ResponseWriter, r *http.Request, body []byte) ([]byte, error) {
+	addVary(w.Header(), "Accept-Encoding")
+
+	if len(body) < minCompressSize {
+		return body, nil
+	}
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "identity" {
+		return body, nil
+	}
+
+	body, err := compress(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	return body, nil
+}
+
+// addVary adds a header name to the Vary header unless it is already listed.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", name)
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	maxBytes := 1_048_576 //1mb
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -135,8 +200,11 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
+	app.metrics.backgroundTasks.Add(1)
+
 	go func() {
 		defer app.wg.Done()
+		defer app.metrics.backgroundTasks.Add(-1)
 
 		defer func() {
 			if err := recover(); err != nil {
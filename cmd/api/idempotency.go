@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+)
+
+// replayedHeaders are the response headers stored alongside an idempotent
+// response and sent again when it is replayed. Content-Encoding isn't one of
+// them: the body is stored decoded and compressed afresh for whoever retries,
+// since they may not accept the coding the first request negotiated.
+var replayedHeaders = []string{"Content-Type", "Location", "Content-Location", "ETag", "Vary"}
+
+type captureResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header
+	body       bytes.Buffer
+}
+
+func (cw *captureResponseWriter) WriteHeader(statusCode int) {
+	if cw.statusCode == 0 {
+		cw.statusCode = statusCode
+		cw.header = cw.ResponseWriter.Header().Clone()
+	}
+
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *captureResponseWriter) Write(b []byte) (int, error) {
+	if cw.statusCode == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// idempotency makes mutating requests that carry an Idempotency-Key header
+// safe to retry: the first response for a key is stored and replayed for
+// later requests with the same key and body, and reusing a key for a
+// different request is rejected.
+//
+// The body is buffered to fingerprint it, so only routes with JSON bodies, or
+// none, are wrapped. Uploads stream their bodies and are safe to retry
+// without a key.
+func (app *application) idempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			key = ""
+		}
+
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+
+		maxBytes := int64(1_048_576)
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if int64(len(body)) > maxBytes {
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s\n", r.Method, r.URL.Path)
+		fingerprint.Write(body)
+
+		record := &data.IdempotencyKey{
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Fingerprint: fingerprint.Sum(nil),
+			ExpiresAt:   time.Now().Add(app.config.idempotency.ttl),
+		}
+
+		reserved, err := app.models.IdempotencyKeys.Reserve(r.Context(), record)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !reserved {
+			existing, err := app.models.IdempotencyKeys.Get(r.Context(), key)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.idempotencyKeyInUseResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			switch {
+			case !bytes.Equal(existing.Fingerprint, record.Fingerprint):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case !existing.Completed():
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				app.replayResponse(w, r, existing)
+			}
+			return
+		}
+
+		cw := &captureResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			// Record the outcome even if the client has gone away, otherwise
+			// the key would look in flight until it expires.
+			ctx := context.WithoutCancel(r.Context())
+
+			// Server errors (including panics, which recoverPanic turns into
+			// a 500 further out) release the key so the client can retry.
+			if cw.statusCode == 0 || cw.statusCode >= 500 {
+				err := app.models.IdempotencyKeys.Delete(ctx, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+				return
+			}
+
+			body, err := decompress(cw.header.Get("Content-Encoding"), cw.body.Bytes())
+			if err != nil {
+				app.logError(r, err)
+
+				err = app.models.IdempotencyKeys.Delete(ctx, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+				return
+			}
+
+			record.StatusCode = cw.statusCode
+			record.ResponseBody = body
+			record.ResponseHeaders = make(http.Header)
+			for _, name := range replayedHeaders {
+				if values := cw.header.Values(name); len(values) > 0 {
+					record.ResponseHeaders[name] = values
+				}
+			}
+
+			err = app.models.IdempotencyKeys.Complete(ctx, record)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	}
+}
+
+// replayResponse sends a stored response again, compressed as the retrying
+// client asks.
+func (app *application) replayResponse(w http.ResponseWriter, r *http.Request, existing *data.IdempotencyKey) {
+	for _, name := range replayedHeaders {
+		for _, value := range existing.ResponseHeaders.Values(name) {
+			if name == "Vary" {
+				addVary(w.Header(), value)
+				continue
+			}
+
+			w.Header().Add(name, value)
+		}
+	}
+
+	// Keys stored before bodies were kept decoded still carry the coding of
+	// the original response.
+	body, err := decompress(existing.ResponseHeaders.Get("Content-Encoding"), existing.ResponseBody)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	body, err = app.compressResponse(w, r, body)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(body)
+}
+
+// expireIdempotencyKeys periodically deletes idempotency keys past their
+// expiry until the application starts shutting down.
+func (app *application) expireIdempotencyKeys(interval time.Duration) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.backgroundCtx.Done():
+				return
+			case <-ticker.C:
+				n, err := app.models.IdempotencyKeys.DeleteExpired(app.backgroundCtx)
+				if err != nil {
+					app.logger.Error(err.Error(), "job", "expire idempotency keys")
+					continue
+				}
+
+				if n > 0 {
+					app.logger.Info("expired idempotency keys", "count", n)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JLL32/thmanyah/internal/data"
+)
+
+func TestReplayResponseNegotiatesEncoding(t *testing.T) {
+	app := newTestApplication(t)
+
+	body := []byte(strings.Repeat(`{"title":"حلقة"}`, 200))
+
+	existing := &data.IdempotencyKey{
+		StatusCode: http.StatusCreated,
+		ResponseHeaders: http.Header{
+			"Content-Type": {"application/json"},
+			"Location":     {"/v1/videos/abc"},
+			"Etag":         {`"3"`},
+			"Vary":         {"Origin", "Accept-Encoding"},
+		},
+		ResponseBody: body,
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		stored         *data.IdempotencyKey
+	}{
+		{"identity", "", existing},
+		{"gzip", "gzip", existing},
+		{"stored compressed", "", storedCompressed(t, existing)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/videos", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			rr := httptest.NewRecorder()
+			rr.Header().Add("Vary", "Origin")
+
+			app.replayResponse(rr, r, tt.stored)
+
+			if rr.Code != http.StatusCreated {
+				t.Errorf("got status %d; want %d", rr.Code, http.StatusCreated)
+			}
+
+			for _, name := range []string{"Content-Type", "Location", "ETag"} {
+				if got, want := rr.Header().Get(name), existing.ResponseHeaders.Get(name); got != want {
+					t.Errorf("got %s %q; want %q", name, got, want)
+				}
+			}
+
+			if got := rr.Header().Values("Vary"); strings.Join(got, ", ") != "Origin, Accept-Encoding" {
+				t.Errorf("got Vary %q; want Origin and Accept-Encoding once each", got)
+			}
+
+			if rr.Header().Get("Idempotent-Replayed") != "true" {
+				t.Error("Idempotent-Replayed header not set")
+			}
+
+			got := rr.Body.Bytes()
+
+			if encoding := rr.Header().Get("Content-Encoding"); encoding != tt.acceptEncoding {
+				t.Fatalf("got Content-Encoding %q; want %q", encoding, tt.acceptEncoding)
+			}
+
+			if tt.acceptEncoding == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err = io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !bytes.Equal(got, body) {
+				t.Error("replayed body differs from the stored one")
+			}
+		})
+	}
+}
+
+// storedCompressed returns a copy of a stored response as it was recorded
+// before bodies were kept decoded.
+func storedCompressed(t *testing.T, key *data.IdempotencyKey) *data.IdempotencyKey {
+	t.Helper()
+
+	body, err := compress("gzip", key.ResponseBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := *key
+	stored.ResponseHeaders = key.ResponseHeaders.Clone()
+	stored.ResponseHeaders.Set("Content-Encoding", "gzip")
+	stored.ResponseBody = body
+
+	return &stored
+}
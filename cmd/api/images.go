@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/imaging"
+	"github.com/JLL32/thmanyah/internal/storage"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// maxImagePixels bounds the size of a decoded image (about 144 MB as
+	// RGBA), so a small, highly compressed upload can't exhaust memory.
+	maxImagePixels = 6000 * 6000
+
+	imageJPEGQuality = 85
+)
+
+var imageMediaTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+func parseImageSizes(val string) ([]image.Point, error) {
+	var sizes []image.Point
+
+	for _, field := range strings.Fields(val) {
+		size, err := imaging.ParseSize(field)
+		if err != nil {
+			return nil, err
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
+func (app *application) readImageKindParam(r *http.Request, kinds []string) (string, error) {
+	kind := httprouter.ParamsFromContext(r.Context()).ByName("kind")
+	if !slices.Contains(kinds, kind) {
+		return "", errors.New("invalid kind parameter")
+	}
+
+	return kind, nil
+}
+
+// readImageFileParam parses the WxH.jpg file name of a rendition.
+func (app *application) readImageFileParam(r *http.Request) (image.Point, error) {
+	name, ok := strings.CutSuffix(httprouter.ParamsFromContext(r.Context()).ByName("file"), ".jpg")
+	if !ok {
+		return image.Point{}, errors.New("invalid file parameter")
+	}
+
+	return imaging.ParseSize(name)
+}
+
+func (app *application) imageURL(img *data.VideoImage) string {
+	if app.config.images.baseURL != "" {
+		return strings.TrimRight(app.config.images.baseURL, "/") + "/" + img.StorageKey
+	}
+
+	return fmt.Sprintf("/v1/videos/%s/images/%s/%dx%d.jpg", img.VideoID, img.Kind, img.Width, img.Height)
+}
+
+func (app *application) showImageURL(img *data.ShowImage) string {
+	if app.config.images.baseURL != "" {
+		return strings.TrimRight(app.config.images.baseURL, "/") + "/" + img.StorageKey
+	}
+
+	return fmt.Sprintf("/v1/shows/%d/images/%s/%dx%d.jpg", img.ShowID, img.Kind, img.Width, img.Height)
+}
+
+// setThumbnails fills in the Thumbnails of each video with one query.
+func (app *application) setThumbnails(ctx context.Context, videos ...*data.Video) error {
+	if len(videos) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(videos))
+	for i, video := range videos {
+		ids[i] = video.VideoID
+	}
+
+	images, err := app.models.VideoImages.GetAllForVideos(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byVideo := make(map[string]map[string][]data.Thumbnail)
+
+	for _, img := range images {
+		if byVideo[img.VideoID] == nil {
+			byVideo[img.VideoID] = make(map[string][]data.Thumbnail)
+		}
+
+		byVideo[img.VideoID][img.Kind] = append(byVideo[img.VideoID][img.Kind], data.Thumbnail{
+			Width:  img.Width,
+			Height: img.Height,
+			URL:    app.imageURL(img),
+		})
+	}
+
+	for _, video := range videos {
+		video.Thumbnails = byVideo[video.VideoID]
+	}
+
+	return nil
+}
+
+// setShowThumbnails fills in the Thumbnails of each show with one query.
+func (app *application) setShowThumbnails(ctx context.Context, shows ...*data.Show) error {
+	if len(shows) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(shows))
+	for i, show := range shows {
+		ids[i] = show.ID
+	}
+
+	images, err := app.models.ShowImages.GetAllForShows(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byShow := make(map[int64]map[string][]data.Thumbnail)
+
+	for _, img := range images {
+		if byShow[img.ShowID] == nil {
+			byShow[img.ShowID] = make(map[string][]data.Thumbnail)
+		}
+
+		byShow[img.ShowID][img.Kind] = append(byShow[img.ShowID][img.Kind], data.Thumbnail{
+			Width:  img.Width,
+			Height: img.Height,
+			URL:    app.showImageURL(img),
+		})
+	}
+
+	for _, show := range shows {
+		show.Thumbnails = byShow[show.ID]
+	}
+
+	return nil
+}
+
+// readImageUpload reads the source image from the request body and renders
+// it into each configured rendition of kind that it is large enough for,
+// storing them under prefix. If it returns false, it has already sent an
+// error response.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request, prefix, kind string) ([]*data.ImageRendition, bool) {
+	if !slices.Contains(imageMediaTypes, requestMediaType(r)) {
+		app.unsupportedImageTypeResponse(w, r)
+		return nil, false
+	}
+
+	err := app.extendDeadlines(w)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, app.config.images.maxSize))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.contentTooLargeResponse(w, r, maxBytesError.Limit)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	v := validator.New()
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("image", "must be a valid JPEG, PNG or GIF image")
+		app.failedValidationResponse(w, r, v)
+		return nil, false
+	}
+
+	if v.Check(cfg.Width*cfg.Height <= maxImagePixels, "image", fmt.Sprintf("must not have more than %d pixels", maxImagePixels)); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return nil, false
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("image", "must be a valid JPEG, PNG or GIF image")
+		app.failedValidationResponse(w, r, v)
+		return nil, false
+	}
+
+	images, err := app.renderImage(r.Context(), prefix, kind, src)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if len(images) == 0 {
+		smallest := slices.MinFunc(app.config.images.sizes[kind], func(a, b image.Point) int {
+			return a.X*a.Y - b.X*b.Y
+		})
+
+		v.AddError("image", fmt.Sprintf("must be at least %dx%d pixels", smallest.X, smallest.Y))
+		app.failedValidationResponse(w, r, v)
+		return nil, false
+	}
+
+	return images, true
+}
+
+// renderImage produces a JPEG rendition of src for every configured size of
+// kind that src is large enough for, and stores them under prefix. On error,
+// any renditions already stored are removed again.
+func (app *application) renderImage(ctx context.Context, prefix, kind string, src image.Image) ([]*data.ImageRendition, error) {
+	token := rand.Text()
+
+	var images []*data.ImageRendition
+
+	for _, size := range app.config.images.sizes[kind] {
+		if !imaging.Covers(src.Bounds().Size(), size) {
+			continue
+		}
+
+		img := &data.ImageRendition{
+			Kind:       kind,
+			Width:      size.X,
+			Height:     size.Y,
+			StorageKey: fmt.Sprintf("%s/images/%s/%s/%dx%d.jpg", prefix, kind, token, size.X, size.Y),
+		}
+
+		err := app.storeRendition(ctx, img, src)
+		if err != nil {
+			app.deleteBlobs(imageKeys(images)...)
+			return nil, err
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+func (app *application) storeRendition(ctx context.Context, img *data.ImageRendition, src image.Image) error {
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, imaging.Fill(src, image.Pt(img.Width, img.Height)), &jpeg.Options{Quality: imageJPEGQuality})
+	if err != nil {
+		return err
+	}
+
+	img.Size = int64(buf.Len())
+
+	return app.blobs.Put(ctx, img.StorageKey, &buf, img.Size, "image/jpeg")
+}
+
+func imageKeys(images []*data.ImageRendition) []string {
+	keys := make([]string, len(images))
+	for i, img := range images {
+		keys[i] = img.StorageKey
+	}
+	return keys
+}
+
+// uploadImageHandler replaces one kind of image of a video. The request body
+// is the source image; it is resized and cropped into each configured
+// rendition that it is large enough for.
+func (app *application) uploadImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	kind, err := app.readImageKindParam(r, data.ImageKinds)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	video, err := app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, ok := app.readImageUpload(w, r, "videos/"+id, kind)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	oldKeys, err := app.replaceImages(ctx, id, kind, images)
+	if err != nil {
+		app.deleteBlobs(imageKeys(images)...)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.deleteBlobs(oldKeys...)
+
+	err = app.setThumbnails(ctx, video)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"video": video}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceImages swaps the renditions of one kind of image of a video in a
+// transaction and returns the storage keys of the ones it replaced.
+func (app *application) replaceImages(ctx context.Context, videoID, kind string, images []*data.ImageRendition) ([]string, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	oldKeys, err := models.VideoImages.DeleteKind(ctx, videoID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		err = models.VideoImages.Insert(ctx, &data.VideoImage{VideoID: videoID, ImageRendition: *img})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oldKeys, tx.Commit()
+}
+
+func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	kind, err := app.readImageKindParam(r, data.ImageKinds)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	keys, err := app.models.VideoImages.DeleteKind(r.Context(), id, kind)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(keys) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.deleteBlobs(keys...)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showImageHandler serves a rendition of an image of a video from the blob
+// store. It is what the thumbnail URLs point at unless -image-base-url sends
+// clients straight to a CDN or bucket instead.
+func (app *application) showImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	kind, err := app.readImageKindParam(r, data.ImageKinds)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	size, err := app.readImageFileParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	img, err := app.models.VideoImages.Get(r.Context(), id, kind, size.X, size.Y)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.serveImage(w, r, &img.ImageRendition)
+}
+
+// serveImage writes a stored rendition, answering conditional requests with
+// 304 Not Modified.
+func (app *application) serveImage(w http.ResponseWriter, r *http.Request, img *data.ImageRendition) {
+	// Every upload gets new storage keys, so the key identifies the content.
+	sum := sha256.Sum256([]byte(img.StorageKey))
+	etag := fmt.Sprintf(`"%x"`, sum[:16])
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := app.blobs.Get(r.Context(), img.StorageKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", fmt.Sprint(img.Size))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/provider"
+	"github.com/JLL32/thmanyah/internal/validator"
+)
+
+// maxImportVideos bounds the videos imported by one request.
+const maxImportVideos = 50
+
+const (
+	importStatusCreated   = "created"
+	importStatusUpdated   = "updated"
+	importStatusUnchanged = "unchanged"
+	importStatusNotFound  = "not_found"
+	importStatusInvalid   = "invalid"
+)
+
+// importResult reports what importing one video did.
+type importResult struct {
+	VideoID string            `json:"video_id"`
+	Status  string            `json:"status"`
+	Changes []string          `json:"changes,omitempty"`
+	Video   *data.Video       `json:"video,omitempty"`
+	Error   map[string]string `json:"error,omitempty"`
+}
+
+// importOptions are the values of new videos that providers don't supply.
+type importOptions struct {
+	Type string
+	// Language is used for videos whose provider doesn't declare a language
+	// we can store.
+	Language string
+	// DryRun rolls back the write, so the result tells what importing
+	// would do without changing the video.
+	DryRun bool
+}
+
+// providerLanguage maps a BCP 47 tag from a provider to the ISO 639-1 code
+// stored on videos, or "" if it has none.
+func providerLanguage(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	primary = strings.ToLower(primary)
+
+	if !validator.ISO6391(primary) {
+		return ""
+	}
+
+	return primary
+}
+
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+
+	return s
+}
+
+// applyProviderVideo copies the metadata of pv onto video and returns the
+// names of the fields that changed. The type and, when pv has none, the
+// language of video are left alone.
+func applyProviderVideo(video *data.Video, pv *provider.Video) []string {
+	var changes []string
+
+	set := func(field string, changed bool) {
+		if changed {
+			changes = append(changes, field)
+		}
+	}
+
+	title := truncateRunes(strings.TrimSpace(pv.Title), 500)
+	// Descriptions are optional on most platforms but required here.
+	description := truncateRunes(cmp.Or(strings.TrimSpace(pv.Description), title), 5000)
+	length := int(pv.Duration.Round(time.Second).Seconds())
+	language := cmp.Or(providerLanguage(pv.Language), video.Language)
+	// The database stores published_at to the second.
+	publishedAt := pv.PublishedAt.Round(time.Second)
+
+	set("title", video.Title != title)
+	set("description", video.Description != description)
+	set("length", video.Length != length)
+	set("language", video.Language != language)
+	set("published_at", !video.PublishedAt.Equal(publishedAt))
+
+	video.Title = title
+	video.Description = description
+	video.Length = length
+	video.Language = language
+	video.PublishedAt = publishedAt
+
+	return changes
+}
+
+// importVideo creates the video pv describes, or refreshes it through the
+// versioned update if it already exists. Client errors, such as metadata that
+// doesn't pass validation, are reported in the result; the returned error is
+// only set for unexpected failures.
+func (app *application) importVideo(ctx context.Context, pv *provider.Video, opts importOptions) (importResult, error) {
+	res := importResult{VideoID: pv.ID}
+
+	v := validator.New()
+
+	if data.ValidateVideoID(v, pv.ID); !v.Valid() {
+		res.Status = importStatusInvalid
+		res.Error = v.Errors
+		return res, nil
+	}
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	video, err := models.Videos.GetForUpdate(ctx, pv.ID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		video = &data.Video{VideoID: pv.ID, Type: opts.Type, Language: opts.Language}
+		res.Status = importStatusCreated
+	case err != nil:
+		return res, err
+	default:
+		res.Status = importStatusUpdated
+	}
+
+	res.Changes = applyProviderVideo(video, pv)
+
+	if res.Status == importStatusUpdated && len(res.Changes) == 0 {
+		res.Status = importStatusUnchanged
+		res.Video = video
+		return res, nil
+	}
+
+	if data.ValidateVideo(v, video); !v.Valid() {
+		res.Status = importStatusInvalid
+		res.Changes = nil
+		res.Error = v.Errors
+		return res, nil
+	}
+
+	if res.Status == importStatusCreated {
+		err = models.Videos.Insert(ctx, video)
+	} else {
+		err = models.Videos.Update(ctx, video)
+	}
+	if err != nil {
+		var fieldErr *data.FieldError
+
+		switch {
+		case errors.As(err, &fieldErr):
+			res.Status = importStatusInvalid
+			res.Changes = nil
+			res.Error = map[string]string{fieldErr.Field: fieldErr.Message}
+			return res, nil
+		default:
+			return res, err
+		}
+	}
+
+	res.Video = video
+
+	if opts.DryRun {
+		return res, nil
+	}
+
+	return res, tx.Commit()
+}
+
+// importYouTubeHandler creates or refreshes videos from their YouTube
+// metadata. The YouTube video ID becomes the video_id.
+func (app *application) importYouTubeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		VideoIDs []string `json:"video_ids"`
+		Type     string   `json:"type"`
+		Language string   `json:"language"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.VideoIDs) > 0, "video_ids", "must contain at least one video ID")
+	v.Check(len(input.VideoIDs) <= maxImportVideos, "video_ids", fmt.Sprintf("must not contain more than %d video IDs", maxImportVideos))
+	v.Check(validator.Unique(input.VideoIDs), "video_ids", "must not contain duplicate values")
+
+	for i, id := range input.VideoIDs {
+		v.Check(validator.Matches(id, validator.VideoIDRX), fmt.Sprintf("video_ids[%d]", i), "must be 11 characters long and contain only letters, digits, '-' and '_'")
+	}
+
+	v.Check(validator.PermittedValue(input.Type, data.VideoTypes...), "type", "must be one of: "+strings.Join(data.VideoTypes, ", "))
+	v.Check(input.Language == "" || validator.ISO6391(input.Language), "language", "must be a lowercase ISO 639-1 language code")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	ctx := r.Context()
+
+	fetched, err := app.metadataProvider.Videos(ctx, input.VideoIDs)
+	if err != nil {
+		app.providerErrorResponse(w, r, err)
+		return
+	}
+
+	byID := make(map[string]*provider.Video, len(fetched))
+	for _, pv := range fetched {
+		byID[pv.ID] = pv
+	}
+
+	opts := importOptions{Type: input.Type, Language: input.Language}
+
+	results := make([]importResult, len(input.VideoIDs))
+	var videos []*data.Video
+
+	for i, id := range input.VideoIDs {
+		pv, ok := byID[id]
+		if !ok {
+			results[i] = importResult{VideoID: id, Status: importStatusNotFound}
+			continue
+		}
+
+		results[i], err = app.importVideo(ctx, pv, opts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if results[i].Video != nil {
+			videos = append(videos, results[i].Video)
+		}
+	}
+
+	err = app.setThumbnails(ctx, videos...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/provider"
+)
+
+func TestApplyProviderVideo(t *testing.T) {
+	publishedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	stored := data.Video{
+		VideoID:     "dQw4w9WgXcQ",
+		Title:       "Episode 1",
+		Description: "The first episode",
+		Type:        "documentary",
+		Length:      90,
+		Language:    "ar",
+		PublishedAt: publishedAt,
+	}
+
+	tests := []struct {
+		name string
+		pv   provider.Video
+		want []string
+		// check, if set, verifies the updated video.
+		check func(t *testing.T, video *data.Video)
+	}{
+		{
+			name: "unchanged",
+			pv: provider.Video{
+				Title:       "Episode 1",
+				Description: "The first episode",
+				Duration:    90 * time.Second,
+				Language:    "ar",
+				PublishedAt: publishedAt,
+			},
+		},
+		{
+			name: "rounding and whitespace are not changes",
+			pv: provider.Video{
+				Title:       "  Episode 1\n",
+				Description: "The first episode ",
+				Duration:    89600 * time.Millisecond,
+				Language:    "ar-SA",
+				PublishedAt: publishedAt.Add(300 * time.Millisecond),
+			},
+		},
+		{
+			name: "every field changed",
+			pv: provider.Video{
+				Title:       "Episode 2",
+				Description: "The second episode",
+				Duration:    time.Hour,
+				Language:    "EN-us",
+				PublishedAt: publishedAt.Add(time.Hour),
+			},
+			want: []string{"title", "description", "length", "language", "published_at"},
+			check: func(t *testing.T, video *data.Video) {
+				if video.Title != "Episode 2" || video.Description != "The second episode" || video.Length != 3600 ||
+					video.Language != "en" || !video.PublishedAt.Equal(publishedAt.Add(time.Hour)) {
+					t.Errorf("got %+v", video)
+				}
+				if video.Type != "documentary" {
+					t.Errorf("got type %q; want it left alone", video.Type)
+				}
+			},
+		},
+		{
+			name: "no language keeps the stored one",
+			pv: provider.Video{
+				Title:       "Episode 1",
+				Description: "The first episode",
+				Duration:    90 * time.Second,
+				Language:    "zxx-unknown",
+				PublishedAt: publishedAt,
+			},
+		},
+		{
+			name: "missing description falls back to the title",
+			pv: provider.Video{
+				Title:       "Episode 1",
+				Duration:    90 * time.Second,
+				PublishedAt: publishedAt,
+			},
+			want: []string{"description"},
+			check: func(t *testing.T, video *data.Video) {
+				if video.Description != "Episode 1" {
+					t.Errorf("got description %q; want the title", video.Description)
+				}
+			},
+		},
+		{
+			name: "long title is truncated",
+			pv: provider.Video{
+				Title:       strings.Repeat("ح", 600),
+				Description: "The first episode",
+				Duration:    90 * time.Second,
+				PublishedAt: publishedAt,
+			},
+			want: []string{"title"},
+			check: func(t *testing.T, video *data.Video) {
+				if video.Title != strings.Repeat("ح", 500) {
+					t.Errorf("got a title of %d characters; want 500", len([]rune(video.Title)))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := stored
+
+			got := applyProviderVideo(&video, &tt.pv)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got changes %v; want %v", got, tt.want)
+			}
+
+			if tt.check != nil {
+				tt.check(t, &video)
+			}
+		})
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/transcode"
+)
+
+// startJobWorkers starts n workers that run queued jobs until the background
+// context is cancelled.
+func (app *application) startJobWorkers(n int) {
+	for i := range n {
+		app.background(func() {
+			app.runJobWorker(i)
+		})
+	}
+}
+
+func (app *application) runJobWorker(worker int) {
+	logger := app.logger.With("worker", worker)
+
+	for {
+		job, err := app.models.Jobs.Claim(app.backgroundCtx, app.config.jobs.lease)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) && app.backgroundCtx.Err() == nil {
+				logger.Error("claiming job", "error", err)
+			}
+
+			select {
+			case <-app.backgroundCtx.Done():
+				return
+			case <-time.After(app.config.jobs.pollInterval):
+				continue
+			}
+		}
+
+		app.processJob(logger.With("job_id", job.ID, "kind", job.Kind, "video_id", job.VideoID), job)
+	}
+}
+
+// processJob runs a claimed job, renewing its lease while it runs, and records
+// the outcome. A job interrupted by shutdown goes back in the queue without
+// counting as an attempt.
+func (app *application) processJob(logger *slog.Logger, job *data.Job) {
+	ctx, cancel := context.WithCancel(app.backgroundCtx)
+	defer cancel()
+
+	// The outcome must be recorded even when the job was interrupted by
+	// shutdown.
+	recordCtx := context.WithoutCancel(ctx)
+
+	go func() {
+		ticker := time.NewTicker(app.config.jobs.lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := app.models.Jobs.Extend(ctx, job, app.config.jobs.lease)
+				if errors.Is(err, data.ErrEditConflict) {
+					logger.Error("lost lease on job")
+					cancel()
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					logger.Error("extending job lease", "error", err)
+				}
+			}
+		}
+	}()
+
+	logger.Info("job started", "attempt", job.Attempts)
+	start := time.Now()
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// The lease of the last attempt ran out, so its worker died.
+		err = errors.New("worker stopped during the last attempt")
+	} else {
+		err = app.runJob(ctx, job)
+	}
+
+	switch {
+	case err == nil:
+		err = app.models.Jobs.Complete(recordCtx, job)
+		if err != nil {
+			logger.Error("completing job", "error", err)
+			return
+		}
+		logger.Info("job succeeded", "duration", time.Since(start))
+
+	case app.backgroundCtx.Err() != nil:
+		err = app.models.Jobs.Release(recordCtx, job)
+		if err != nil {
+			logger.Error("releasing job", "error", err)
+			return
+		}
+		logger.Info("job released for shutdown")
+
+	default:
+		// Back off quadratically: 30s, 2m, 4.5m, ...
+		retryAt := time.Now().Add(time.Duration(job.Attempts*job.Attempts) * 30 * time.Second)
+
+		failErr := app.models.Jobs.Fail(recordCtx, job, err, retryAt)
+		if failErr != nil {
+			logger.Error("failing job", "error", failErr)
+			return
+		}
+		logger.Error("job failed", "error", err, "duration", time.Since(start))
+	}
+}
+
+// runJob dispatches a job to the function for its kind. A panic fails the job
+// instead of killing the worker.
+func (app *application) runJob(ctx context.Context, job *data.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	switch job.Kind {
+	case data.JobKindTranscode:
+		return app.transcodeJob(ctx, job)
+	case data.JobKindProbe:
+		return app.probeJob(ctx, job)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+// enqueueAssetJobs queues the jobs that process a completed video or audio
+// asset: probing its metadata and transcoding it.
+func enqueueAssetJobs(ctx context.Context, jobs data.JobModel, asset *data.MediaAsset) error {
+	payload, err := json.Marshal(data.AssetPayload{AssetID: asset.ID})
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []string{data.JobKindProbe, data.JobKindTranscode} {
+		err = jobs.Enqueue(ctx, &data.Job{
+			VideoID: asset.VideoID,
+			Kind:    kind,
+			Payload: payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readJobAsset returns the complete asset that a job's payload refers to.
+func (app *application) readJobAsset(ctx context.Context, job *data.Job) (*data.MediaAsset, error) {
+	var payload data.AssetPayload
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("decoding payload: %w", err)
+	}
+
+	asset, err := app.models.MediaAssets.Get(ctx, job.VideoID, payload.AssetID)
+	if err != nil {
+		return nil, fmt.Errorf("loading asset %d: %w", payload.AssetID, err)
+	}
+
+	if asset.Status != data.AssetStatusComplete {
+		return nil, fmt.Errorf("asset %d has not been fully uploaded", asset.ID)
+	}
+
+	return asset, nil
+}
+
+// downloadAsset copies the file of an asset into dir and returns its path.
+func (app *application) downloadAsset(ctx context.Context, asset *data.MediaAsset, dir string) (string, error) {
+	input := filepath.Join(dir, "input"+filepath.Ext(asset.Filename))
+
+	err := app.downloadBlob(ctx, asset.StorageKey, input)
+	if err != nil {
+		return "", fmt.Errorf("downloading asset: %w", err)
+	}
+
+	return input, nil
+}
+
+// transcodeJob produces HLS renditions of an asset and replaces the video's
+// current renditions with them.
+func (app *application) transcodeJob(ctx context.Context, job *data.Job) error {
+	asset, err := app.readJobAsset(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "transcode-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	input, err := app.downloadAsset(ctx, asset, dir)
+	if err != nil {
+		return err
+	}
+
+	outputDir := filepath.Join(dir, "output")
+
+	err = os.Mkdir(outputDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	req := transcode.Request{
+		Input:     input,
+		OutputDir: outputDir,
+		AudioOnly: asset.Kind == "audio",
+	}
+
+	if !req.AudioOnly {
+		// The ladder is fitted to the source's frame, which the probe job
+		// may not have stored yet.
+		probed, err := app.prober.Probe(ctx, input)
+		if err != nil {
+			return fmt.Errorf("probing asset: %w", err)
+		}
+
+		req.SourceWidth, req.SourceHeight = probed.Width, probed.Height
+
+		// A video upload without a video stream plays as audio.
+		req.AudioOnly = probed.Width == 0 || probed.Height == 0
+	}
+
+	outputs, err := app.transcoder.Transcode(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// Each attempt uploads under its own prefix, so an attempt that lost its
+	// lease can't overwrite, or later delete, what its successor uploaded.
+	prefix := fmt.Sprintf("videos/%s/hls/%d/%d", job.VideoID, job.ID, job.Attempts)
+
+	var renditions []*data.Rendition
+
+	for _, out := range outputs {
+		rendition := &data.Rendition{
+			VideoID:     job.VideoID,
+			Name:        out.Name,
+			AssetID:     asset.ID,
+			JobID:       job.ID,
+			Width:       out.Width,
+			Height:      out.Height,
+			Bandwidth:   out.Bandwidth,
+			Codecs:      out.Codecs,
+			PlaylistKey: path.Join(prefix, out.Dir, out.Playlist),
+		}
+
+		rendition.BlobKeys, err = app.uploadDir(ctx, filepath.Join(outputDir, out.Dir), path.Join(prefix, out.Dir))
+		renditions = append(renditions, rendition)
+
+		if err != nil {
+			app.deleteBlobs(renditionKeys(renditions)...)
+			return fmt.Errorf("uploading rendition %s: %w", out.Name, err)
+		}
+	}
+
+	oldKeys, err := app.replaceRenditions(ctx, job, asset, renditions)
+	if err != nil {
+		app.deleteBlobs(renditionKeys(renditions)...)
+
+		if errors.Is(err, errAssetSuperseded) {
+			app.logger.Info("discarding renditions of a superseded asset", "job_id", job.ID, "video_id", job.VideoID, "asset_id", asset.ID)
+			return nil
+		}
+
+		return err
+	}
+
+	app.deleteBlobs(oldKeys...)
+
+	return nil
+}
+
+// probeJob stores the metadata of an asset and, with -probe-update-length,
+// sets the length of its video to the probed duration.
+func (app *application) probeJob(ctx context.Context, job *data.Job) error {
+	asset, err := app.readJobAsset(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	input, err := app.downloadAsset(ctx, asset, dir)
+	if err != nil {
+		return err
+	}
+
+	probed, err := app.prober.Probe(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	md := &data.MediaMetadata{
+		DurationMS:    probed.Duration.Milliseconds(),
+		Width:         probed.Width,
+		Height:        probed.Height,
+		VideoCodec:    probed.VideoCodec,
+		AudioCodec:    probed.AudioCodec,
+		AudioLanguage: probed.AudioLanguage,
+	}
+
+	err = app.models.MediaAssets.SetMetadata(ctx, asset, md)
+	if err != nil {
+		return err
+	}
+
+	if !app.config.probe.updateLength || probed.Duration <= 0 {
+		return nil
+	}
+
+	return app.setVideoLength(ctx, asset, max(1, int(probed.Duration.Round(time.Second).Seconds())))
+}
+
+// errAssetSuperseded is returned when the results of a job about an asset are
+// about to be saved but a newer asset of the video has been uploaded, whose
+// own jobs take precedence.
+var errAssetSuperseded = errors.New("a newer asset of the video has been uploaded")
+
+// checkLatestAsset locks the video of an asset and returns errAssetSuperseded
+// unless the asset is the video's newest complete one. Holding the lock
+// until the end of the transaction orders it against the jobs of a newer
+// asset, which lock the video too. models must be returned by WithTx.
+func checkLatestAsset(ctx context.Context, models data.Models, asset *data.MediaAsset) error {
+	_, err := models.Videos.GetForUpdate(ctx, asset.VideoID)
+	if err != nil {
+		return err
+	}
+
+	latest, err := models.MediaAssets.GetLatestComplete(ctx, asset.VideoID)
+	if err != nil {
+		return err
+	}
+
+	if latest.ID != asset.ID {
+		return errAssetSuperseded
+	}
+
+	return nil
+}
+
+// setVideoLength sets the length of the video of an asset, in seconds,
+// through the same versioned update that API clients use, retrying if an
+// editor saves the video in between. The length is left as it is if it would
+// cut off a chapter, or if a newer asset of the video has been uploaded.
+func (app *application) setVideoLength(ctx context.Context, asset *data.MediaAsset, length int) error {
+	id := asset.VideoID
+
+	for range 3 {
+		video, err := app.models.Videos.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if video.Length == length {
+			return nil
+		}
+
+		video.Length = length
+
+		err = app.updateVideoLength(ctx, asset, video)
+
+		if errors.Is(err, errAssetSuperseded) {
+			app.logger.Info("not updating video length from a superseded asset", "video_id", id, "asset_id", asset.ID)
+			return nil
+		}
+
+		var fieldErr *data.FieldError
+		if errors.As(err, &fieldErr) {
+			// Retrying won't make the file any longer, so keep the length
+			// an editor set for the chapters.
+			app.logger.Warn("not updating video length", "video_id", id, "length", length, "error", fieldErr)
+			return nil
+		}
+
+		if !errors.Is(err, data.ErrEditConflict) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("updating length of video %s: %w", id, data.ErrEditConflict)
+}
+
+// updateVideoLength saves a video whose length was set from an asset, in a
+// transaction that first checks the asset hasn't been superseded.
+func (app *application) updateVideoLength(ctx context.Context, asset *data.MediaAsset, video *data.Video) error {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	err = checkLatestAsset(ctx, models, asset)
+	if err != nil {
+		return err
+	}
+
+	err = models.Videos.Update(ctx, video)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func renditionKeys(renditions []*data.Rendition) []string {
+	var keys []string
+	for _, rendition := range renditions {
+		keys = append(keys, rendition.BlobKeys...)
+	}
+	return keys
+}
+
+// replaceRenditions swaps the renditions of a video for those a transcode job
+// made of asset, in a transaction, and returns the blob keys of the ones it
+// replaced. It returns ErrEditConflict if the job's claim has been taken over,
+// and errAssetSuperseded if a newer asset of the video has been uploaded.
+func (app *application) replaceRenditions(ctx context.Context, job *data.Job, asset *data.MediaAsset, renditions []*data.Rendition) ([]string, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	err = models.Jobs.Hold(ctx, job)
+	if err != nil {
+		return nil, fmt.Errorf("saving renditions: %w", err)
+	}
+
+	err = checkLatestAsset(ctx, models, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	oldKeys, err := models.Renditions.DeleteAllForVideo(ctx, job.VideoID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rendition := range renditions {
+		err = models.Renditions.Insert(ctx, rendition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oldKeys, tx.Commit()
+}
+
+func (app *application) downloadBlob(ctx context.Context, key, dst string) error {
+	blob, err := app.blobs.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, blob)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// uploadDir stores every file in dir under prefix and returns their keys,
+// including those stored before an error.
+func (app *application) uploadDir(ctx context.Context, dir, prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		key := path.Join(prefix, filepath.ToSlash(rel))
+
+		err = app.blobs.Put(ctx, key, f, info.Size(), transcode.ContentType(name))
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+		return nil
+	})
+
+	return keys, err
+}
+
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	jobs, err := app.models.Jobs.GetAllForVideo(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"jobs": jobs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
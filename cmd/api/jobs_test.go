@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/probe"
+	"github.com/JLL32/thmanyah/internal/storage"
+	"github.com/JLL32/thmanyah/internal/transcode"
+)
+
+// newTestWorkerApplication returns an application with a database, a local
+// blob store and a fake transcoder, and a video with a complete asset whose
+// transcode job has been queued.
+func newTestWorkerApplication(t *testing.T) (*application, *transcode.Fake, *data.Job) {
+	t.Helper()
+
+	app := newTestDBApplication(t)
+	ctx := context.Background()
+
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &transcode.Fake{Segments: 2}
+
+	app.blobs = blobs
+	app.transcoder = fake
+	app.prober = &probe.Fake{}
+	app.config.jobs.lease = time.Minute
+	app.config.jobs.pollInterval = 10 * time.Millisecond
+
+	video := insertTestVideo(t, app, 600)
+	asset := insertTestAsset(t, app, video.VideoID)
+
+	payload, err := json.Marshal(data.AssetPayload{AssetID: asset.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &data.Job{VideoID: video.VideoID, Kind: data.JobKindTranscode, Payload: payload}
+
+	err = app.models.Jobs.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, fake, job
+}
+
+// insertTestAsset inserts a complete video asset and, if the application has
+// a blob store, stores its file.
+func insertTestAsset(t *testing.T, app *application, videoID string) *data.MediaAsset {
+	t.Helper()
+
+	ctx := context.Background()
+
+	body := []byte("fake media")
+	sum := sha256.Sum256(body)
+
+	asset := &data.MediaAsset{
+		VideoID:     videoID,
+		Kind:        "video",
+		Filename:    "episode.mp4",
+		ContentType: "video/mp4",
+		Size:        int64(len(body)),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+
+	err := app.models.MediaAssets.Insert(ctx, asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if app.blobs != nil {
+		err = app.blobs.Put(ctx, asset.StorageKey, bytes.NewReader(body), asset.Size, asset.ContentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	asset.Status = data.AssetStatusComplete
+	asset.UploadedBytes = asset.Size
+
+	err = app.models.MediaAssets.Update(ctx, asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return asset
+}
+
+// getTestJob returns the stored state of a job.
+func getTestJob(t *testing.T, app *application, job *data.Job) *data.Job {
+	t.Helper()
+
+	jobs, err := app.models.Jobs.GetAllForVideo(context.Background(), job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range jobs {
+		if j.ID == job.ID {
+			return j
+		}
+	}
+
+	t.Fatalf("job %d not found", job.ID)
+	return nil
+}
+
+// claimTestJob claims the next job, which must be want.
+func claimTestJob(t *testing.T, app *application, want *data.Job, lease time.Duration) *data.Job {
+	t.Helper()
+
+	job, err := app.models.Jobs.Claim(context.Background(), lease)
+	if err != nil {
+		t.Fatalf("claiming job %d: %v", want.ID, err)
+	}
+	if job.ID != want.ID {
+		t.Fatalf("claimed job %d; want %d", job.ID, want.ID)
+	}
+
+	return job
+}
+
+func assertNoJobDue(t *testing.T, app *application) {
+	t.Helper()
+
+	job, err := app.models.Jobs.Claim(context.Background(), time.Minute)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Fatalf("got job %+v, error %v; want no job due", job, err)
+	}
+}
+
+func TestProcessJobTranscodes(t *testing.T) {
+	app, fake, queued := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	job := claimTestJob(t, app, queued, app.config.jobs.lease)
+
+	if job.Status != data.JobStatusRunning || job.Attempts != 1 {
+		t.Fatalf("got claimed job %s after %d attempts; want running after 1", job.Status, job.Attempts)
+	}
+
+	// A claimed job isn't handed to another worker.
+	assertNoJobDue(t, app)
+
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusSucceeded || stored.FinishedAt == nil {
+		t.Fatalf("got job %s, finished at %v; want it succeeded", stored.Status, stored.FinishedAt)
+	}
+
+	if requests := fake.Requests(); len(requests) != 1 || requests[0].AudioOnly {
+		t.Fatalf("got transcode requests %+v; want one for video", requests)
+	}
+
+	renditions, err := app.models.Renditions.GetAllForVideo(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) == 0 {
+		t.Fatal("got no renditions")
+	}
+
+	if requests := fake.Requests(); requests[0].SourceWidth != 1920 || requests[0].SourceHeight != 1080 {
+		t.Errorf("got source size %dx%d; want the probed 1920x1080", requests[0].SourceWidth, requests[0].SourceHeight)
+	}
+
+	prefix := fmt.Sprintf("videos/%s/hls/%d/1/", job.VideoID, job.ID)
+
+	for _, rendition := range renditions {
+		if !strings.HasPrefix(rendition.PlaylistKey, prefix) {
+			t.Errorf("rendition %s is stored at %s; want it under the attempt's prefix %s", rendition.Name, rendition.PlaylistKey, prefix)
+		}
+
+		if rendition.JobID != job.ID {
+			t.Errorf("rendition %s is from job %d; want %d", rendition.Name, rendition.JobID, job.ID)
+		}
+
+		// A playlist and two segments.
+		if len(rendition.BlobKeys) != 3 {
+			t.Errorf("rendition %s has blobs %v; want 3", rendition.Name, rendition.BlobKeys)
+		}
+
+		for _, key := range rendition.BlobKeys {
+			blob, err := app.blobs.Get(ctx, key)
+			if err != nil {
+				t.Errorf("rendition %s: %v", rendition.Name, err)
+				continue
+			}
+			blob.Close()
+		}
+	}
+}
+
+func TestProcessJobRetriesFailures(t *testing.T) {
+	app, fake, queued := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	fake.Err = errors.New("exit status 1: Invalid data found when processing input")
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		job := claimTestJob(t, app, queued, app.config.jobs.lease)
+		if job.Attempts != attempt {
+			t.Fatalf("got attempt %d; want %d", job.Attempts, attempt)
+		}
+
+		start := time.Now()
+		app.processJob(app.logger, job)
+
+		stored := getTestJob(t, app, job)
+		if !strings.Contains(stored.Error, "Invalid data found") {
+			t.Errorf("attempt %d: got error %q", attempt, stored.Error)
+		}
+
+		if attempt == 3 {
+			if stored.Status != data.JobStatusFailed || stored.FinishedAt == nil {
+				t.Fatalf("got job %s, finished at %v after the last attempt; want it failed", stored.Status, stored.FinishedAt)
+			}
+			break
+		}
+
+		if stored.Status != data.JobStatusQueued {
+			t.Fatalf("attempt %d: got job %s; want it queued again", attempt, stored.Status)
+		}
+
+		// Retries back off quadratically: 30s, then 2m.
+		backoff := time.Duration(attempt*attempt) * 30 * time.Second
+		if stored.RunAt.Before(start.Add(backoff - time.Second)) {
+			t.Errorf("attempt %d: retry due at %v; want %v after %v", attempt, stored.RunAt, backoff, start)
+		}
+
+		assertNoJobDue(t, app)
+
+		_, err := app.db.ExecContext(ctx, "UPDATE jobs SET run_at = NOW() WHERE id = $1", job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertNoJobDue(t, app)
+
+	if requests := fake.Requests(); len(requests) != 3 {
+		t.Errorf("transcoded %d times; want 3", len(requests))
+	}
+}
+
+func TestClaimTakesOverExpiredLease(t *testing.T) {
+	app, fake, queued := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	// A lease in the past stands in for a worker that died mid-run.
+	dead := claimTestJob(t, app, queued, -time.Second)
+
+	job := claimTestJob(t, app, queued, app.config.jobs.lease)
+	if job.Attempts != 2 {
+		t.Fatalf("got attempt %d; want 2", job.Attempts)
+	}
+
+	// The first claim no longer holds the job.
+	err := app.models.Jobs.Extend(ctx, dead, app.config.jobs.lease)
+	if !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("extending the expired claim: got %v; want ErrEditConflict", err)
+	}
+
+	err = app.models.Jobs.Complete(ctx, dead)
+	if !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("completing the expired claim: got %v; want ErrEditConflict", err)
+	}
+
+	// The first attempt finishing late doesn't save its renditions.
+	app.processJob(app.logger, dead)
+
+	renditions, err := app.models.Renditions.GetAllForVideo(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != 0 {
+		t.Fatalf("got %d renditions from the expired claim; want none", len(renditions))
+	}
+
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusSucceeded || stored.Attempts != 2 {
+		t.Fatalf("got job %s after %d attempts; want succeeded after 2", stored.Status, stored.Attempts)
+	}
+
+	if requests := fake.Requests(); len(requests) != 2 {
+		t.Errorf("transcoded %d times; want 2", len(requests))
+	}
+
+	renditions, err = app.models.Renditions.GetAllForVideo(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rendition := range renditions {
+		for _, key := range rendition.BlobKeys {
+			blob, err := app.blobs.Get(ctx, key)
+			if err != nil {
+				t.Errorf("rendition %s: %v", rendition.Name, err)
+				continue
+			}
+			blob.Close()
+		}
+	}
+}
+
+func TestProcessJobDiscardsSupersededAsset(t *testing.T) {
+	app, fake, queued := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	insertTestAsset(t, app, queued.VideoID)
+
+	job := claimTestJob(t, app, queued, app.config.jobs.lease)
+
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusSucceeded {
+		t.Fatalf("got job %s with error %q; want it succeeded without saving renditions", stored.Status, stored.Error)
+	}
+
+	if requests := fake.Requests(); len(requests) != 1 {
+		t.Errorf("transcoded %d times; want 1", len(requests))
+	}
+
+	renditions, err := app.models.Renditions.GetAllForVideo(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != 0 {
+		t.Fatalf("got %d renditions from the older asset; want none", len(renditions))
+	}
+}
+
+func TestProcessJobFailsAfterExpiredLastAttempt(t *testing.T) {
+	app, fake, queued := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	_, err := app.db.ExecContext(ctx, "UPDATE jobs SET max_attempts = 1 WHERE id = $1", queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimTestJob(t, app, queued, -time.Second)
+	job := claimTestJob(t, app, queued, app.config.jobs.lease)
+
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusFailed || stored.Error != "worker stopped during the last attempt" {
+		t.Fatalf("got job %s with error %q; want it failed because its worker stopped", stored.Status, stored.Error)
+	}
+
+	if requests := fake.Requests(); len(requests) != 0 {
+		t.Errorf("transcoded %d times; want the job not run again", len(requests))
+	}
+}
+
+func TestProcessJobReleasedOnShutdown(t *testing.T) {
+	app, _, queued := newTestWorkerApplication(t)
+
+	job := claimTestJob(t, app, queued, app.config.jobs.lease)
+
+	app.stopBackground()
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusQueued || stored.Attempts != 0 {
+		t.Fatalf("got job %s after %d attempts; want it queued with the attempt not counted", stored.Status, stored.Attempts)
+	}
+}
+
+func TestJobWorkersRunQueuedJobs(t *testing.T) {
+	app, _, queued := newTestWorkerApplication(t)
+
+	app.startJobWorkers(2)
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		stored := getTestJob(t, app, queued)
+		if stored.Status == data.JobStatusSucceeded {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got job %s with error %q after 10s; want it succeeded", stored.Status, stored.Error)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	app.stopBackground()
+	app.wg.Wait()
+}
+
+// enqueueTestProbeJob queues a probe job for the asset of a transcode job from
+// newTestWorkerApplication, and claims it.
+func enqueueTestProbeJob(t *testing.T, app *application, transcodeJob *data.Job) *data.Job {
+	t.Helper()
+
+	job := &data.Job{VideoID: transcodeJob.VideoID, Kind: data.JobKindProbe, Payload: transcodeJob.Payload}
+
+	err := app.models.Jobs.Enqueue(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The transcode job is older, so it is claimed first.
+	claimTestJob(t, app, transcodeJob, app.config.jobs.lease)
+
+	return claimTestJob(t, app, job, app.config.jobs.lease)
+}
+
+func TestProbeJobStoresMetadataAndLength(t *testing.T) {
+	app, _, transcodeJob := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	prober := &probe.Fake{Metadata: &probe.Metadata{
+		Duration:      42*time.Minute + 29*time.Second + 600*time.Millisecond,
+		Width:         1280,
+		Height:        720,
+		VideoCodec:    "h264",
+		AudioCodec:    "aac",
+		AudioLanguage: "ara",
+	}}
+
+	app.prober = prober
+	app.config.probe.updateLength = true
+
+	job := enqueueTestProbeJob(t, app, transcodeJob)
+
+	var payload data.AssetPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := app.models.Videos.Get(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusSucceeded {
+		t.Fatalf("got job %s with error %q; want it succeeded", stored.Status, stored.Error)
+	}
+
+	if paths := prober.Paths(); len(paths) != 1 {
+		t.Errorf("probed %d files; want 1", len(paths))
+	}
+
+	asset, err := app.models.MediaAssets.Get(ctx, job.VideoID, payload.AssetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := asset.Metadata
+	if md == nil || md.DurationMS != 2_549_600 || md.Width != 1280 || md.Height != 720 ||
+		md.VideoCodec != "h264" || md.AudioCodec != "aac" || md.AudioLanguage != "ara" || md.ProbedAt.IsZero() {
+		t.Fatalf("got metadata %+v", md)
+	}
+
+	video, err := app.models.Videos.Get(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 42:29.6 rounds to 2550 seconds, saved as a new version.
+	if video.Length != 2550 || video.Version != before.Version+1 {
+		t.Errorf("got length %d at version %d; want 2550 at %d", video.Length, video.Version, before.Version+1)
+	}
+}
+
+func TestProbeJobRetriesLengthAfterEditConflict(t *testing.T) {
+	app, _, transcodeJob := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	app.prober = &probe.Fake{Metadata: &probe.Metadata{Duration: 30 * time.Minute}}
+	app.config.probe.updateLength = true
+
+	job := enqueueTestProbeJob(t, app, transcodeJob)
+
+	before, err := app.models.Videos.Get(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An editor holds the video while the job reads it, then saves a new
+	// title, so the job's first update is at a stale version.
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	editor := app.models.WithTx(tx)
+
+	video, err := editor.Videos.GetForUpdate(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.processJob(app.logger, job)
+	}()
+
+	// Wait for the job to read the video and block on the editor's lock.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var waiting int
+
+		err := app.db.QueryRowContext(ctx, "SELECT count(*) FROM pg_stat_activity WHERE datname = current_database() AND wait_event_type = 'Lock'").Scan(&waiting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waiting > 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the job didn't wait for the editor's lock")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	video.Title = "Edited while probing"
+
+	err = editor.Videos.Update(ctx, video)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusSucceeded {
+		t.Fatalf("got job %s with error %q; want it succeeded", stored.Status, stored.Error)
+	}
+
+	after, err := app.models.Videos.Get(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry keeps the editor's change and sets the length on top.
+	if after.Title != "Edited while probing" || after.Length != 1800 || after.Version != before.Version+2 {
+		t.Errorf("got %q, length %d at version %d; want the edited title, 1800 at %d", after.Title, after.Length, after.Version, before.Version+2)
+	}
+}
+
+func TestProbeJobSkipsLengthOfSupersededAsset(t *testing.T) {
+	app, _, transcodeJob := newTestWorkerApplication(t)
+	ctx := context.Background()
+
+	app.config.probe.updateLength = true
+
+	job := enqueueTestProbeJob(t, app, transcodeJob)
+
+	insertTestAsset(t, app, job.VideoID)
+
+	app.processJob(app.logger, job)
+
+	stored := getTestJob(t, app, job)
+	if stored.Status != data.JobStatusSucceeded {
+		t.Fatalf("got job %s with error %q; want it succeeded", stored.Status, stored.Error)
+	}
+
+	video, err := app.models.Videos.Get(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if video.Length != 600 {
+		t.Errorf("got length %d from the older asset; want it left at 600", video.Length)
+	}
+}
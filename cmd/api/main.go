@@ -2,17 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
+	"image"
 	"log/slog"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/probe"
+	"github.com/JLL32/thmanyah/internal/provider"
+	"github.com/JLL32/thmanyah/internal/storage"
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/JLL32/thmanyah/internal/transcode"
+	"github.com/JLL32/thmanyah/internal/urlsign"
+	"github.com/JLL32/thmanyah/internal/validator"
 	_ "github.com/lib/pq"
 )
 
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
 type config struct {
 	port int
 	env  string
@@ -22,13 +41,109 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  time.Duration
 	}
+	log struct {
+		format string
+		level  string
+	}
+	trace struct {
+		output string
+	}
+	server struct {
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+		maxHeaderBytes    int
+		shutdownTimeout   time.Duration
+		drainDelay        time.Duration
+		http2             bool
+	}
+	tls struct {
+		certFile     string
+		keyFile      string
+		redirectPort int
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	idempotency struct {
+		ttl             time.Duration
+		cleanupInterval time.Duration
+	}
+	storage struct {
+		backend string
+		dir     string
+		s3      struct {
+			endpoint  string
+			bucket    string
+			region    string
+			accessKey string
+			secretKey string
+		}
+	}
+	upload struct {
+		maxSize      int64
+		maxChunkSize int64
+		timeout      time.Duration
+	}
+	images struct {
+		maxSize int64
+		sizes   map[string][]image.Point
+		baseURL string
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		lease        time.Duration
+	}
+	transcode struct {
+		backend    string
+		ffmpegPath string
+	}
+	probe struct {
+		backend      string
+		ffprobePath  string
+		updateLength bool
+	}
+	playback struct {
+		signingKeys string
+		urlTTL      time.Duration
+		mintAPIKeys []string
+	}
+	metadata struct {
+		provider      string
+		youtubeAPIKey string
+		youtubeAPIURL string
+	}
+	sync struct {
+		channels  []string
+		interval  time.Duration
+		timeout   time.Duration
+		dryRun    bool
+		videoType string
+		language  string
+	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	wg     sync.WaitGroup
-	models data.Models
+	config           config
+	logger           *slog.Logger
+	wg               sync.WaitGroup
+	db               *sql.DB
+	models           data.Models
+	metrics          *appMetrics
+	tracer           *tracing.Tracer
+	blobs            storage.BlobStore
+	transcoder       transcode.Transcoder
+	prober           probe.Prober
+	metadataProvider provider.MetadataProvider
+	urlSigner        *urlsign.Signer
+	shuttingDown     atomic.Bool
+
+	// backgroundCtx is cancelled when the server starts shutting down, to
+	// stop long-running background jobs before waiting on wg.
+	backgroundCtx  context.Context
+	stopBackground context.CancelFunc
 }
 
 func main() {
@@ -43,9 +158,175 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
 
+	flag.StringVar(&cfg.log.format, "log-format", "text", "Log output format (text|json)")
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug|info|warn|error)")
+
+	flag.DurationVar(&cfg.server.readTimeout, "read-timeout", 5*time.Second, "Maximum duration for reading an entire request")
+	flag.DurationVar(&cfg.server.readHeaderTimeout, "read-header-timeout", 5*time.Second, "Maximum duration for reading request headers")
+	flag.DurationVar(&cfg.server.writeTimeout, "write-timeout", 10*time.Second, "Maximum duration before timing out writes of a response")
+	flag.DurationVar(&cfg.server.idleTimeout, "idle-timeout", time.Minute, "Maximum time to wait for the next request on a keep-alive connection")
+	flag.IntVar(&cfg.server.maxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes, "Maximum size of request headers in bytes")
+	flag.DurationVar(&cfg.server.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Grace period for in-flight requests during shutdown")
+	flag.DurationVar(&cfg.server.drainDelay, "shutdown-drain-delay", 0, "Time to keep serving after readiness starts failing, before shutting down")
+	flag.BoolVar(&cfg.server.http2, "http2", true, "Enable HTTP/2 (over TLS, or cleartext h2c when TLS is off)")
+
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file (enables HTTPS; reloaded on SIGHUP)")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.IntVar(&cfg.tls.redirectPort, "tls-redirect-port", 0, "Port for a plain HTTP listener that redirects to HTTPS (disabled if 0)")
+
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", 24*time.Hour, "How long idempotency keys and their stored responses are kept")
+	flag.DurationVar(&cfg.idempotency.cleanupInterval, "idempotency-cleanup-interval", time.Hour, "How often expired idempotency keys are deleted")
+
+	flag.StringVar(&cfg.storage.backend, "storage-backend", "local", "Blob storage for media assets (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./media", "Directory for media assets with the local storage backend")
+	flag.StringVar(&cfg.storage.s3.endpoint, "storage-s3-endpoint", "", "S3-compatible endpoint URL, e.g. https://s3.eu-west-1.amazonaws.com")
+	flag.StringVar(&cfg.storage.s3.bucket, "storage-s3-bucket", "", "S3 bucket for media assets")
+	flag.StringVar(&cfg.storage.s3.region, "storage-s3-region", "us-east-1", "S3 region used for request signing")
+	flag.StringVar(&cfg.storage.s3.accessKey, "storage-s3-access-key", os.Getenv("STORAGE_S3_ACCESS_KEY"), "S3 access key ID (defaults to $STORAGE_S3_ACCESS_KEY)")
+	flag.StringVar(&cfg.storage.s3.secretKey, "storage-s3-secret-key", os.Getenv("STORAGE_S3_SECRET_KEY"), "S3 secret access key (defaults to $STORAGE_S3_SECRET_KEY)")
+
+	flag.Int64Var(&cfg.upload.maxSize, "upload-max-size", 10<<30, "Maximum size of a media asset in bytes")
+	flag.Int64Var(&cfg.upload.maxChunkSize, "upload-max-chunk-size", 64<<20, "Maximum size of a single upload chunk in bytes")
+	flag.DurationVar(&cfg.upload.timeout, "upload-timeout", 10*time.Minute, "Maximum duration for receiving an upload chunk, replacing -read-timeout and -write-timeout")
+
+	flag.Int64Var(&cfg.images.maxSize, "image-max-size", 20<<20, "Maximum size of an uploaded image in bytes")
+	flag.StringVar(&cfg.images.baseURL, "image-base-url", "", "Base URL of the blob store for thumbnail URLs, e.g. a CDN (served through the API if empty)")
+
+	cfg.images.sizes = map[string][]image.Point{
+		"poster":  {{1280, 720}, {640, 360}, {320, 180}},
+		"artwork": {{3000, 3000}, {1400, 1400}, {600, 600}},
+	}
+	for _, kind := range data.ImageKinds {
+		var defaults []string
+		for _, size := range cfg.images.sizes[kind] {
+			defaults = append(defaults, fmt.Sprintf("%dx%d", size.X, size.Y))
+		}
+
+		usage := fmt.Sprintf("Renditions generated for %s images (space separated WIDTHxHEIGHT, default %q)", kind, strings.Join(defaults, " "))
+		flag.Func("image-"+kind+"-sizes", usage, func(val string) error {
+			sizes, err := parseImageSizes(val)
+			if err != nil {
+				return err
+			}
+			if len(sizes) == 0 {
+				return errors.New("at least one size is required")
+			}
+
+			cfg.images.sizes[kind] = sizes
+			return nil
+		})
+	}
+
+	flag.IntVar(&cfg.jobs.workers, "job-workers", 2, "Number of background job workers (disabled if 0)")
+	flag.DurationVar(&cfg.jobs.pollInterval, "job-poll-interval", 5*time.Second, "How often an idle job worker checks the queue")
+	flag.DurationVar(&cfg.jobs.lease, "job-lease", time.Minute, "How long a claimed job is held before another worker may take it over; renewed while the job runs")
+
+	flag.StringVar(&cfg.transcode.backend, "transcoder", "ffmpeg", "Transcoder for uploaded media (ffmpeg|fake)")
+	flag.StringVar(&cfg.transcode.ffmpegPath, "ffmpeg-path", "ffmpeg", "Path of the ffmpeg binary")
+
+	flag.StringVar(&cfg.probe.backend, "prober", "ffprobe", "Prober for the metadata of uploaded media (ffprobe|fake)")
+	flag.StringVar(&cfg.probe.ffprobePath, "ffprobe-path", "ffprobe", "Path of the ffprobe binary")
+	flag.BoolVar(&cfg.probe.updateLength, "probe-update-length", true, "Set the length of a video to the probed duration of each uploaded asset")
+
+	flag.StringVar(&cfg.playback.signingKeys, "url-signing-keys", os.Getenv("URL_SIGNING_KEYS"), "Space-separated kid:secret keys for signing media URLs; the first one signs (defaults to $URL_SIGNING_KEYS; random if empty)")
+	flag.DurationVar(&cfg.playback.urlTTL, "playback-url-ttl", time.Hour, "How long signed playback URLs stay valid")
+
+	cfg.playback.mintAPIKeys = strings.Fields(os.Getenv("PLAYBACK_MINT_API_KEYS"))
+	flag.Func("playback-mint-api-keys", "Space-separated bearer tokens allowed to mint playback URLs (defaults to $PLAYBACK_MINT_API_KEYS)", func(val string) error {
+		cfg.playback.mintAPIKeys = strings.Fields(val)
+		return nil
+	})
+
+	flag.StringVar(&cfg.metadata.provider, "metadata-provider", "youtube", "Provider of the metadata of imported videos (youtube|fake)")
+	flag.StringVar(&cfg.metadata.youtubeAPIKey, "youtube-api-key", os.Getenv("YOUTUBE_API_KEY"), "YouTube Data API key (defaults to $YOUTUBE_API_KEY)")
+	flag.StringVar(&cfg.metadata.youtubeAPIURL, "youtube-api-url", "https://www.googleapis.com/youtube/v3", "Base URL of the YouTube Data API")
+
+	flag.Func("sync-channels", "Space-separated channel IDs whose uploads are synced from the metadata provider on a schedule", func(val string) error {
+		cfg.sync.channels = strings.Fields(val)
+		return nil
+	})
+	flag.DurationVar(&cfg.sync.interval, "sync-interval", 6*time.Hour, "How often each channel is synced (disabled if 0)")
+	flag.DurationVar(&cfg.sync.timeout, "sync-timeout", 30*time.Minute, "Maximum duration of a sync run; a run still marked as running after this is taken to have died")
+	flag.BoolVar(&cfg.sync.dryRun, "sync-dry-run", false, "Record what scheduled syncs would change without writing any video")
+	flag.StringVar(&cfg.sync.videoType, "sync-type", "podcast", "Type of the videos created by syncs")
+	flag.StringVar(&cfg.sync.language, "sync-language", "", "Language of videos created by syncs whose metadata declares none")
+
+	flag.StringVar(&cfg.trace.output, "trace-output", "", "Write OTLP/JSON trace spans to this file, or \"stdout\" (disabled if empty)")
+
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger, err := newLogger(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	tracer, closeTracer, err := newTracer(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer closeTracer()
+
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if cfg.idempotency.ttl <= 0 || cfg.idempotency.cleanupInterval <= 0 {
+		logger.Error("-idempotency-key-ttl and -idempotency-cleanup-interval must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.jobs.lease <= 0 || cfg.jobs.pollInterval <= 0 {
+		logger.Error("-job-lease and -job-poll-interval must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.sync.interval < 0 || cfg.sync.timeout <= 0 {
+		logger.Error("-sync-interval must not be negative and -sync-timeout must be positive")
+		os.Exit(1)
+	}
+
+	if !validator.PermittedValue(cfg.sync.videoType, data.VideoTypes...) {
+		logger.Error(fmt.Sprintf("invalid -sync-type value %q", cfg.sync.videoType))
+		os.Exit(1)
+	}
+
+	if cfg.sync.language != "" && !validator.ISO6391(cfg.sync.language) {
+		logger.Error(fmt.Sprintf("invalid -sync-language value %q", cfg.sync.language))
+		os.Exit(1)
+	}
+
+	transcoder, err := newTranscoder(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	prober, err := newProber(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	metadataProvider, err := newMetadataProvider(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	urlSigner, err := newURLSigner(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -56,11 +337,31 @@ func main() {
 	logger.Info("database connection pool established")
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:           cfg,
+		logger:           logger,
+		db:               db,
+		models:           data.NewModels(db, tracer),
+		metrics:          newAppMetrics(db),
+		tracer:           tracer,
+		blobs:            blobs,
+		transcoder:       transcoder,
+		prober:           prober,
+		urlSigner:        urlSigner,
+		metadataProvider: metadataProvider,
 	}
 
+	err = app.models.Videos.CheckTypes(context.Background())
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	app.backgroundCtx, app.stopBackground = context.WithCancel(context.Background())
+
+	app.expireIdempotencyKeys(cfg.idempotency.cleanupInterval)
+	app.startJobWorkers(cfg.jobs.workers)
+	app.startSyncScheduler()
+
 	err = app.serve()
 
 	if err != nil {
@@ -69,6 +370,122 @@ func main() {
 	}
 }
 
+func newLogger(cfg config) (*slog.Logger, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(cfg.log.level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -log-level value %q", cfg.log.level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch cfg.log.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format value %q", cfg.log.format)
+	}
+}
+
+func newBlobStore(cfg config) (storage.BlobStore, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocalStore(cfg.storage.dir)
+	case "s3":
+		if cfg.storage.s3.endpoint == "" || cfg.storage.s3.bucket == "" {
+			return nil, errors.New("-storage-s3-endpoint and -storage-s3-bucket are required with -storage-backend=s3")
+		}
+
+		return &storage.S3Store{
+			Endpoint:  cfg.storage.s3.endpoint,
+			Bucket:    cfg.storage.s3.bucket,
+			Region:    cfg.storage.s3.region,
+			AccessKey: cfg.storage.s3.accessKey,
+			SecretKey: cfg.storage.s3.secretKey,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid -storage-backend value %q", cfg.storage.backend)
+	}
+}
+
+func newTranscoder(cfg config) (transcode.Transcoder, error) {
+	switch cfg.transcode.backend {
+	case "ffmpeg":
+		return &transcode.FFmpeg{Path: cfg.transcode.ffmpegPath}, nil
+	case "fake":
+		return &transcode.Fake{}, nil
+	default:
+		return nil, fmt.Errorf("invalid -transcoder value %q", cfg.transcode.backend)
+	}
+}
+
+func newProber(cfg config) (probe.Prober, error) {
+	switch cfg.probe.backend {
+	case "ffprobe":
+		return &probe.FFprobe{Path: cfg.probe.ffprobePath}, nil
+	case "fake":
+		return &probe.Fake{}, nil
+	default:
+		return nil, fmt.Errorf("invalid -prober value %q", cfg.probe.backend)
+	}
+}
+
+func newMetadataProvider(cfg config, logger *slog.Logger) (provider.MetadataProvider, error) {
+	switch cfg.metadata.provider {
+	case "youtube":
+		if cfg.metadata.youtubeAPIKey == "" {
+			logger.Warn("no -youtube-api-key set; YouTube imports will fail")
+		}
+
+		return &provider.YouTube{APIKey: cfg.metadata.youtubeAPIKey, BaseURL: cfg.metadata.youtubeAPIURL}, nil
+	case "fake":
+		return &provider.Fake{}, nil
+	default:
+		return nil, fmt.Errorf("invalid -metadata-provider value %q", cfg.metadata.provider)
+	}
+}
+
+// newURLSigner falls back to a random key when none are configured, which is
+// only good for a single instance in development.
+func newURLSigner(cfg config, logger *slog.Logger) (*urlsign.Signer, error) {
+	if strings.TrimSpace(cfg.playback.signingKeys) != "" {
+		return urlsign.ParseKeys(cfg.playback.signingKeys)
+	}
+
+	logger.Warn("no -url-signing-keys set; using a random key, so signed URLs won't work across restarts or instances")
+
+	return &urlsign.Signer{
+		Keys:  map[string][]byte{"ephemeral": []byte(rand.Text() + rand.Text())},
+		KeyID: "ephemeral",
+	}, nil
+}
+
+// newTracer returns a nil tracer, which disables span recording, when no trace
+// output has been configured.
+func newTracer(cfg config, logger *slog.Logger) (*tracing.Tracer, func() error, error) {
+	onError := func(err error) {
+		logger.Error("exporting trace span", "error", err)
+	}
+
+	switch cfg.trace.output {
+	case "":
+		return nil, func() error { return nil }, nil
+	case "stdout":
+		exporter := tracing.NewJSONExporter(os.Stdout, "thmanyah-api", onError)
+		return tracing.NewTracer(exporter), func() error { return nil }, nil
+	default:
+		f, err := os.OpenFile(cfg.trace.output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		exporter := tracing.NewJSONExporter(f, "thmanyah-api", onError)
+		return tracing.NewTracer(exporter), f.Close, nil
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/JLL32/thmanyah/internal/metrics"
+)
+
+type appMetrics struct {
+	registry         *metrics.Registry
+	requests         *metrics.CounterVec
+	requestDuration  *metrics.HistogramVec
+	requestsInFlight atomic.Int64
+	backgroundTasks  atomic.Int64
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	m := &appMetrics{registry: metrics.NewRegistry()}
+
+	m.requests = m.registry.NewCounterVec(
+		"http_requests_total",
+		"Total number of HTTP requests processed, by method, route and status code.",
+		"method", "route", "status",
+	)
+	m.requestDuration = m.registry.NewHistogramVec(
+		"http_request_duration_seconds",
+		"Time taken to process HTTP requests, by method and route.",
+		metrics.DefaultBuckets,
+		"method", "route",
+	)
+	m.registry.NewGaugeFunc("http_requests_in_flight", "Number of HTTP requests currently being processed.", nil, func() float64 {
+		return float64(m.requestsInFlight.Load())
+	})
+	m.registry.NewGaugeFunc("background_tasks_in_flight", "Number of background tasks currently running.", nil, func() float64 {
+		return float64(m.backgroundTasks.Load())
+	})
+
+	if db != nil {
+		m.registry.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.", nil, func() float64 {
+			return float64(db.Stats().MaxOpenConnections)
+		})
+		m.registry.NewGaugeFunc("db_open_connections", "Number of established connections, both in use and idle.", nil, func() float64 {
+			return float64(db.Stats().OpenConnections)
+		})
+		m.registry.NewGaugeFunc("db_in_use_connections", "Number of connections currently in use.", nil, func() float64 {
+			return float64(db.Stats().InUse)
+		})
+		m.registry.NewGaugeFunc("db_idle_connections", "Number of idle connections.", nil, func() float64 {
+			return float64(db.Stats().Idle)
+		})
+		m.registry.NewCounterFunc("db_wait_count_total", "Total number of connections waited for.", nil, func() float64 {
+			return float64(db.Stats().WaitCount)
+		})
+		m.registry.NewCounterFunc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", nil, func() float64 {
+			return db.Stats().WaitDuration.Seconds()
+		})
+		m.registry.NewCounterFunc("db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.", nil, func() float64 {
+			return float64(db.Stats().MaxIdleClosed)
+		})
+		m.registry.NewCounterFunc("db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.", nil, func() float64 {
+			return float64(db.Stats().MaxIdleTimeClosed)
+		})
+		m.registry.NewCounterFunc("db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.", nil, func() float64 {
+			return float64(db.Stats().MaxLifetimeClosed)
+		})
+	}
+
+	m.registry.NewGaugeFunc("build_info", "Build information about the running binary.", metrics.Labels{
+		"version":   version,
+		"commit":    commit,
+		"goversion": runtime.Version(),
+	}, func() float64 {
+		return 1
+	})
+
+	return m
+}
+
+// metricMethod returns the method label to record a request under. Methods
+// outside the standard set share the "OTHER" label, so that clients can't
+// create a new series for every method they make up.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, err := app.metrics.registry.WriteTo(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
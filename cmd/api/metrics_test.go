@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordMetricsBoundsMethodLabel(t *testing.T) {
+	app := newTestApplication(t)
+
+	handler := app.recordMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, method := range []string{"GET", "PATCH", "BREW", "X-1", "get", "PROPFIND"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/v1/videos", nil))
+	}
+
+	var buf bytes.Buffer
+
+	_, err := app.metrics.registry.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var methods []string
+
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "http_requests_total{") {
+			continue
+		}
+
+		_, rest, _ := strings.Cut(line, `method="`)
+		method, _, _ := strings.Cut(rest, `"`)
+		methods = append(methods, method)
+
+		if method == "OTHER" && !strings.HasSuffix(line, " 4") {
+			t.Errorf("got %q; want the four non-standard requests counted together", line)
+		}
+	}
+
+	if len(methods) != 3 {
+		t.Errorf("got method labels %q; want GET, PATCH and OTHER", methods)
+	}
+
+	for _, method := range methods {
+		if method != "GET" && method != "PATCH" && method != "OTHER" {
+			t.Errorf("got method label %q", method)
+		}
+	}
+}
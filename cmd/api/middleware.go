@@ -1,8 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -18,3 +28,245 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+type responseRecorder struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *responseRecorder) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *responseRecorder) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *responseRecorder) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+	return n, err
+}
+
+func (mw *responseRecorder) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.requestsInFlight.Add(1)
+		defer app.metrics.requestsInFlight.Add(-1)
+
+		r, state := app.withRequestState(r)
+
+		mw := newResponseRecorder(w)
+
+		defer func() {
+			route := state.route
+			if route == "" {
+				route = "unmatched"
+			}
+
+			method := metricMethod(r.Method)
+
+			app.metrics.requests.Inc(method, route, strconv.Itoa(mw.statusCode))
+			app.metrics.requestDuration.Observe(time.Since(start).Seconds(), method, route)
+		}()
+
+		next.ServeHTTP(mw, r)
+	})
+}
+
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to
+// propagate into logs and response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func traceID(r *http.Request) string {
+	sc := tracing.SpanFromContext(r.Context()).SpanContext()
+	if !sc.IsValid() {
+		return ""
+	}
+
+	return sc.TraceID.String()
+}
+
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		r, state := app.withRequestState(r)
+
+		mw := newResponseRecorder(w)
+
+		defer func() {
+			level := slog.LevelInfo
+			if mw.statusCode >= 500 {
+				level = slog.LevelError
+			}
+
+			app.logger.LogAttrs(r.Context(), level, "request completed",
+				slog.String("request_id", app.contextGetRequestID(r)),
+				slog.String("trace_id", traceID(r)),
+				slog.String("method", r.Method),
+				slog.String("route", state.route),
+				slog.String("uri", r.URL.RequestURI()),
+				slog.Int("status", mw.statusCode),
+				slog.Int("bytes", mw.bytesWritten),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_ip", remoteIP(r)),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		}()
+
+		next.ServeHTTP(mw, r)
+	})
+}
+
+// remoteIP returns the address of the client's end of the connection, with
+// IPv4-mapped IPv6 addresses in their IPv4 form.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+
+	return addr.Unmap().String()
+}
+
+// requireSignedURL only lets a request through if its URL was signed by
+// app.urlSigner, and records the verified token in the request context. It
+// guards the routes that serve protected media.
+func (app *application) requireSignedURL(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := app.urlSigner.Verify(r.URL.Path, r.URL.Query(), time.Now(), remoteIP(r))
+		if err != nil {
+			app.invalidSignedURLResponse(w, r, err)
+			return
+		}
+
+		next(w, app.contextSetSignedURL(r, token))
+	}
+}
+
+// traceRequests continues the trace described by an incoming traceparent
+// header, or starts a new one, and wraps the request in a server span.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if sc, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		ctx, span := app.tracer.Start(ctx, r.Method, tracing.SpanKindServer,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("user_agent.original", r.UserAgent()),
+			tracing.String("http.request_id", app.contextGetRequestID(r)),
+		)
+		defer span.End()
+
+		r, state := app.withRequestState(r.WithContext(ctx))
+
+		mw := newResponseRecorder(w)
+
+		next.ServeHTTP(mw, r)
+
+		if state.route != "" {
+			span.SetName(r.Method + " " + state.route)
+			span.SetAttributes(tracing.String("http.route", state.route))
+		}
+
+		span.SetAttributes(tracing.Int("http.response.status_code", mw.statusCode))
+		if mw.statusCode >= 500 {
+			span.SetStatus(tracing.StatusError, http.StatusText(mw.statusCode))
+		}
+	})
+}
+
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && slices.Contains(app.config.cors.trustedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "Accept-Patch, ETag, Idempotent-Replayed, Location, X-Request-ID")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsPreflight is installed as the router's GlobalOPTIONS handler, so it only
+// runs for paths that have routes, after httprouter has set the Allow header
+// to the methods registered for the path.
+func (app *application) corsPreflight(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Access-Control-Request-Method") != "" && w.Header().Get("Access-Control-Allow-Origin") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Range, Content-Type, Idempotency-Key, X-Expected-Version, X-Request-ID, traceparent")
+		w.Header().Set("Access-Control-Max-Age", "600")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
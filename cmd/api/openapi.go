@@ -0,0 +1,1110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/validator"
+)
+
+type spec map[string]any
+
+func schemaRef(name string) spec {
+	return spec{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonBody(schema spec) spec {
+	return spec{"application/json": spec{"schema": schema}}
+}
+
+func jsonResponse(description string, schema spec) spec {
+	return spec{"description": description, "content": jsonBody(schema)}
+}
+
+// errorResponses references the shared error response for each status code.
+func errorResponses(statuses ...int) spec {
+	responses := spec{}
+	for _, status := range statuses {
+		responses[fmt.Sprint(status)] = spec{"$ref": "#/components/responses/Error"}
+	}
+	return responses
+}
+
+func withErrors(responses spec, statuses ...int) spec {
+	for code, response := range errorResponses(statuses...) {
+		responses[code] = response
+	}
+	return responses
+}
+
+var videoIDParameter = spec{
+	"name":        "id",
+	"in":          "path",
+	"required":    true,
+	"description": "Video ID",
+	"schema":      spec{"type": "string", "maxLength": 11},
+}
+
+// signedURLParameters are the query parameters added by urlsign.
+var signedURLParameters = []spec{
+	{"name": "kid", "in": "query", "required": true, "schema": spec{"type": "string"}, "description": "ID of the signing key"},
+	{"name": "expires", "in": "query", "required": true, "schema": spec{"type": "integer"}, "description": "Unix time the URL expires at"},
+	{"name": "ip", "in": "query", "schema": spec{"type": "string"}, "description": "Client IP address the URL is bound to"},
+	{"name": "sig", "in": "query", "required": true, "schema": spec{"type": "string"}},
+}
+
+var imageKindParameter = spec{
+	"name":     "kind",
+	"in":       "path",
+	"required": true,
+	"schema":   spec{"type": "string", "enum": data.ImageKinds},
+}
+
+var showIDParameter = spec{
+	"name":        "id",
+	"in":          "path",
+	"required":    true,
+	"description": "Show ID",
+	"schema":      spec{"type": "integer", "minimum": 1},
+}
+
+var showImageKindParameter = spec{
+	"name":     "kind",
+	"in":       "path",
+	"required": true,
+	"schema":   spec{"type": "string", "enum": data.ShowImageKinds},
+}
+
+var imageFileParameter = spec{
+	"name":        "file",
+	"in":          "path",
+	"required":    true,
+	"schema":      spec{"type": "string", "pattern": `^\d+x\d+\.jpg$`},
+	"description": "Rendition, e.g. 1280x720.jpg",
+}
+
+// imageUploadBody is the request body of the image upload routes.
+var imageUploadBody = spec{
+	"required": true,
+	"content": spec{
+		"image/jpeg": spec{"schema": spec{"type": "string", "format": "binary"}},
+		"image/png":  spec{"schema": spec{"type": "string", "format": "binary"}},
+		"image/gif":  spec{"schema": spec{"type": "string", "format": "binary"}},
+	},
+}
+
+// imageRenditionResponses are the responses of the image rendition routes.
+var imageRenditionResponses = withErrors(spec{
+	"200": spec{
+		"description": "JPEG image",
+		"content":     spec{"image/jpeg": spec{"schema": spec{"type": "string", "format": "binary"}}},
+	},
+	"304": spec{"description": "Not modified"},
+}, 404, 500)
+
+// openAPISpec describes every route registered in routes(). routes_test.go
+// fails for a route that's missing here.
+func (app *application) openAPISpec() spec {
+	videoFields := spec{
+		"title":        spec{"type": "string", "minLength": 1, "maxLength": 500},
+		"description":  spec{"type": "string", "minLength": 1, "maxLength": 5000},
+		"type":         spec{"type": "string", "enum": data.VideoTypes},
+		"length":       spec{"type": "integer", "minimum": 1, "description": "Duration in seconds"},
+		"language":     spec{"type": "string", "pattern": "^[a-z]{2}$", "description": "Lowercase ISO 639-1 language code"},
+		"published_at": spec{"type": "string", "format": "date-time"},
+	}
+
+	video := spec{
+		"video_id":   spec{"type": "string", "maxLength": 11},
+		"created_at": spec{"type": "string", "format": "date-time", "readOnly": true},
+		"version":    spec{"type": "integer", "readOnly": true},
+		"thumbnails": spec{
+			"type":                 "object",
+			"readOnly":             true,
+			"description":          "Image renditions by kind (" + strings.Join(data.ImageKinds, ", ") + "), largest first; omitted when there are none",
+			"additionalProperties": spec{"type": "array", "items": schemaRef("Thumbnail")},
+		},
+	}
+	for name, field := range videoFields {
+		video[name] = field
+	}
+
+	videoInput := spec{"video_id": spec{"type": "string", "pattern": validator.VideoIDRX.String(), "description": "Optional; generated by the server when omitted"}}
+	for name, field := range videoFields {
+		videoInput[name] = field
+	}
+
+	videoRequired := []string{"title", "description", "type", "length", "language", "published_at"}
+
+	chapterFields := spec{
+		"start_time": spec{"type": "integer", "minimum": 0, "description": "Seconds from the start of the video"},
+		"end_time":   spec{"type": []string{"integer", "null"}, "description": "Seconds from the start of the video; the chapter runs until the next one when omitted"},
+		"title":      spec{"type": "string", "minLength": 1, "maxLength": 200},
+		"image_url":  spec{"type": "string", "format": "uri", "maxLength": 2048},
+	}
+
+	chapter := spec{
+		"id":         spec{"type": "integer", "readOnly": true},
+		"video_id":   spec{"type": "string", "readOnly": true},
+		"created_at": spec{"type": "string", "format": "date-time", "readOnly": true},
+		"version":    spec{"type": "integer", "readOnly": true},
+	}
+	for name, field := range chapterFields {
+		chapter[name] = field
+	}
+
+	showFields := spec{
+		"title":       spec{"type": "string", "minLength": 1, "maxLength": 500},
+		"description": spec{"type": "string", "maxLength": 5000},
+	}
+
+	show := spec{
+		"id":         spec{"type": "integer", "readOnly": true},
+		"created_at": spec{"type": "string", "format": "date-time", "readOnly": true},
+		"version":    spec{"type": "integer", "readOnly": true},
+		"thumbnails": spec{
+			"type":                 "object",
+			"readOnly":             true,
+			"description":          "Image renditions by kind (" + strings.Join(data.ShowImageKinds, ", ") + "), largest first; omitted when there are none",
+			"additionalProperties": spec{"type": "array", "items": schemaRef("Thumbnail")},
+		},
+	}
+	for name, field := range showFields {
+		show[name] = field
+	}
+
+	schemas := spec{
+		"Video": spec{
+			"type":       "object",
+			"properties": video,
+			"required":   append([]string{"video_id", "created_at", "version"}, videoRequired...),
+		},
+		"VideoInput": spec{
+			"type":                 "object",
+			"properties":           videoInput,
+			"required":             videoRequired,
+			"additionalProperties": false,
+		},
+		"VideoUpdate": spec{
+			"type":                 "object",
+			"properties":           videoFields,
+			"additionalProperties": false,
+		},
+		"Chapter": spec{
+			"type":       "object",
+			"properties": chapter,
+			"required":   []string{"id", "video_id", "start_time", "title", "created_at", "version"},
+		},
+		"ChapterInput": spec{
+			"type":                 "object",
+			"properties":           chapterFields,
+			"required":             []string{"start_time", "title"},
+			"additionalProperties": false,
+		},
+		"ChapterUpdate": spec{
+			"type":                 "object",
+			"properties":           chapterFields,
+			"additionalProperties": false,
+		},
+		"Metadata": spec{
+			"type":        "object",
+			"description": "Empty when there are no matching records.",
+			"properties": spec{
+				"current_page":  spec{"type": "integer"},
+				"page_size":     spec{"type": "integer"},
+				"first_page":    spec{"type": "integer"},
+				"last_page":     spec{"type": "integer"},
+				"total_records": spec{"type": "integer"},
+			},
+		},
+		"JSONPatchOperation": spec{
+			"type": "object",
+			"properties": spec{
+				"op":    spec{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  spec{"type": "string"},
+				"from":  spec{"type": "string"},
+				"value": spec{},
+			},
+			"required": []string{"op", "path"},
+		},
+		"BatchRequest": spec{
+			"type": "object",
+			"properties": spec{
+				"mode": spec{"type": "string", "enum": []string{"atomic", "best_effort"}, "default": "atomic"},
+				"operations": spec{
+					"type":     "array",
+					"minItems": 1,
+					"maxItems": maxBatchOperations,
+					"items": spec{
+						"type": "object",
+						"properties": spec{
+							"op":               spec{"type": "string", "enum": []string{"update", "delete"}},
+							"video_id":         spec{"type": "string"},
+							"expected_version": spec{"type": "integer"},
+							"fields":           schemaRef("VideoUpdate"),
+						},
+						"required": []string{"op", "video_id"},
+					},
+				},
+			},
+			"required": []string{"operations"},
+		},
+		"BatchResponse": spec{
+			"type": "object",
+			"properties": spec{
+				"committed": spec{"type": "boolean"},
+				"results": spec{
+					"type": "array",
+					"items": spec{
+						"type": "object",
+						"properties": spec{
+							"index":    spec{"type": "integer"},
+							"op":       spec{"type": "string"},
+							"video_id": spec{"type": "string"},
+							"status":   spec{"type": "integer"},
+							"video":    schemaRef("Video"),
+							"error":    schemaRef("ErrorMessage"),
+						},
+					},
+				},
+			},
+		},
+		"MediaAssetInput": spec{
+			"type": "object",
+			"properties": spec{
+				"kind":         spec{"type": "string", "enum": data.MediaAssetKinds},
+				"filename":     spec{"type": "string", "minLength": 1, "maxLength": 255},
+				"content_type": spec{"type": "string"},
+				"size":         spec{"type": "integer", "minimum": 1, "maximum": app.config.upload.maxSize},
+				"sha256":       spec{"type": "string", "pattern": validator.SHA256RX.String(), "description": "Hex-encoded SHA-256 digest of the whole file"},
+			},
+			"required":             []string{"kind", "filename", "content_type", "size", "sha256"},
+			"additionalProperties": false,
+		},
+		"MediaAsset": spec{
+			"type": "object",
+			"properties": spec{
+				"id":             spec{"type": "integer"},
+				"video_id":       spec{"type": "string"},
+				"kind":           spec{"type": "string", "enum": data.MediaAssetKinds},
+				"filename":       spec{"type": "string"},
+				"content_type":   spec{"type": "string"},
+				"size":           spec{"type": "integer"},
+				"sha256":         spec{"type": "string"},
+				"status":         spec{"type": "string", "enum": []string{data.AssetStatusUploading, data.AssetStatusComplete}},
+				"uploaded_bytes": spec{"type": "integer", "description": "Offset the next chunk must start at"},
+				"created_at":     spec{"type": "string", "format": "date-time"},
+				"version":        spec{"type": "integer"},
+				"metadata":       schemaRef("MediaMetadata"),
+			},
+		},
+		"MediaMetadata": spec{
+			"type":        "object",
+			"description": "Probed from the file after the upload completes",
+			"properties": spec{
+				"duration_ms":    spec{"type": "integer"},
+				"width":          spec{"type": "integer"},
+				"height":         spec{"type": "integer"},
+				"video_codec":    spec{"type": "string"},
+				"audio_codec":    spec{"type": "string"},
+				"audio_language": spec{"type": "string", "description": "Language tag of the first audio stream, e.g. ara"},
+				"probed_at":      spec{"type": "string", "format": "date-time"},
+			},
+			"required": []string{"duration_ms", "probed_at"},
+		},
+		"Thumbnail": spec{
+			"type": "object",
+			"properties": spec{
+				"width":  spec{"type": "integer"},
+				"height": spec{"type": "integer"},
+				"url":    spec{"type": "string", "format": "uri-reference"},
+			},
+			"required": []string{"width", "height", "url"},
+		},
+		"Job": spec{
+			"type": "object",
+			"properties": spec{
+				"id":           spec{"type": "integer"},
+				"video_id":     spec{"type": "string"},
+				"kind":         spec{"type": "string", "enum": []string{data.JobKindProbe, data.JobKindTranscode}},
+				"payload":      spec{"type": "object"},
+				"status":       spec{"type": "string", "enum": []string{data.JobStatusQueued, data.JobStatusRunning, data.JobStatusSucceeded, data.JobStatusFailed}},
+				"attempts":     spec{"type": "integer"},
+				"max_attempts": spec{"type": "integer"},
+				"error":        spec{"type": "string", "description": "Error of the last failed attempt"},
+				"run_at":       spec{"type": "string", "format": "date-time", "description": "When a queued job is next due"},
+				"started_at":   spec{"type": "string", "format": "date-time"},
+				"finished_at":  spec{"type": "string", "format": "date-time"},
+				"created_at":   spec{"type": "string", "format": "date-time"},
+			},
+		},
+		"CaptionTrack": spec{
+			"type": "object",
+			"properties": spec{
+				"language":   spec{"type": "string", "description": "BCP 47 language tag"},
+				"cues":       spec{"type": "integer"},
+				"created_at": spec{"type": "string", "format": "date-time"},
+				"urls": spec{
+					"type": "object",
+					"properties": spec{
+						"vtt": spec{"type": "string", "format": "uri-reference"},
+						"srt": spec{"type": "string", "format": "uri-reference"},
+					},
+				},
+			},
+			"required": []string{"language", "cues", "created_at", "urls"},
+		},
+		"ImportRequest": spec{
+			"type": "object",
+			"properties": spec{
+				"video_ids": spec{"type": "array", "items": spec{"type": "string", "pattern": validator.VideoIDRX.String()}, "minItems": 1, "maxItems": maxImportVideos, "uniqueItems": true},
+				"type":      spec{"type": "string", "enum": data.VideoTypes, "description": "Type of the videos created"},
+				"language":  spec{"type": "string", "pattern": "^[a-z]{2}$", "description": "Language of created videos whose metadata declares none"},
+			},
+			"required":             []string{"video_ids", "type"},
+			"additionalProperties": false,
+		},
+		"ImportResult": spec{
+			"type": "object",
+			"properties": spec{
+				"video_id": spec{"type": "string"},
+				"status":   spec{"type": "string", "enum": []string{importStatusCreated, importStatusUpdated, importStatusUnchanged, importStatusNotFound, importStatusInvalid}},
+				"changes":  spec{"type": "array", "items": spec{"type": "string"}, "description": "Fields that were set or changed"},
+				"video":    schemaRef("Video"),
+				"error":    spec{"description": "Validation errors by field, for invalid videos"},
+			},
+			"required": []string{"video_id", "status"},
+		},
+		"SyncRun": spec{
+			"type": "object",
+			"properties": spec{
+				"id":         spec{"type": "integer"},
+				"provider":   spec{"type": "string"},
+				"channel_id": spec{"type": "string"},
+				"trigger":    spec{"type": "string", "enum": []string{data.SyncTriggerSchedule, data.SyncTriggerAPI}},
+				"dry_run":    spec{"type": "boolean", "description": "Whether the run only recorded what it would change"},
+				"status":     spec{"type": "string", "enum": []string{data.SyncRunStatusRunning, data.SyncRunStatusSucceeded, data.SyncRunStatusFailed}},
+				"created":    spec{"type": "integer"},
+				"updated":    spec{"type": "integer"},
+				"unchanged":  spec{"type": "integer"},
+				"invalid":    spec{"type": "integer"},
+				"changes": spec{
+					"type":        "array",
+					"description": "Videos created, updated or found invalid; only returned for a single run",
+					"items": spec{
+						"type": "object",
+						"properties": spec{
+							"video_id": spec{"type": "string"},
+							"status":   spec{"type": "string", "enum": []string{importStatusCreated, importStatusUpdated, importStatusInvalid}},
+							"fields":   spec{"type": "array", "items": spec{"type": "string"}, "description": "Fields that were set or changed"},
+							"error":    spec{"type": "object", "additionalProperties": spec{"type": "string"}, "description": "Validation errors by field, for invalid videos"},
+						},
+						"required": []string{"video_id", "status"},
+					},
+				},
+				"error":       spec{"type": "string", "description": "Why a failed run stopped"},
+				"started_at":  spec{"type": "string", "format": "date-time"},
+				"finished_at": spec{"type": "string", "format": "date-time"},
+			},
+			"required": []string{"id", "provider", "channel_id", "trigger", "dry_run", "status", "created", "updated", "unchanged", "invalid", "started_at"},
+		},
+		"Show": spec{
+			"type":       "object",
+			"properties": show,
+			"required":   []string{"id", "title", "description", "created_at", "version"},
+		},
+		"ShowInput": spec{
+			"type":                 "object",
+			"properties":           showFields,
+			"required":             []string{"title"},
+			"additionalProperties": false,
+		},
+		"ShowUpdate": spec{
+			"type":                 "object",
+			"properties":           showFields,
+			"additionalProperties": false,
+		},
+		"SyncRunInput": spec{
+			"type": "object",
+			"properties": spec{
+				"channel_id": spec{"type": "string", "maxLength": 100, "description": "Channel on the metadata provider"},
+				"dry_run":    spec{"type": "boolean", "default": false, "description": "Record what would change without writing any video"},
+			},
+			"required":             []string{"channel_id"},
+			"additionalProperties": false,
+		},
+		"PlaybackURLInput": spec{
+			"type": "object",
+			"properties": spec{
+				"client_ip": spec{"type": "string", "description": "Bind the URL, and the URLs in its playlists, to the viewer's IP address"},
+			},
+			"additionalProperties": false,
+		},
+		"PlaybackURL": spec{
+			"type": "object",
+			"properties": spec{
+				"url":        spec{"type": "string", "format": "uri-reference"},
+				"expires_at": spec{"type": "string", "format": "date-time"},
+			},
+			"required": []string{"url", "expires_at"},
+		},
+		"ErrorMessage": spec{
+			"oneOf": []spec{
+				{"type": "string"},
+				{"type": "object", "additionalProperties": spec{"type": "string"}, "description": "Field name to message"},
+			},
+		},
+		"Error": spec{
+			"type": "object",
+			"properties": spec{
+				"error":      schemaRef("ErrorMessage"),
+				"request_id": spec{"type": "string"},
+			},
+			"required": []string{"error"},
+		},
+		"Problem": spec{
+			"type": "object",
+			"properties": spec{
+				"type":       spec{"type": "string", "format": "uri-reference"},
+				"title":      spec{"type": "string"},
+				"status":     spec{"type": "integer"},
+				"detail":     spec{"type": "string"},
+				"instance":   spec{"type": "string", "format": "uri-reference"},
+				"request_id": spec{"type": "string"},
+				"errors": spec{
+					"type": "array",
+					"items": spec{
+						"type": "object",
+						"properties": spec{
+							"field":   spec{"type": "string"},
+							"message": spec{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+		"Health": spec{
+			"type": "object",
+			"properties": spec{
+				"status":      spec{"type": "string"},
+				"system_info": spec{"type": "object", "additionalProperties": spec{"type": "string"}},
+				"checks":      spec{"type": "object", "additionalProperties": spec{"type": "string"}},
+				"db_pool":     spec{"type": "object", "additionalProperties": spec{"type": "integer"}},
+			},
+		},
+	}
+
+	videoEnvelope := spec{"type": "object", "properties": spec{"video": schemaRef("Video")}}
+	assetEnvelope := spec{"type": "object", "properties": spec{"asset": schemaRef("MediaAsset")}}
+	assetList := spec{"type": "array", "items": schemaRef("MediaAsset")}
+	chapterEnvelope := spec{"type": "object", "properties": spec{"chapter": schemaRef("Chapter")}}
+	showEnvelope := spec{"type": "object", "properties": spec{"show": schemaRef("Show")}}
+	messageResponse := jsonResponse("OK", spec{"type": "object", "properties": spec{"message": spec{"type": "string"}}})
+
+	paths := spec{
+		"/v1/videos": spec{
+			"post": spec{
+				"operationId": "createVideo",
+				"summary":     "Create a video",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("VideoInput"))},
+				"responses": withErrors(spec{
+					"201": spec{
+						"description": "Created",
+						"headers":     spec{"Location": spec{"schema": spec{"type": "string"}}},
+						"content":     jsonBody(videoEnvelope),
+					},
+				}, 400, 409, 422, 500),
+			},
+			"get": spec{
+				"operationId": "listVideos",
+				"summary":     "List videos",
+				"parameters": []spec{
+					{"name": "title", "in": "query", "schema": spec{"type": "string"}, "description": "Full-text filter on title"},
+					{"name": "description", "in": "query", "schema": spec{"type": "string"}, "description": "Full-text filter on description"},
+					{"name": "transcript", "in": "query", "schema": spec{"type": "string"}, "description": "Full-text filter on the text of caption tracks in any language"},
+					{"name": "page", "in": "query", "schema": spec{"type": "integer", "minimum": 1, "maximum": 10_000_000, "default": 1}},
+					{"name": "page_size", "in": "query", "schema": spec{"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+					{"name": "sort", "in": "query", "schema": spec{"type": "string", "enum": videoSortSafelist, "default": "video_id"}, "description": "Prefix with - for descending order"},
+				},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{
+						"type": "object",
+						"properties": spec{
+							"metadata": schemaRef("Metadata"),
+							"videos":   spec{"type": "array", "items": schemaRef("Video")},
+						},
+					}),
+				}, 422, 500),
+			},
+		},
+		"/v1/videos/batch": spec{
+			"post": spec{
+				"operationId": "batchVideos",
+				"summary":     "Apply several updates and deletes in one transaction",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("BatchRequest"))},
+				"responses": withErrors(spec{
+					"200":     jsonResponse("Batch processed", schemaRef("BatchResponse")),
+					"default": jsonResponse("Atomic batch rolled back; status is that of the failed operation", schemaRef("BatchResponse")),
+				}, 400, 422, 500),
+			},
+		},
+		"/v1/videos/import/youtube": spec{
+			"post": spec{
+				"operationId": "importYouTubeVideos",
+				"summary":     "Create or refresh videos from their YouTube metadata",
+				"description": "Each YouTube video ID becomes a video_id. Existing videos are refreshed through the versioned update, keeping their type.",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("ImportRequest"))},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"results": spec{"type": "array", "items": schemaRef("ImportResult")}}}),
+				}, 400, 422, 500, 502),
+			},
+		},
+		"/v1/videos/{id}": spec{
+			"parameters": []spec{videoIDParameter},
+			"get": spec{
+				"operationId": "showVideo",
+				"summary":     "Get a video and its media assets",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{
+						"type": "object",
+						"properties": spec{
+							"video":  schemaRef("Video"),
+							"assets": assetList,
+						},
+					}),
+				}, 404, 500),
+			},
+			"patch": spec{
+				"operationId": "updateVideo",
+				"summary":     "Update a video",
+				"parameters": []spec{
+					{"name": "X-Expected-Version", "in": "header", "schema": spec{"type": "integer"}, "description": "Fail with 409 unless the video is at this version"},
+				},
+				"requestBody": spec{
+					"required": true,
+					"content": spec{
+						"application/json":             spec{"schema": schemaRef("VideoUpdate")},
+						"application/merge-patch+json": spec{"schema": spec{"type": "object"}},
+						"application/json-patch+json":  spec{"schema": spec{"type": "array", "items": schemaRef("JSONPatchOperation")}},
+					},
+				},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", videoEnvelope),
+				}, 400, 404, 409, 415, 422, 500),
+			},
+			"delete": spec{
+				"operationId": "deleteVideo",
+				"summary":     "Delete a video",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"message": spec{"type": "string"}}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/assets": spec{
+			"parameters": []spec{videoIDParameter},
+			"post": spec{
+				"operationId": "createAsset",
+				"summary":     "Start uploading a media asset",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("MediaAssetInput"))},
+				"responses": withErrors(spec{
+					"201": spec{
+						"description": "Created; upload the file in chunks to the Location URL",
+						"headers":     spec{"Location": spec{"schema": spec{"type": "string"}}},
+						"content":     jsonBody(assetEnvelope),
+					},
+				}, 400, 404, 422, 500),
+			},
+			"get": spec{
+				"operationId": "listAssets",
+				"summary":     "List the media assets of a video",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"assets": assetList}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/assets/{asset_id}": spec{
+			"parameters": []spec{
+				videoIDParameter,
+				{"name": "asset_id", "in": "path", "required": true, "schema": spec{"type": "integer", "minimum": 1}},
+			},
+			"get": spec{
+				"operationId": "showAsset",
+				"summary":     "Get a media asset and its upload progress",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", assetEnvelope),
+				}, 404, 500),
+			},
+			"put": spec{
+				"operationId": "uploadAssetChunk",
+				"summary":     "Upload the next chunk of a media asset",
+				"parameters": []spec{
+					{"name": "Content-Range", "in": "header", "required": true, "schema": spec{"type": "string", "pattern": contentRangeRX.String()}, "description": "bytes start-end/total; start must equal uploaded_bytes"},
+				},
+				"requestBody": spec{
+					"required": true,
+					"content":  spec{"application/octet-stream": spec{"schema": spec{"type": "string", "format": "binary"}}},
+				},
+				"responses": withErrors(spec{
+					"200": jsonResponse("Chunk stored; status is complete after the last chunk", assetEnvelope),
+				}, 400, 404, 409, 413, 422, 500),
+			},
+		},
+		"/v1/videos/{id}/images/{kind}": spec{
+			"parameters": []spec{videoIDParameter, imageKindParameter},
+			"put": spec{
+				"operationId": "uploadImage",
+				"summary":     "Replace an image of a video",
+				"description": "The image is resized and centre-cropped into every configured rendition of its kind that it is large enough for.",
+				"requestBody": imageUploadBody,
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", videoEnvelope),
+				}, 400, 404, 413, 415, 422, 500),
+			},
+			"delete": spec{
+				"operationId": "deleteImage",
+				"summary":     "Delete an image of a video",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"message": spec{"type": "string"}}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/images/{kind}/{file}": spec{
+			"parameters": []spec{videoIDParameter, imageKindParameter, imageFileParameter},
+			"get": spec{
+				"operationId": "showImage",
+				"summary":     "Get an image rendition",
+				"responses":   imageRenditionResponses,
+			},
+		},
+		"/v1/videos/{id}/captions": spec{
+			"parameters": []spec{videoIDParameter},
+			"get": spec{
+				"operationId": "listCaptions",
+				"summary":     "List the caption tracks of a video",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"caption_tracks": spec{"type": "array", "items": schemaRef("CaptionTrack")}}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/captions/{lang}": spec{
+			"parameters": []spec{
+				videoIDParameter,
+				{"name": "lang", "in": "path", "required": true, "schema": spec{"type": "string"}, "description": "BCP 47 language tag, e.g. ar; followed by .vtt or .srt to get the track"},
+			},
+			"put": spec{
+				"operationId": "uploadCaptions",
+				"summary":     "Replace the caption track of a video in one language",
+				"description": "Without a caption Content-Type, files starting with WEBVTT are read as WebVTT and others as SRT.",
+				"requestBody": spec{
+					"required": true,
+					"content": spec{
+						"text/vtt":             spec{"schema": spec{"type": "string"}},
+						"application/x-subrip": spec{"schema": spec{"type": "string"}},
+						"text/plain":           spec{"schema": spec{"type": "string"}},
+					},
+				},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"caption_track": schemaRef("CaptionTrack")}}),
+				}, 400, 404, 413, 415, 422, 500),
+			},
+			"delete": spec{
+				"operationId": "deleteCaptions",
+				"summary":     "Delete the caption track of a video in one language",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"message": spec{"type": "string"}}}),
+				}, 404, 500),
+			},
+			"get": spec{
+				"operationId": "showCaptions",
+				"summary":     "Get a caption track as WebVTT ({lang}.vtt) or SRT ({lang}.srt)",
+				"responses": withErrors(spec{
+					"200": spec{
+						"description": "Caption file",
+						"content": spec{
+							"text/vtt":             spec{"schema": spec{"type": "string"}},
+							"application/x-subrip": spec{"schema": spec{"type": "string"}},
+						},
+					},
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/chapters": spec{
+			"parameters": []spec{videoIDParameter},
+			"post": spec{
+				"operationId": "createChapter",
+				"summary":     "Add a chapter to a video",
+				"description": "Chapters must lie within the length of the video, start at different times, and end before the next chapter starts.",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("ChapterInput"))},
+				"responses": withErrors(spec{
+					"201": spec{
+						"description": "Created",
+						"headers":     spec{"Location": spec{"schema": spec{"type": "string"}}},
+						"content":     jsonBody(chapterEnvelope),
+					},
+				}, 400, 404, 422, 500),
+			},
+			"get": spec{
+				"operationId": "listChapters",
+				"summary":     "List the chapters of a video in order of start time",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"chapters": spec{"type": "array", "items": schemaRef("Chapter")}}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/chapters.json": spec{
+			"parameters": []spec{videoIDParameter},
+			"get": spec{
+				"operationId": "showPodcastChapters",
+				"summary":     "Get the chapters of a video in the Podcasting 2.0 JSON chapters format",
+				"responses": withErrors(spec{
+					"200": spec{
+						"description": "JSON chapters file",
+						"content": spec{
+							"application/json+chapters": spec{"schema": spec{
+								"type": "object",
+								"properties": spec{
+									"version": spec{"type": "string"},
+									"chapters": spec{
+										"type": "array",
+										"items": spec{
+											"type": "object",
+											"properties": spec{
+												"startTime": spec{"type": "integer"},
+												"endTime":   spec{"type": "integer"},
+												"title":     spec{"type": "string"},
+												"img":       spec{"type": "string", "format": "uri"},
+											},
+										},
+									},
+								},
+							}},
+						},
+					},
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/chapters/{chapter_id}": spec{
+			"parameters": []spec{
+				videoIDParameter,
+				{"name": "chapter_id", "in": "path", "required": true, "schema": spec{"type": "integer", "minimum": 1}},
+			},
+			"get": spec{
+				"operationId": "showChapter",
+				"summary":     "Get a chapter",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", chapterEnvelope),
+				}, 404, 500),
+			},
+			"patch": spec{
+				"operationId": "updateChapter",
+				"summary":     "Update a chapter",
+				"parameters": []spec{
+					{"name": "X-Expected-Version", "in": "header", "schema": spec{"type": "integer"}, "description": "Fail with 409 unless the chapter is at this version"},
+				},
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("ChapterUpdate"))},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", chapterEnvelope),
+				}, 400, 404, 409, 422, 500),
+			},
+			"delete": spec{
+				"operationId": "deleteChapter",
+				"summary":     "Delete a chapter",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"message": spec{"type": "string"}}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/jobs": spec{
+			"parameters": []spec{videoIDParameter},
+			"get": spec{
+				"operationId": "listJobs",
+				"summary":     "List the background jobs of a video, newest first",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"jobs": spec{"type": "array", "items": schemaRef("Job")}}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/playback-url": spec{
+			"parameters": []spec{videoIDParameter},
+			"post": spec{
+				"operationId": "mintPlaybackURL",
+				"summary":     "Mint a signed URL for the master playlist of a published video",
+				"description": "For the service that authenticates users and checks their entitlement to the video.",
+				"security":    []spec{{"mintAPIKey": []string{}}},
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("PlaybackURLInput"))},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"playback_url": schemaRef("PlaybackURL")}}),
+				}, 400, 401, 404, 422, 500),
+			},
+		},
+		"/v1/videos/{id}/playback.m3u8": spec{
+			"parameters": append([]spec{videoIDParameter}, signedURLParameters...),
+			"get": spec{
+				"operationId": "playback",
+				"summary":     "Get the HLS master playlist of a published video through a signed URL",
+				"description": "Lists every rendition with a signed, expiring URL for its media playlist. Unpublished and not yet transcoded videos are reported as not found.",
+				"responses": withErrors(spec{
+					"200": spec{
+						"description": "HLS master playlist",
+						"content":     spec{hlsContentType: spec{"schema": spec{"type": "string"}}},
+					},
+				}, 403, 404, 500),
+			},
+		},
+		"/v1/videos/{id}/hls/{rendition}/{file}": spec{
+			"parameters": append([]spec{
+				videoIDParameter,
+				{"name": "rendition", "in": "path", "required": true, "schema": spec{"type": "string"}, "description": "Rendition name, e.g. 720p"},
+				{"name": "file", "in": "path", "required": true, "schema": spec{"type": "string"}, "description": "Media playlist or segment file name"},
+			}, signedURLParameters...),
+			"get": spec{
+				"operationId": "showHLSFile",
+				"summary":     "Get a media playlist or segment through a signed URL",
+				"description": "Only reachable through the signed URLs in a master or media playlist.",
+				"responses": withErrors(spec{
+					"200": spec{
+						"description": "Media playlist, with signed segment URLs, or segment",
+						"content": spec{
+							hlsContentType: spec{"schema": spec{"type": "string"}},
+							"video/mp2t":   spec{"schema": spec{"type": "string", "format": "binary"}},
+						},
+					},
+				}, 403, 404, 500),
+			},
+		},
+		"/v1/shows": spec{
+			"post": spec{
+				"operationId": "createShow",
+				"summary":     "Create a show",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("ShowInput"))},
+				"responses": withErrors(spec{
+					"201": spec{
+						"description": "Created",
+						"headers":     spec{"Location": spec{"schema": spec{"type": "string"}}},
+						"content":     jsonBody(showEnvelope),
+					},
+				}, 400, 422, 500),
+			},
+			"get": spec{
+				"operationId": "listShows",
+				"summary":     "List shows",
+				"parameters": []spec{
+					{"name": "title", "in": "query", "schema": spec{"type": "string"}, "description": "Full-text filter on title"},
+					{"name": "page", "in": "query", "schema": spec{"type": "integer", "minimum": 1, "maximum": 10_000_000, "default": 1}},
+					{"name": "page_size", "in": "query", "schema": spec{"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+					{"name": "sort", "in": "query", "schema": spec{"type": "string", "enum": showSortSafelist, "default": "id"}, "description": "Prefix with - for descending order"},
+				},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{
+						"type": "object",
+						"properties": spec{
+							"metadata": schemaRef("Metadata"),
+							"shows":    spec{"type": "array", "items": schemaRef("Show")},
+						},
+					}),
+				}, 422, 500),
+			},
+		},
+		"/v1/shows/{id}": spec{
+			"parameters": []spec{showIDParameter},
+			"get": spec{
+				"operationId": "showShow",
+				"summary":     "Get a show",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", showEnvelope),
+				}, 404, 500),
+			},
+			"patch": spec{
+				"operationId": "updateShow",
+				"summary":     "Update a show",
+				"parameters": []spec{
+					{"name": "X-Expected-Version", "in": "header", "schema": spec{"type": "integer"}, "description": "Fail with 409 unless the show is at this version"},
+				},
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("ShowUpdate"))},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", showEnvelope),
+				}, 400, 404, 409, 422, 500),
+			},
+			"delete": spec{
+				"operationId": "deleteShow",
+				"summary":     "Delete a show and its images",
+				"responses":   withErrors(spec{"200": messageResponse}, 404, 500),
+			},
+		},
+		"/v1/shows/{id}/images/{kind}": spec{
+			"parameters": []spec{showIDParameter, showImageKindParameter},
+			"put": spec{
+				"operationId": "uploadShowImage",
+				"summary":     "Replace an image of a show",
+				"description": "The image is resized and centre-cropped into every configured rendition of its kind that it is large enough for.",
+				"requestBody": imageUploadBody,
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", showEnvelope),
+				}, 400, 404, 413, 415, 422, 500),
+			},
+			"delete": spec{
+				"operationId": "deleteShowImage",
+				"summary":     "Delete an image of a show",
+				"responses":   withErrors(spec{"200": messageResponse}, 404, 500),
+			},
+		},
+		"/v1/shows/{id}/images/{kind}/{file}": spec{
+			"parameters": []spec{showIDParameter, showImageKindParameter, imageFileParameter},
+			"get": spec{
+				"operationId": "showShowImage",
+				"summary":     "Get an image rendition of a show",
+				"responses":   imageRenditionResponses,
+			},
+		},
+		"/v1/sync-runs": spec{
+			"post": spec{
+				"operationId": "createSyncRun",
+				"summary":     "Sync the uploads of a channel in the background",
+				"description": "Every upload of the channel is created, or refreshed through the versioned update, as by the YouTube import. Poll the run for its outcome.",
+				"requestBody": spec{"required": true, "content": jsonBody(schemaRef("SyncRunInput"))},
+				"responses": withErrors(spec{
+					"202": spec{
+						"description": "Accepted",
+						"headers":     spec{"Location": spec{"schema": spec{"type": "string"}}},
+						"content":     jsonBody(spec{"type": "object", "properties": spec{"sync_run": schemaRef("SyncRun")}}),
+					},
+				}, 400, 409, 422, 500),
+			},
+			"get": spec{
+				"operationId": "listSyncRuns",
+				"summary":     "List sync runs, newest first",
+				"parameters": []spec{
+					{"name": "channel_id", "in": "query", "schema": spec{"type": "string"}, "description": "Only list the runs of this channel"},
+					{"name": "page", "in": "query", "schema": spec{"type": "integer", "minimum": 1, "maximum": 10_000_000, "default": 1}},
+					{"name": "page_size", "in": "query", "schema": spec{"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+				},
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{
+						"type": "object",
+						"properties": spec{
+							"metadata":  schemaRef("Metadata"),
+							"sync_runs": spec{"type": "array", "items": schemaRef("SyncRun")},
+						},
+					}),
+				}, 422, 500),
+			},
+		},
+		"/v1/sync-runs/{run_id}": spec{
+			"parameters": []spec{
+				{"name": "run_id", "in": "path", "required": true, "schema": spec{"type": "integer", "minimum": 1}},
+			},
+			"get": spec{
+				"operationId": "showSyncRun",
+				"summary":     "Get a sync run with the videos it changed",
+				"responses": withErrors(spec{
+					"200": jsonResponse("OK", spec{"type": "object", "properties": spec{"sync_run": schemaRef("SyncRun")}}),
+				}, 404, 500),
+			},
+		},
+		"/v1/healthz": spec{
+			"get": spec{
+				"operationId": "liveness",
+				"summary":     "Liveness probe",
+				"responses":   spec{"200": jsonResponse("Alive", schemaRef("Health"))},
+			},
+		},
+		"/v1/healthcheck": spec{
+			"get": spec{
+				"operationId": "healthcheck",
+				"summary":     "Liveness probe (deprecated alias of /v1/healthz)",
+				"deprecated":  true,
+				"responses":   spec{"200": jsonResponse("Alive", schemaRef("Health"))},
+			},
+		},
+		"/v1/readyz": spec{
+			"get": spec{
+				"operationId": "readiness",
+				"summary":     "Readiness probe",
+				"responses": spec{
+					"200": jsonResponse("Ready", schemaRef("Health")),
+					"503": jsonResponse("Not ready", schemaRef("Health")),
+				},
+			},
+		},
+		"/v1/openapi.json": spec{
+			"get": spec{
+				"operationId": "openAPI",
+				"summary":     "This document",
+				"responses":   spec{"200": jsonResponse("OK", spec{"type": "object"})},
+			},
+		},
+		"/metrics": spec{
+			"get": spec{
+				"operationId": "metrics",
+				"summary":     "Prometheus metrics",
+				"responses": spec{
+					"200": spec{
+						"description": "Prometheus text exposition format",
+						"content":     spec{"text/plain": spec{"schema": spec{"type": "string"}}},
+					},
+				},
+			},
+		},
+	}
+
+	return spec{
+		"openapi": "3.1.0",
+		"info": spec{
+			"title":   "Thmanyah API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": spec{
+			"schemas": schemas,
+			"securitySchemes": spec{
+				"mintAPIKey": spec{"type": "http", "scheme": "bearer", "description": "One of the server's -playback-mint-api-keys"},
+			},
+			"responses": spec{
+				"Error": spec{
+					"description": "Error. Send Accept: application/problem+json to receive an RFC 9457 problem document.",
+					"content": spec{
+						"application/json":         spec{"schema": schemaRef("Error")},
+						"application/problem+json": spec{"schema": schemaRef("Problem")},
+					},
+				},
+			},
+		},
+	}
+}
+
+var routeParamRX = regexp.MustCompile(`:([^/]+)`)
+
+// verifyOpenAPISpec checks that every registered route has an operation in
+// the spec.
+func verifyOpenAPISpec(s spec, routes []route) error {
+	paths, _ := s["paths"].(spec)
+
+	var missing []string
+
+	for _, rt := range routes {
+		path := routeParamRX.ReplaceAllString(rt.path, "{$1}")
+
+		item, _ := paths[path].(spec)
+		if _, ok := item[strings.ToLower(rt.method)]; !ok {
+			missing = append(missing, rt.method+" "+rt.path)
+		}
+	}
+
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("routes missing from the OpenAPI spec: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope(app.openAPISpec()), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/jsonpatch"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// editableVideoFields are the members of the Video JSON representation that a
+// patch document may change; every other member is read-only.
+var editableVideoFields = map[string]bool{
+	"title":        true,
+	"description":  true,
+	"type":         true,
+	"length":       true,
+	"language":     true,
+	"published_at": true,
+}
+
+var errPatchFailed = errors.New("patch could not be applied")
+
+// requestMediaType returns the request's media type without parameters, or
+// "" when no Content-Type was sent.
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
+
+// readVideoPatch reads a merge patch or JSON patch document from the request
+// body and applies it to video. Errors wrapping jsonpatch.ErrTestFailed or
+// errPatchFailed mean the document was well-formed but couldn't be applied.
+func (app *application) readVideoPatch(w http.ResponseWriter, r *http.Request, mediaType string, video *data.Video) error {
+	var apply func(doc any) (any, error)
+
+	switch mediaType {
+	case mergePatchMediaType:
+		var patch map[string]any
+
+		err := app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+
+		apply = func(doc any) (any, error) {
+			return jsonpatch.Merge(doc, patch), nil
+		}
+
+	case jsonPatchMediaType:
+		var ops []jsonpatch.Operation
+
+		err := app.readJSON(w, r, &ops)
+		if err != nil {
+			return err
+		}
+
+		apply = func(doc any) (any, error) {
+			return jsonpatch.Apply(doc, ops)
+		}
+	}
+
+	return patchVideo(video, apply)
+}
+
+// patchVideo applies fn to the JSON representation of video and copies the
+// editable fields of the result back. Editable fields removed by the patch are
+// reset to their zero value, so validation reports them as missing.
+func patchVideo(video *data.Video, fn func(doc any) (any, error)) error {
+	original, err := toJSONObject(video)
+	if err != nil {
+		return err
+	}
+
+	result, err := fn(original)
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errPatchFailed, err)
+	}
+
+	patched, ok := result.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: the patched document must be a JSON object", errPatchFailed)
+	}
+
+	editable := make(map[string]any)
+
+	for key, value := range patched {
+		if editableVideoFields[key] {
+			editable[key] = value
+			continue
+		}
+
+		originalValue, exists := original.(map[string]any)[key]
+		if !exists {
+			return fmt.Errorf("%w: unknown field %q", errPatchFailed, key)
+		}
+		if !jsonpatch.Equal(originalValue, value) {
+			return fmt.Errorf("%w: field %q is read-only", errPatchFailed, key)
+		}
+	}
+
+	for key := range original.(map[string]any) {
+		if _, ok := patched[key]; !ok && !editableVideoFields[key] {
+			return fmt.Errorf("%w: field %q is read-only", errPatchFailed, key)
+		}
+	}
+
+	jsn, err := json.Marshal(editable)
+	if err != nil {
+		return err
+	}
+
+	var input struct {
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		Type        string    `json:"type"`
+		Length      int       `json:"length"`
+		Language    string    `json:"language"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+
+	err = json.Unmarshal(jsn, &input)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		if errors.As(err, &unmarshalTypeError) {
+			return fmt.Errorf("%w: incorrect JSON type for field %q", errPatchFailed, unmarshalTypeError.Field)
+		}
+		return fmt.Errorf("%w: %w", errPatchFailed, err)
+	}
+
+	video.Title = input.Title
+	video.Description = input.Description
+	video.Type = input.Type
+	video.Length = input.Length
+	video.Language = input.Language
+	video.PublishedAt = input.PublishedAt
+
+	return nil
+}
+
+func toJSONObject(v any) (any, error) {
+	jsn, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(jsn))
+	dec.UseNumber()
+
+	var doc any
+	err = dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
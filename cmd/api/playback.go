@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/storage"
+	"github.com/JLL32/thmanyah/internal/transcode"
+	"github.com/JLL32/thmanyah/internal/urlsign"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+const hlsContentType = "application/vnd.apple.mpegurl"
+
+// maxMediaPlaylistSize bounds how much of a stored media playlist is read
+// for rewriting. A playlist of a ten-hour video with 6s segments is about
+// 300 KB.
+const maxMediaPlaylistSize = 4 << 20
+
+// readPlayback returns the renditions of the video in the request, or sends a
+// 404 if the video doesn't exist, isn't published or hasn't been transcoded.
+// Unpublished videos look the same as missing ones, so their IDs don't leak.
+func (app *application) readPlayback(w http.ResponseWriter, r *http.Request) (string, []*data.Rendition, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return "", nil, false
+	}
+
+	video, err := app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return "", nil, false
+	}
+
+	if !video.Published(time.Now()) {
+		app.notFoundResponse(w, r)
+		return "", nil, false
+	}
+
+	renditions, err := app.models.Renditions.GetAllForVideo(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return "", nil, false
+	}
+
+	if len(renditions) == 0 {
+		app.notFoundResponse(w, r)
+		return "", nil, false
+	}
+
+	return id, renditions, true
+}
+
+// hlsPath is the path under which a file of a rendition is served.
+func hlsPath(videoID, rendition, file string) string {
+	return fmt.Sprintf("/v1/videos/%s/hls/%s/%s", videoID, rendition, file)
+}
+
+func writePlaylist(w http.ResponseWriter, playlist []byte) {
+	// Playlists embed signed URLs that expire, so they mustn't be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", hlsContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(playlist)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(playlist)
+}
+
+// playbackHandler serves the HLS master playlist of a video, listing each
+// rendition with a signed URL for its media playlist. It is reached through a
+// URL from mintPlaybackURLHandler, and the URLs it hands out are bound to the
+// same IP address as that one.
+func (app *application) playbackHandler(w http.ResponseWriter, r *http.Request) {
+	id, renditions, ok := app.readPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	token := app.contextGetSignedURL(r)
+	expires := app.nestedURLExpiry(token)
+
+	var buf bytes.Buffer
+
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, rendition := range renditions {
+		fmt.Fprintf(&buf, "#EXT-X-STREAM-INF:BANDWIDTH=%d", rendition.Bandwidth)
+
+		// Players pick variants by RESOLUTION, so it is only sent when the
+		// encoded size is known: audio renditions have none, and sizes
+		// recorded before transcodes reported them were cleared.
+		if rendition.Width > 0 && rendition.Height > 0 {
+			fmt.Fprintf(&buf, ",RESOLUTION=%dx%d", rendition.Width, rendition.Height)
+		}
+		fmt.Fprintf(&buf, ",CODECS=%q\n", rendition.Codecs)
+
+		buf.WriteString(app.urlSigner.SignURL(hlsPath(id, rendition.Name, path.Base(rendition.PlaylistKey)), expires, token.IP))
+		buf.WriteByte('\n')
+	}
+
+	writePlaylist(w, buf.Bytes())
+}
+
+// showHLSFileHandler serves the media playlist or a segment of a rendition,
+// through a signed URL from the playlist above them. Media playlists are
+// rewritten so that each segment URL is signed as well.
+func (app *application) showHLSFileHandler(w http.ResponseWriter, r *http.Request) {
+	id, renditions, ok := app.readPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	i := slices.IndexFunc(renditions, func(rendition *data.Rendition) bool {
+		return rendition.Name == params.ByName("rendition")
+	})
+	if i < 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	rendition := renditions[i]
+	key := path.Join(path.Dir(rendition.PlaylistKey), params.ByName("file"))
+
+	if !slices.Contains(rendition.BlobKeys, key) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	blob, err := app.blobs.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer blob.Close()
+
+	if key == rendition.PlaylistKey {
+		playlist, err := app.signMediaPlaylist(blob, id, rendition.Name, app.contextGetSignedURL(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		writePlaylist(w, playlist)
+		return
+	}
+
+	// Segments never change: every transcode writes them under new keys.
+	w.Header().Set("Cache-Control", "private, max-age=86400, immutable")
+	w.Header().Set("Content-Type", transcode.ContentType(key))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// nestedURLExpiry returns when the URLs signed for a playlist fetched through
+// the signed URL token expire: with it, so that re-signing them on every fetch
+// can't keep one minted URL working past its own expiry.
+func (app *application) nestedURLExpiry(token urlsign.Token) time.Time {
+	expires := time.Now().Add(app.config.playback.urlTTL)
+
+	if !token.Expires.IsZero() && token.Expires.Before(expires) {
+		return token.Expires
+	}
+
+	return expires
+}
+
+// signMediaPlaylist copies a stored media playlist, replacing each segment
+// URI, which is relative to the playlist, with a URL signed like the one the
+// playlist was fetched through.
+func (app *application) signMediaPlaylist(playlist io.Reader, videoID, rendition string, token urlsign.Token) ([]byte, error) {
+	expires := app.nestedURLExpiry(token)
+	ip := token.IP
+
+	var buf bytes.Buffer
+
+	scanner := bufio.NewScanner(io.LimitReader(playlist, maxMediaPlaylistSize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = app.urlSigner.SignURL(hlsPath(videoID, rendition, line), expires, ip)
+		}
+
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mintPlaybackURLHandler hands out a signed URL for the master playlist of a
+// published video. There are no user accounts in this API: the service that
+// signs users in and checks their entitlement to a video calls it with a
+// mint API key, and passes the URL on to the user's player.
+func (app *application) mintPlaybackURLHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.authenticateMintRequest(r) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ClientIP string `json:"client_ip"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	var ip string
+	if input.ClientIP != "" {
+		addr, err := netip.ParseAddr(input.ClientIP)
+		if v.Check(err == nil, "client_ip", "must be an IPv4 or IPv6 address"); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		ip = addr.Unmap().String()
+	}
+
+	video, err := app.models.Videos.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !video.Published(time.Now()) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	expires := time.Now().Add(app.config.playback.urlTTL)
+
+	playbackURL := envelope{
+		"url":        app.urlSigner.SignURL(fmt.Sprintf("/v1/videos/%s/playback.m3u8", id), expires, ip),
+		"expires_at": expires.UTC().Truncate(time.Second),
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"playback_url": playbackURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authenticateMintRequest reports whether the request carries one of the
+// -playback-mint-api-keys as a bearer token.
+func (app *application) authenticateMintRequest(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	valid := false
+	for _, key := range app.config.playback.mintAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/urlsign"
+)
+
+func TestSignMediaPlaylistKeepsParentExpiry(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.playback.urlTTL = time.Hour
+	app.urlSigner = &urlsign.Signer{Keys: map[string][]byte{"k": []byte(strings.Repeat("s", urlsign.MinKeyLength))}, KeyID: "k"}
+
+	// A playlist fetched through a URL with ten minutes left.
+	parent := urlsign.Token{KeyID: "k", Expires: time.Now().Add(10 * time.Minute).Truncate(time.Second), IP: "203.0.113.7"}
+
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000,\nsegment_00000.ts\n#EXTINF:6.000,\nsegment_00001.ts\n#EXT-X-ENDLIST\n"
+
+	signed, err := app.signMediaPlaylist(strings.NewReader(playlist), "dQw4w9WgXcQ", "1080p", parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(signed))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		segments++
+
+		u, err := url.Parse(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := app.urlSigner.Verify(u.Path, u.Query(), time.Now(), parent.IP)
+		if err != nil {
+			t.Fatalf("verifying %s: %v", line, err)
+		}
+
+		if !token.Expires.Equal(parent.Expires) {
+			t.Errorf("segment URL expires at %v; want the playlist URL's %v", token.Expires, parent.Expires)
+		}
+	}
+
+	if segments != 2 {
+		t.Errorf("got %d segment URLs; want 2", segments)
+	}
+}
+
+func TestNestedURLExpiry(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.playback.urlTTL = time.Hour
+
+	soon := time.Now().Add(time.Minute)
+	if got := app.nestedURLExpiry(urlsign.Token{Expires: soon}); !got.Equal(soon) {
+		t.Errorf("got %v; want the parent's expiry %v", got, soon)
+	}
+
+	// Without a parent, URLs get the full TTL.
+	if got := app.nestedURLExpiry(urlsign.Token{}); time.Until(got) < 59*time.Minute {
+		t.Errorf("got %v without a parent; want an hour from now", got)
+	}
+}
@@ -2,23 +2,166 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// router wraps httprouter.Router so that every registered handler records its
+// route pattern in the request state, which httprouter doesn't expose itself.
+//
+// It also serves exact-match routes registered with StaticHandlerFunc ahead of
+// httprouter, which refuses to register a static segment such as
+// /v1/videos/batch next to a wildcard such as /v1/videos/:id/assets for the
+// same method.
+type router struct {
+	*httprouter.Router
+	app    *application
+	routes []route
+	static map[string]map[string]http.HandlerFunc
+}
+
+type route struct {
+	method string
+	path   string
+}
+
+func (rt *router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, route{method: method, path: path})
+	rt.Router.HandlerFunc(method, path, rt.withRoute(path, handler))
+}
+
+// StaticHandlerFunc registers a route without wildcards that is matched
+// before httprouter's own routes.
+func (rt *router) StaticHandlerFunc(method, path string, handler http.HandlerFunc) {
+	if rt.static == nil {
+		rt.static = make(map[string]map[string]http.HandlerFunc)
+	}
+	if rt.static[path] == nil {
+		rt.static[path] = make(map[string]http.HandlerFunc)
+	}
+
+	rt.routes = append(rt.routes, route{method: method, path: path})
+	rt.static[path][method] = rt.withRoute(path, handler)
+}
+
+func (rt *router) withRoute(path string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state := rt.app.contextGetRequestState(r); state != nil {
+			state.route = path
+		}
+
+		handler(w, r)
+	}
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	methods, ok := rt.static[r.URL.Path]
+	if !ok {
+		rt.Router.ServeHTTP(w, r)
+		return
+	}
+
+	if handler, ok := methods[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+
+	if r.Method == http.MethodOptions && rt.HandleOPTIONS {
+		w.Header().Set("Allow", rt.allowed(r.URL.Path))
+		rt.GlobalOPTIONS.ServeHTTP(w, r)
+		return
+	}
+
+	rt.Router.ServeHTTP(w, r)
+}
+
+// allowed lists the methods that path can be requested with, across both the
+// static routes and httprouter's.
+func (rt *router) allowed(path string) string {
+	var allow []string
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		_, static := rt.static[path][method]
+		if handle, _, _ := rt.Lookup(method, path); static || handle != nil {
+			allow = append(allow, method)
+		}
+	}
+
+	return strings.Join(append(allow, http.MethodOptions), ", ")
+}
+
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := app.registerRoutes()
+
+	return app.requestID(app.enableCORS(app.traceRequests(app.logRequests(app.recordMetrics(app.recoverPanic(router))))))
+}
+
+// registerRoutes returns the router with every route of the API registered.
+// routes_test.go checks each of them against the OpenAPI spec.
+func (app *application) registerRoutes() *router {
+	router := &router{Router: httprouter.New(), app: app}
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	router.GlobalOPTIONS = http.HandlerFunc(app.corsPreflight)
 
-	router.HandlerFunc(http.MethodPost, "/v1/videos", app.createVideoHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/videos", app.idempotency(app.createVideoHandler))
+	router.StaticHandlerFunc(http.MethodPost, "/v1/videos/batch", app.idempotency(app.batchVideosHandler))
+	router.StaticHandlerFunc(http.MethodPost, "/v1/videos/import/youtube", app.idempotency(app.importYouTubeHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/videos/:id", app.showVideoHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/videos/:id", app.updateVideoHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/videos/:id", app.deleteVideoHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/videos/:id", app.idempotency(app.updateVideoHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/videos/:id", app.idempotency(app.deleteVideoHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/videos", app.listVideosHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/videos/:id/assets", app.idempotency(app.createAssetHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/assets", app.listAssetsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/assets/:asset_id", app.showAssetHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/videos/:id/assets/:asset_id", app.uploadAssetChunkHandler)
+
+	router.HandlerFunc(http.MethodPut, "/v1/videos/:id/images/:kind", app.uploadImageHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/videos/:id/images/:kind", app.idempotency(app.deleteImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/images/:kind/:file", app.showImageHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/captions", app.listCaptionsHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/videos/:id/captions/:lang", app.uploadCaptionsHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/videos/:id/captions/:lang", app.idempotency(app.deleteCaptionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/captions/:lang", app.showCaptionsHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/videos/:id/chapters", app.idempotency(app.createChapterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/chapters", app.listChaptersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/chapters.json", app.showPodcastChaptersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/chapters/:chapter_id", app.showChapterHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/videos/:id/chapters/:chapter_id", app.idempotency(app.updateChapterHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/videos/:id/chapters/:chapter_id", app.idempotency(app.deleteChapterHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/jobs", app.listJobsHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/videos/:id/playback-url", app.mintPlaybackURLHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/playback.m3u8", app.requireSignedURL(app.playbackHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/hls/:rendition/:file", app.requireSignedURL(app.showHLSFileHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/shows", app.idempotency(app.createShowHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shows", app.listShowsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/shows/:id", app.showShowHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/shows/:id", app.idempotency(app.updateShowHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/shows/:id", app.idempotency(app.deleteShowHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/shows/:id/images/:kind", app.uploadShowImageHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/shows/:id/images/:kind", app.idempotency(app.deleteShowImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shows/:id/images/:kind/:file", app.showShowImageHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/sync-runs", app.idempotency(app.createSyncRunHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sync-runs", app.listSyncRunsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/sync-runs/:run_id", app.showSyncRunHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/healthz", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/readyz", app.readinessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.livenessHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.openAPIHandler)
+
+	router.HandlerFunc(http.MethodGet, "/metrics", app.metricsHandler)
 
-	return app.recoverPanic(router)
+	return router
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpecDescribesEveryRoute(t *testing.T) {
+	app := newTestApplication(t)
+
+	router := app.registerRoutes()
+
+	err := verifyOpenAPISpec(app.openAPISpec(), router.routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyOpenAPISpecReportsUndocumentedRoutes(t *testing.T) {
+	app := newTestApplication(t)
+
+	router := app.registerRoutes()
+	router.HandlerFunc(http.MethodGet, "/v1/videos/:id/undocumented", app.notFoundResponse)
+
+	err := verifyOpenAPISpec(app.openAPISpec(), router.routes)
+	if err == nil || !strings.Contains(err.Error(), "GET /v1/videos/:id/undocumented") {
+		t.Fatalf("got error %v; want one naming the undocumented route", err)
+	}
+}
@@ -2,24 +2,90 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
 func (app *application) serve() error {
+	useTLS := app.config.tls.certFile != "" || app.config.tls.keyFile != ""
+
+	if useTLS && (app.config.tls.certFile == "" || app.config.tls.keyFile == "") {
+		return errors.New("both -tls-cert and -tls-key must be provided")
+	}
+	if !useTLS && app.config.tls.redirectPort != 0 {
+		return errors.New("-tls-redirect-port requires -tls-cert and -tls-key")
+	}
+
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		IdleTimeout:       app.config.server.idleTimeout,
+		ReadTimeout:       app.config.server.readTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		WriteTimeout:      app.config.server.writeTimeout,
+		MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Protocols:         new(http.Protocols),
+	}
+
+	srv.Protocols.SetHTTP1(true)
+	if app.config.server.http2 {
+		if useTLS {
+			srv.Protocols.SetHTTP2(true)
+		} else {
+			srv.Protocols.SetUnencryptedHTTP2(true)
+		}
+	}
+
+	var redirectSrv *http.Server
+
+	if useTLS {
+		certs, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+
+			for range hup {
+				err := certs.reload()
+				if err != nil {
+					app.logger.Error("reloading TLS certificate", "error", err)
+					continue
+				}
+
+				app.logger.Info("reloaded TLS certificate", "cert", app.config.tls.certFile)
+			}
+		}()
+
+		if app.config.tls.redirectPort != 0 {
+			redirectSrv = &http.Server{
+				Addr:              fmt.Sprintf(":%d", app.config.tls.redirectPort),
+				Handler:           app.redirectToHTTPS(),
+				IdleTimeout:       app.config.server.idleTimeout,
+				ReadTimeout:       app.config.server.readTimeout,
+				ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+				WriteTimeout:      app.config.server.writeTimeout,
+				MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+				ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+			}
+		}
 	}
 
 	shutdownError := make(chan error)
@@ -27,15 +93,24 @@ func (app *application) serve() error {
 	go func() {
 		quit := make(chan os.Signal, 1)
 
-		signal.Notify(quit, syscall.SIGTTIN, syscall.SIGTERM)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 		s := <-quit
 
 		app.logger.Info("shutting down server", "signal", s.String())
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Fail readiness checks first and give load balancers a chance to
+		// notice before we stop accepting connections.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.server.drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
 		defer cancel()
 
+		if redirectSrv != nil {
+			redirectSrv.Shutdown(ctx)
+		}
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
@@ -43,13 +118,30 @@ func (app *application) serve() error {
 
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
+		app.stopBackground()
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+	if redirectSrv != nil {
+		go func() {
+			app.logger.Info("starting HTTPS redirect server", "addr", redirectSrv.Addr)
 
-	err := srv.ListenAndServe()
+			err := redirectSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error(err.Error(), "addr", redirectSrv.Addr)
+			}
+		}()
+	}
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "tls", useTLS)
+
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -61,3 +153,23 @@ func (app *application) serve() error {
 	app.logger.Info("server stopped", "addr", srv.Addr)
 	return nil
 }
+
+// redirectToHTTPS permanently redirects every request to the same URL on the
+// HTTPS listener.
+func (app *application) redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+
+		if app.config.port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(app.config.port))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+
+		w.Header().Set("Connection", "close")
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
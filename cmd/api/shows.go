@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) readShowIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
+func (app *application) createShowHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	show := &data.Show{
+		Title:       input.Title,
+		Description: input.Description,
+	}
+
+	v := validator.New()
+	if data.ValidateShow(v, show); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Shows.Insert(r.Context(), show)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/shows/%d", show.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"show": show}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showShowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readShowIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	show, err := app.models.Shows.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.setShowThumbnails(r.Context(), show)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"show": show}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateShowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readShowIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	show, err := app.models.Shows.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if version := r.Header.Get("X-Expected-Version"); version != "" {
+		if strconv.Itoa(show.Version) != version {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		show.Title = *input.Title
+	}
+	if input.Description != nil {
+		show.Description = *input.Description
+	}
+
+	v := validator.New()
+	if data.ValidateShow(v, show); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Shows.Update(r.Context(), show)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.setShowThumbnails(r.Context(), show)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"show": show}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteShowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readShowIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The image rows go with the show (ON DELETE CASCADE), so read their
+	// blob keys first to clean them up afterwards.
+	images, err := app.models.ShowImages.GetAllForShows(r.Context(), []int64{id})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Shows.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	keys := make([]string, len(images))
+	for i, img := range images {
+		keys[i] = img.StorageKey
+	}
+
+	app.deleteBlobs(keys...)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "show successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+var showSortSafelist = []string{"id", "title", "created_at", "-id", "-title", "-created_at"}
+
+func (app *application) listShowsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = showSortSafelist
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	shows, metadata, err := app.models.Shows.GetAll(r.Context(), input.Title, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.setShowThumbnails(r.Context(), shows...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"metadata": metadata, "shows": shows}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// uploadShowImageHandler replaces the artwork of a show, rendered into the
+// same configured sizes as video artwork.
+func (app *application) uploadShowImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readShowIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	kind, err := app.readImageKindParam(r, data.ShowImageKinds)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	show, err := app.models.Shows.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, ok := app.readImageUpload(w, r, fmt.Sprintf("shows/%d", id), kind)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	oldKeys, err := app.replaceShowImages(ctx, id, kind, images)
+	if err != nil {
+		app.deleteBlobs(imageKeys(images)...)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.deleteBlobs(oldKeys...)
+
+	err = app.setShowThumbnails(ctx, show)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"show": show}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceShowImages swaps the renditions of one kind of image of a show in a
+// transaction and returns the storage keys of the ones it replaced.
+func (app *application) replaceShowImages(ctx context.Context, showID int64, kind string, images []*data.ImageRendition) ([]string, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	models := app.models.WithTx(tx)
+
+	oldKeys, err := models.ShowImages.DeleteKind(ctx, showID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		err = models.ShowImages.Insert(ctx, &data.ShowImage{ShowID: showID, ImageRendition: *img})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oldKeys, tx.Commit()
+}
+
+func (app *application) deleteShowImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readShowIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	kind, err := app.readImageKindParam(r, data.ShowImageKinds)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	keys, err := app.models.ShowImages.DeleteKind(r.Context(), id, kind)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(keys) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.deleteBlobs(keys...)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showShowImageHandler serves a rendition of an image of a show, like
+// showImageHandler does for videos.
+func (app *application) showShowImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readShowIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	kind, err := app.readImageKindParam(r, data.ShowImageKinds)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	size, err := app.readImageFileParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	img, err := app.models.ShowImages.Get(r.Context(), id, kind, size.X, size.Y)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.serveImage(w, r, &img.ImageRendition)
+}
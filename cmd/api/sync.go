@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/provider"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// startSyncScheduler syncs every configured channel once at startup and then
+// on every tick of the sync interval, until the background context is
+// cancelled. With several instances running, a channel is synced by whichever
+// instance gets to it first once more than half the interval has passed since
+// its last run, so each channel is synced at least once per interval.
+func (app *application) startSyncScheduler() {
+	cfg := app.config.sync
+
+	if cfg.interval <= 0 || len(cfg.channels) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ticker := time.NewTicker(cfg.interval)
+		defer ticker.Stop()
+
+		for {
+			for _, channelID := range cfg.channels {
+				if app.backgroundCtx.Err() != nil {
+					return
+				}
+
+				run, err := app.startSyncRun(app.backgroundCtx, channelID, data.SyncTriggerSchedule, cfg.dryRun, cfg.interval/2)
+				if err != nil {
+					if !errors.Is(err, data.ErrSyncNotDue) && app.backgroundCtx.Err() == nil {
+						app.logger.Error(err.Error(), "job", "sync channel", "channel_id", channelID)
+					}
+					continue
+				}
+
+				app.runSync(run)
+			}
+
+			select {
+			case <-app.backgroundCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// startSyncRun records a new run of the channel, unless another one is in
+// progress or started less than minInterval ago, in which case it returns
+// data.ErrSyncNotDue.
+func (app *application) startSyncRun(ctx context.Context, channelID, trigger string, dryRun bool, minInterval time.Duration) (*data.SyncRun, error) {
+	run := &data.SyncRun{
+		Provider:  app.config.metadata.provider,
+		ChannelID: channelID,
+		Trigger:   trigger,
+		DryRun:    dryRun,
+	}
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = app.models.WithTx(tx).SyncRuns.Start(ctx, run, minInterval, app.config.sync.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, tx.Commit()
+}
+
+// runSync syncs the uploads of the run's channel, bounded by the sync
+// timeout, and records the outcome.
+func (app *application) runSync(run *data.SyncRun) {
+	logger := app.logger.With("sync_run_id", run.ID, "channel_id", run.ChannelID, "dry_run", run.DryRun)
+
+	ctx, cancel := context.WithTimeout(app.backgroundCtx, app.config.sync.timeout)
+	defer cancel()
+
+	// The outcome must be recorded even when the run was interrupted by
+	// shutdown.
+	recordCtx := context.WithoutCancel(ctx)
+
+	logger.Info("sync started", "trigger", run.Trigger)
+	start := time.Now()
+
+	err := app.syncUploads(ctx, run)
+	switch {
+	case err == nil:
+		run.Status = data.SyncRunStatusSucceeded
+	case app.backgroundCtx.Err() != nil:
+		run.Status = data.SyncRunStatusFailed
+		run.Error = "interrupted by shutdown"
+	default:
+		run.Status = data.SyncRunStatusFailed
+		run.Error = err.Error()
+	}
+
+	finishErr := app.models.SyncRuns.Finish(recordCtx, run)
+	if finishErr != nil {
+		logger.Error("finishing sync run", "error", finishErr)
+		return
+	}
+
+	logger.Info("sync "+run.Status, "duration", time.Since(start),
+		"created", run.Created, "updated", run.Updated, "unchanged", run.Unchanged, "invalid", run.Invalid,
+		"error", run.Error)
+}
+
+// syncUploads imports each video uploaded to the run's channel, counting the
+// results on run.
+func (app *application) syncUploads(ctx context.Context, run *data.SyncRun) error {
+	opts := importOptions{
+		Type:     app.config.sync.videoType,
+		Language: app.config.sync.language,
+		DryRun:   run.DryRun,
+	}
+
+	return walkUploads(ctx, app.metadataProvider, run.ChannelID, func(pv *provider.Video) error {
+		res, err := app.importVideo(ctx, pv, opts)
+		if err != nil {
+			return fmt.Errorf("video %s: %w", pv.ID, err)
+		}
+
+		switch res.Status {
+		case importStatusCreated:
+			run.Created++
+		case importStatusUpdated:
+			run.Updated++
+		case importStatusUnchanged:
+			run.Unchanged++
+			return nil
+		case importStatusInvalid:
+			run.Invalid++
+		}
+
+		run.Changes = append(run.Changes, data.SyncChange{
+			VideoID: res.VideoID,
+			Status:  res.Status,
+			Fields:  res.Changes,
+			Error:   res.Error,
+		})
+
+		return nil
+	})
+}
+
+// walkUploads pages through the uploads of a channel and calls fn with each
+// video once. Videos the provider won't show, such as private ones, are
+// skipped. It stops after the last page, or at a page token the provider has
+// already returned, so a provider that loops can't keep it going.
+func walkUploads(ctx context.Context, p provider.MetadataProvider, channelID string, fn func(*provider.Video) error) error {
+	// Uploads made while paging shift the pages, so a video may be listed
+	// twice.
+	seen := make(map[string]bool)
+	tokens := map[string]bool{"": true}
+
+	pageToken := ""
+
+	for {
+		ids, next, err := p.ChannelUploads(ctx, channelID, pageToken)
+		if err != nil {
+			return err
+		}
+
+		fetched, err := p.Videos(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		for _, pv := range fetched {
+			if seen[pv.ID] {
+				continue
+			}
+			seen[pv.ID] = true
+
+			err = fn(pv)
+			if err != nil {
+				return err
+			}
+		}
+
+		if tokens[next] {
+			return nil
+		}
+		tokens[next] = true
+
+		pageToken = next
+	}
+}
+
+func (app *application) readSyncRunIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("run_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid run_id parameter")
+	}
+
+	return id, nil
+}
+
+// createSyncRunHandler starts a sync of a channel in the background and
+// responds with the running run.
+func (app *application) createSyncRunHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChannelID string `json:"channel_id"`
+		DryRun    bool   `json:"dry_run"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(validator.NotBlank(input.ChannelID), "channel_id", "must be provided")
+	v.Check(len(input.ChannelID) <= 100, "channel_id", "must not be more than 100 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	run, err := app.startSyncRun(r.Context(), input.ChannelID, data.SyncTriggerAPI, input.DryRun, 0)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSyncNotDue):
+			app.syncInProgressResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		app.runSync(run)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/sync-runs/%d", run.ID))
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"sync_run": run}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSyncRunsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChannelID string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ChannelID = app.readString(qs, "channel_id", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Runs are always listed newest first.
+	input.Filters.Sort = "-id"
+	input.Filters.SortSafelist = []string{"-id"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	runs, metadata, err := app.models.SyncRuns.GetAll(r.Context(), input.ChannelID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"metadata": metadata, "sync_runs": runs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSyncRunHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readSyncRunIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	run, err := app.models.SyncRuns.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"sync_run": run}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/provider"
+)
+
+func newTestFakeProvider(channelID string, ids ...string) *provider.Fake {
+	fake := &provider.Fake{
+		Catalog:  make(map[string]*provider.Video),
+		Channels: map[string][]string{channelID: ids},
+	}
+
+	for _, id := range ids {
+		fake.Catalog[id] = &provider.Video{
+			ID:          id,
+			Title:       "Video " + id,
+			Description: "About " + id,
+			Duration:    10 * time.Minute,
+			Language:    "ar",
+			PublishedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+
+	return fake
+}
+
+// loopingProvider serves fixed pages of uploads, linked by next page tokens
+// that may lead back to an earlier page instead of ending.
+type loopingProvider struct {
+	*provider.Fake
+	pages map[string][]string
+	next  map[string]string
+	calls int
+}
+
+func (p *loopingProvider) ChannelUploads(ctx context.Context, channelID, pageToken string) ([]string, string, error) {
+	p.calls++
+	return p.pages[pageToken], p.next[pageToken], nil
+}
+
+func walkedIDs(t *testing.T, p provider.MetadataProvider, channelID string) []string {
+	t.Helper()
+
+	var ids []string
+
+	err := walkUploads(context.Background(), p, channelID, func(pv *provider.Video) error {
+		ids = append(ids, pv.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ids
+}
+
+func TestWalkUploadsPages(t *testing.T) {
+	ids := []string{"aaaaaaaaaaa", "bbbbbbbbbbb", "ccccccccccc", "ddddddddddd", "eeeeeeeeeee"}
+
+	fake := newTestFakeProvider("channel", ids...)
+	fake.PageSize = 2
+
+	// A private video is listed but not served.
+	delete(fake.Catalog, "ccccccccccc")
+
+	got := walkedIDs(t, fake, "channel")
+
+	want := []string{"aaaaaaaaaaa", "bbbbbbbbbbb", "ddddddddddd", "eeeeeeeeeee"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if requests := fake.Requests(); len(requests) != 3 {
+		t.Errorf("fetched %d pages; want 3", len(requests))
+	}
+}
+
+func TestWalkUploadsSkipsRepeatedVideos(t *testing.T) {
+	// An upload while paging pushes bbbbbbbbbbb onto the second page too.
+	fake := newTestFakeProvider("channel", "aaaaaaaaaaa", "bbbbbbbbbbb", "bbbbbbbbbbb", "ccccccccccc")
+	fake.PageSize = 2
+
+	got := walkedIDs(t, fake, "channel")
+
+	want := []string{"aaaaaaaaaaa", "bbbbbbbbbbb", "ccccccccccc"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestWalkUploadsStopsAtRepeatedPageToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		next  map[string]string
+		calls int
+	}{
+		{"same token", map[string]string{"": "p2", "p2": "p2"}, 2},
+		{"earlier token", map[string]string{"": "p2", "p2": "p3", "p3": "p2"}, 3},
+		{"last page", map[string]string{"": "p2", "p2": "p3", "p3": ""}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &loopingProvider{
+				Fake: newTestFakeProvider("channel", "aaaaaaaaaaa", "bbbbbbbbbbb", "ccccccccccc"),
+				pages: map[string][]string{
+					"":   {"aaaaaaaaaaa"},
+					"p2": {"bbbbbbbbbbb"},
+					"p3": {"ccccccccccc"},
+				},
+				next: tt.next,
+			}
+
+			walkedIDs(t, p, "channel")
+
+			if p.calls != tt.calls {
+				t.Errorf("requested %d pages; want %d", p.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestWalkUploadsStopsOnError(t *testing.T) {
+	fake := newTestFakeProvider("channel", "aaaaaaaaaaa", "bbbbbbbbbbb")
+	errStop := errors.New("stop")
+
+	var calls int
+
+	err := walkUploads(context.Background(), fake, "channel", func(pv *provider.Video) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("got error %v after %d calls; want errStop after 1", err, calls)
+	}
+}
+
+func newTestSyncApplication(t *testing.T, fake *provider.Fake) *application {
+	t.Helper()
+
+	app := newTestDBApplication(t)
+	app.metadataProvider = fake
+	app.config.metadata.provider = "fake"
+	app.config.sync.timeout = time.Hour
+	app.config.sync.videoType = "podcast"
+	app.config.sync.language = "ar"
+
+	return app
+}
+
+func TestSyncUploadsDryRunWritesNoVideo(t *testing.T) {
+	fake := newTestFakeProvider("channel", "aaaaaaaaaaa", "bbbbbbbbbbb", "ccccccccccc")
+	app := newTestSyncApplication(t, fake)
+	ctx := context.Background()
+
+	existing := &data.Video{
+		VideoID:     "aaaaaaaaaaa",
+		Title:       "Old title",
+		Description: "About aaaaaaaaaaa",
+		Type:        "podcast",
+		Length:      600,
+		Language:    "ar",
+		PublishedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	err := app.models.Videos.Insert(ctx, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := &data.SyncRun{ChannelID: "channel", DryRun: true}
+
+	err = app.syncUploads(ctx, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Created != 2 || run.Updated != 1 || run.Unchanged != 0 || run.Invalid != 0 {
+		t.Errorf("got created %d, updated %d, unchanged %d, invalid %d; want 2, 1, 0, 0", run.Created, run.Updated, run.Unchanged, run.Invalid)
+	}
+
+	if len(run.Changes) != 3 || !slices.Equal(run.Changes[0].Fields, []string{"title"}) {
+		t.Errorf("got changes %+v", run.Changes)
+	}
+
+	for _, id := range []string{"bbbbbbbbbbb", "ccccccccccc"} {
+		_, err := app.models.Videos.Get(ctx, id)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("video %s: got error %v; want it not created", id, err)
+		}
+	}
+
+	video, err := app.models.Videos.Get(ctx, "aaaaaaaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Old title" || video.Version != existing.Version {
+		t.Errorf("got title %q at version %d; want the video left alone", video.Title, video.Version)
+	}
+
+	// The same sync for real writes what the dry run reported.
+	run = &data.SyncRun{ChannelID: "channel"}
+
+	err = app.syncUploads(ctx, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Created != 2 || run.Updated != 1 {
+		t.Errorf("got created %d, updated %d; want 2, 1", run.Created, run.Updated)
+	}
+
+	video, err = app.models.Videos.Get(ctx, "aaaaaaaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Video aaaaaaaaaaa" {
+		t.Errorf("got title %q; want it synced", video.Title)
+	}
+}
+
+func TestStartSyncRunNotDue(t *testing.T) {
+	app := newTestSyncApplication(t, newTestFakeProvider("channel"))
+	ctx := context.Background()
+
+	run, err := app.startSyncRun(ctx, "channel", data.SyncTriggerAPI, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither trigger may start a run while one is in progress.
+	for _, trigger := range []string{data.SyncTriggerAPI, data.SyncTriggerSchedule} {
+		_, err = app.startSyncRun(ctx, "channel", trigger, false, 0)
+		if !errors.Is(err, data.ErrSyncNotDue) {
+			t.Errorf("%s run: got error %v; want ErrSyncNotDue", trigger, err)
+		}
+	}
+
+	// Other channels are synced independently.
+	_, err = app.startSyncRun(ctx, "other", data.SyncTriggerAPI, false, 0)
+	if err != nil {
+		t.Errorf("run of another channel: %v", err)
+	}
+
+	run.Status = data.SyncRunStatusSucceeded
+
+	err = app.models.SyncRuns.Finish(ctx, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A scheduled run isn't held back by a finished manual one...
+	scheduled, err := app.startSyncRun(ctx, "channel", data.SyncTriggerSchedule, false, time.Hour)
+	if err != nil {
+		t.Fatalf("scheduled run after a manual one: %v", err)
+	}
+
+	scheduled.Status = data.SyncRunStatusSucceeded
+
+	err = app.models.SyncRuns.Finish(ctx, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...but is by a scheduled one within the interval.
+	_, err = app.startSyncRun(ctx, "channel", data.SyncTriggerSchedule, false, time.Hour)
+	if !errors.Is(err, data.ErrSyncNotDue) {
+		t.Errorf("second scheduled run: got error %v; want ErrSyncNotDue", err)
+	}
+}
+
+func TestStartSyncRunExpiresStaleRun(t *testing.T) {
+	app := newTestSyncApplication(t, newTestFakeProvider("channel"))
+	ctx := context.Background()
+
+	stale, err := app.startSyncRun(ctx, "channel", data.SyncTriggerAPI, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A negative timeout takes every running run to have died, even with
+	// started_at rounded up to the next second.
+	app.config.sync.timeout = -time.Second
+
+	_, err = app.startSyncRun(ctx, "channel", data.SyncTriggerAPI, false, 0)
+	if err != nil {
+		t.Fatalf("run after a stale one: %v", err)
+	}
+
+	stale, err = app.models.SyncRuns.Get(ctx, stale.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Status != data.SyncRunStatusFailed || stale.FinishedAt == nil {
+		t.Errorf("got stale run %s, finished at %v; want it failed", stale.Status, stale.FinishedAt)
+	}
+
+	// Finishing the run the instance thought it still had conflicts.
+	stale.Status = data.SyncRunStatusSucceeded
+
+	err = app.models.SyncRuns.Finish(ctx, stale)
+	if !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("finishing the stale run: got error %v; want ErrEditConflict", err)
+	}
+}
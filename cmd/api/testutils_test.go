@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JLL32/thmanyah/internal/data"
+)
+
+// newTestApplication returns an application that logs nowhere and whose
+// background context is cancelled when the test ends. Tests set the
+// dependencies they need on it.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics: newAppMetrics(nil),
+	}
+
+	app.backgroundCtx, app.stopBackground = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		app.stopBackground()
+		app.wg.Wait()
+	})
+
+	return app
+}
+
+// newTestDBApplication is like newTestApplication, with the models backed by
+// a database from newTestDB.
+func newTestDBApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+	app.db = newTestDB(t)
+	app.models = data.NewModels(app.db, nil)
+
+	// Let background work finish before the database is closed.
+	t.Cleanup(func() {
+		app.stopBackground()
+		app.wg.Wait()
+	})
+
+	return app
+}
+
+// newTestDB connects to the PostgreSQL database in THMANYAH_TEST_DB_DSN and
+// migrates a schema of its own, which is dropped when the test ends. The test
+// is skipped if the variable isn't set.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("THMANYAH_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("THMANYAH_TEST_DB_DSN not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "test_" + strings.ToLower(rand.Text())
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registered after the schema cleanup, so it runs before it.
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The names are zero-padded, so Glob's lexical order is migration order.
+	for _, path := range migrations {
+		query, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(query))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+	}
+
+	return db
+}
+
+// withSearchPath adds a search_path run-time parameter to a URL or key/value
+// DSN.
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return fmt.Sprintf("%s search_path=%s", dsn, schema)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
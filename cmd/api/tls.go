@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// certReloader serves the certificate loaded from disk and swaps it for a
+// freshly loaded one on reload, so certificates can be rotated without
+// restarting the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// reload leaves the current certificate in place if the new one can't be
+// loaded.
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.cert = &cert
+	return nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
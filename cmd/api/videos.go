@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/JLL32/thmanyah/internal/data"
+	"github.com/JLL32/thmanyah/internal/jsonpatch"
 	"github.com/JLL32/thmanyah/internal/validator"
 )
 
@@ -39,21 +41,33 @@ func (app *application) createVideoHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	v := validator.New()
+	if video.VideoID != "" {
+		data.ValidateVideoID(v, video.VideoID)
+	}
 	if data.ValidateVideo(v, video); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Videos.Insert(video)
+	err = app.models.Videos.Insert(r.Context(), video)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		var fieldErr *data.FieldError
+
+		switch {
+		case errors.Is(err, data.ErrDuplicateVideoID):
+			app.duplicateVideoIDResponse(w, r)
+		case errors.As(err, &fieldErr):
+			app.constraintViolationResponse(w, r, fieldErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/videos/%s", video.VideoID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"video": video}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"video": video}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -67,7 +81,7 @@ func (app *application) showVideoHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	video, err := app.models.Videos.Get(id)
+	video, err := app.models.Videos.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -78,12 +92,56 @@ func (app *application) showVideoHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"video": video}, nil)
+	assets, err := app.models.MediaAssets.GetAllForVideo(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.setThumbnails(r.Context(), video)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"video": video, "assets": assets}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// videoUpdateInput holds the fields of a partial video update. Only non-nil
+// fields are applied.
+type videoUpdateInput struct {
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	Type        *string    `json:"type"`
+	Length      *int       `json:"length"`
+	Language    *string    `json:"language"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+func (input videoUpdateInput) apply(video *data.Video) {
+	if input.Title != nil {
+		video.Title = *input.Title
+	}
+	if input.Description != nil {
+		video.Description = *input.Description
+	}
+	if input.Type != nil {
+		video.Type = *input.Type
+	}
+	if input.Length != nil {
+		video.Length = *input.Length
+	}
+	if input.Language != nil {
+		video.Language = *input.Language
+	}
+	if input.PublishedAt != nil {
+		video.PublishedAt = *input.PublishedAt
+	}
+}
+
 func (app *application) updateVideoHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -91,7 +149,7 @@ func (app *application) updateVideoHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	video, err := app.models.Videos.Get(id)
+	video, err := app.models.Videos.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -109,58 +167,65 @@ func (app *application) updateVideoHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	var input struct {
-		Title *string `json:"title"`
-		Description *string `json:"description"`
-		Type *string `json:"type"`
-		Length *int `json:"length"`
-		Language *string `json:"language"`
-		PublishedAt *time.Time `json:"published_at"`
-	}
+	switch mediaType := requestMediaType(r); mediaType {
+	case "", "application/json":
+		var input videoUpdateInput
 
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	if input.Title != nil {
-		video.Title = *input.Title
-	}
-	if input.Description != nil {
-		video.Description = *input.Description
-	}
-	if input.Type != nil {
-		video.Type = *input.Type
-	}
-	if input.Length != nil {
-		video.Length = *input.Length
-	}
-	if input.Language != nil {
-		video.Language = *input.Language
-	}
-	if input.PublishedAt != nil {
-		video.PublishedAt = *input.PublishedAt
+		input.apply(video)
+
+	case mergePatchMediaType, jsonPatchMediaType:
+		err = app.readVideoPatch(w, r, mediaType, video)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			case errors.Is(err, errPatchFailed):
+				app.unprocessablePatchResponse(w, r, err)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/json, "+mergePatchMediaType+", "+jsonPatchMediaType)
+		return
 	}
 
 	v := validator.New()
 	if data.ValidateVideo(v, video); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Videos.Update(video)
+	err = app.models.Videos.Update(r.Context(), video)
 	if err != nil {
+		var fieldErr *data.FieldError
+
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.As(err, &fieldErr):
+			app.constraintViolationResponse(w, r, fieldErr)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"video": video}, nil)
+	err = app.setThumbnails(r.Context(), video)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"video": video}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -174,7 +239,15 @@ func (app *application) deleteVideoHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Videos.Delete(id)
+	// The asset and image rows go with the video (ON DELETE CASCADE), so
+	// read their blob keys first to clean them up afterwards.
+	blobs, err := videoBlobKeys(r.Context(), app.models, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Videos.Delete(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -185,17 +258,52 @@ func (app *application) deleteVideoHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "video successfully deleted"}, nil)
+	app.deleteBlobs(blobs...)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "video successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+// videoBlobKeys returns the keys of every blob stored for the video's assets
+// and images.
+func videoBlobKeys(ctx context.Context, models data.Models, id string) ([]string, error) {
+	assets, err := models.MediaAssets.GetAllForVideo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := models.VideoImages.GetAllForVideos(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	renditions, err := models.Renditions.GetAllForVideo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, asset := range assets {
+		keys = append(keys, asset.BlobKeys()...)
+	}
+
+	for _, img := range images {
+		keys = append(keys, img.StorageKey)
+	}
+
+	return append(keys, renditionKeys(renditions)...), nil
+}
+
+var videoSortSafelist = []string{"video_id", "title", "description", "length", "type", "-video_id", "-title", "-description", "-length", "-type"}
+
 func (app *application) listVideosHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
+		Title       string
 		Description string
+		Transcript  string
 		data.Filters
 	}
 
@@ -204,24 +312,31 @@ func (app *application) listVideosHandler(w http.ResponseWriter, r *http.Request
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
-	input.Description =  app.readString(qs, "description", "")
+	input.Description = app.readString(qs, "description", "")
+	input.Transcript = app.readString(qs, "transcript", "")
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"video_id", "title", "description", "length", "type", "-video_id", "-title", "-description", "-length", "-type"}
+	input.Filters.Sort = app.readString(qs, "sort", "video_id")
+	input.Filters.SortSafelist = videoSortSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	videos, metadata, err := app.models.Videos.GetAll(r.Context(), input.Title, input.Description, input.Transcript, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	videos, metadata, err := app.models.Videos.GetAll(input.Title, input.Description, input.Filters)
+	err = app.setThumbnails(r.Context(), videos...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "videos": videos}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"metadata": metadata, "videos": videos}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
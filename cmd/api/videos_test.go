@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/data"
+)
+
+func insertTestVideo(t *testing.T, app *application, length int) *data.Video {
+	t.Helper()
+
+	video := &data.Video{
+		Title:       "Episode 1",
+		Description: "The first episode",
+		Type:        "podcast",
+		Length:      length,
+		Language:    "ar",
+		PublishedAt: time.Now().Add(-time.Hour).Round(time.Second),
+	}
+
+	err := app.models.Videos.Insert(context.Background(), video)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return video
+}
+
+func TestUpdateVideoRejectsLengthCuttingOffChapters(t *testing.T) {
+	app := newTestDBApplication(t)
+	ctx := context.Background()
+
+	video := insertTestVideo(t, app, 600)
+
+	end := 400
+	for _, chapter := range []*data.Chapter{
+		{VideoID: video.VideoID, StartTime: 0, Title: "Intro"},
+		{VideoID: video.VideoID, StartTime: 300, EndTime: &end, Title: "Interview"},
+	} {
+		err := app.models.Chapters.Insert(ctx, chapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	version := video.Version
+
+	video.Length = 399
+
+	var fieldErr *data.FieldError
+
+	err := app.models.Videos.Update(ctx, video)
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "length" {
+		t.Fatalf("got error %v; want a FieldError for length", err)
+	}
+
+	stored, err := app.models.Videos.Get(ctx, video.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Length != 600 || stored.Version != version {
+		t.Fatalf("got length %d at version %d; want the rejected update rolled back (600 at %d)", stored.Length, stored.Version, version)
+	}
+
+	video.Length = 400
+
+	err = app.models.Videos.Update(ctx, video)
+	if err != nil {
+		t.Fatalf("updating to the end of the last chapter: %v", err)
+	}
+
+	// A probed duration that would cut off a chapter leaves the length as
+	// the editor set it.
+	asset := insertTestAsset(t, app, video.VideoID)
+
+	err = app.setVideoLength(ctx, asset, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = app.models.Videos.Get(ctx, video.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Length != 400 {
+		t.Fatalf("got length %d after probing; want 400", stored.Length)
+	}
+
+	err = app.setVideoLength(ctx, asset, 450)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = app.models.Videos.Get(ctx, video.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Length != 450 {
+		t.Fatalf("got length %d after probing; want 450", stored.Length)
+	}
+}
@@ -0,0 +1,196 @@
+// Package captions parses and writes subtitle tracks in the WebVTT and SubRip
+// (SRT) formats.
+package captions
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatVTT = "vtt"
+	FormatSRT = "srt"
+)
+
+// Cue is one timed caption.
+type Cue struct {
+	// ID is the optional cue identifier. SRT cue numbers aren't kept, as
+	// they are regenerated on output.
+	ID    string
+	Start time.Duration
+	End   time.Duration
+	// Settings are the WebVTT cue settings after the timing, such as
+	// "align:start line:0". SRT has no equivalent, so they are dropped
+	// when writing SRT.
+	Settings string
+	// Text is the cue payload as WebVTT cue text: it may span several
+	// lines, contain markup such as <i> or <v Speaker>, and escapes &, <
+	// and > as entities. SRT text is converted on the way in and out.
+	Text string
+}
+
+// ParseError reports a malformed caption file.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ErrNoCues is returned for a well-formed file without any cues.
+var ErrNoCues = errors.New("the file contains no cues")
+
+// Parse parses a caption file in the given format.
+func Parse(format, src string) ([]Cue, error) {
+	switch format {
+	case FormatVTT:
+		return ParseVTT(src)
+	case FormatSRT:
+		return ParseSRT(src)
+	default:
+		return nil, fmt.Errorf("captions: unknown format %q", format)
+	}
+}
+
+// Write renders cues in the given format.
+func Write(format string, cues []Cue) (string, error) {
+	switch format {
+	case FormatVTT:
+		return WriteVTT(cues), nil
+	case FormatSRT:
+		return WriteSRT(cues), nil
+	default:
+		return "", fmt.Errorf("captions: unknown format %q", format)
+	}
+}
+
+// block is a run of non-blank lines, and the line number of its first line.
+type block struct {
+	line  int
+	lines []string
+}
+
+func splitBlocks(src string, firstLine int) []block {
+	var (
+		blocks  []block
+		current *block
+	)
+
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, " \t")
+
+		if line == "" {
+			current = nil
+			continue
+		}
+
+		if current == nil {
+			blocks = append(blocks, block{line: firstLine + i})
+			current = &blocks[len(blocks)-1]
+		}
+
+		current.lines = append(current.lines, line)
+	}
+
+	return blocks
+}
+
+// normalize strips a byte order mark and converts line endings to "\n".
+func normalize(src string) string {
+	src = strings.TrimPrefix(src, "\ufeff")
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	return strings.ReplaceAll(src, "\r", "\n")
+}
+
+// timestampRX matches [hh:]mm:ss.ttt, with a comma as the decimal separator
+// as well, as used by SRT.
+var timestampRX = regexp.MustCompile(`^(?:(\d+):)?([0-5]\d):([0-5]\d)[.,](\d{3})$`)
+
+func parseTimestamp(s string) (time.Duration, bool) {
+	m := timestampRX.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+
+	// The pattern only lets digits through, but the hours are unbounded.
+	h, err := strconv.Atoi(cmp.Or(m[1], "0"))
+	if err != nil || h > 999 {
+		return 0, false
+	}
+	min, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.Atoi(m[3])
+	ms, _ := strconv.Atoi(m[4])
+
+	return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second + time.Duration(ms)*time.Millisecond, true
+}
+
+// parseTiming parses a "start --> end [settings]" line.
+func parseTiming(line string) (start, end time.Duration, settings string, ok bool) {
+	startStr, rest, found := strings.Cut(line, "-->")
+	if !found {
+		return 0, 0, "", false
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, 0, "", false
+	}
+
+	start, ok1 := parseTimestamp(strings.TrimSpace(startStr))
+	end, ok2 := parseTimestamp(fields[0])
+	if !ok1 || !ok2 {
+		return 0, 0, "", false
+	}
+
+	return start, end, strings.Join(fields[1:], " "), true
+}
+
+func validateCue(cue Cue, prev *Cue, line int) error {
+	switch {
+	case cue.End <= cue.Start:
+		return &ParseError{Line: line, Msg: "cue must end after it starts"}
+	case prev != nil && cue.Start < prev.Start:
+		return &ParseError{Line: line, Msg: "cues must be in order of start time"}
+	case strings.TrimSpace(cue.Text) == "":
+		return &ParseError{Line: line, Msg: "cue text must not be empty"}
+	}
+
+	return nil
+}
+
+func formatTimestamp(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, sep, ms%1000)
+}
+
+var tagRX = regexp.MustCompile(`<[^>]*>`)
+
+// unescape replaces the character references WebVTT defines.
+var unescape = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", "\u00a0", "&lrm;", "\u200e", "&rlm;", "\u200f")
+
+// PlainText returns the text of cues without markup, one cue per line, for
+// use as a transcript.
+func PlainText(cues []Cue) string {
+	lines := make([]string, len(cues))
+	for i, cue := range cues {
+		lines[i] = CueText(cue)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// CueText returns the text of a cue without markup, on one line.
+func CueText(cue Cue) string {
+	text := tagRX.ReplaceAllString(cue.Text, "")
+
+	text = unescape.Replace(text)
+
+	return strings.Join(strings.Fields(text), " ")
+}
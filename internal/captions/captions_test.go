@@ -0,0 +1,253 @@
+package captions
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSRTToVTTRoundTrip(t *testing.T) {
+	srt := "1\n" +
+		"00:00:01,000 --> 00:00:04,000\n" +
+		"<i>Hello</i> & <font color=\"red\">welcome</font>\n" +
+		"a < b > c\n" +
+		"\n" +
+		"2\n" +
+		"00:00:05,500 --> 01:00:07,250\n" +
+		"{\\an8}<B>Top</B> of the <u>screen</u>\n"
+
+	cues, err := ParseSRT(srt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Cue{
+		{Start: time.Second, End: 4 * time.Second, Text: "<i>Hello</i> &amp; welcome\na &lt; b &gt; c"},
+		{Start: 5500 * time.Millisecond, End: time.Hour + 7250*time.Millisecond, Text: "<b>Top</b> of the <u>screen</u>"},
+	}
+
+	if !reflect.DeepEqual(cues, want) {
+		t.Fatalf("got cues %q; want %q", cues, want)
+	}
+
+	vtt := WriteVTT(cues)
+
+	wantVTT := "WEBVTT\n" +
+		"\n" +
+		"00:00:01.000 --> 00:00:04.000\n" +
+		"<i>Hello</i> &amp; welcome\n" +
+		"a &lt; b &gt; c\n" +
+		"\n" +
+		"00:00:05.500 --> 01:00:07.250\n" +
+		"<b>Top</b> of the <u>screen</u>\n"
+
+	if vtt != wantVTT {
+		t.Fatalf("got VTT\n%s\nwant\n%s", vtt, wantVTT)
+	}
+
+	cues, err = ParseVTT(vtt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cues, want) {
+		t.Fatalf("got cues %q after parsing the VTT; want %q", cues, want)
+	}
+
+	// SRT has no escaping, and <font> isn't written back.
+	wantSRT := "1\n" +
+		"00:00:01,000 --> 00:00:04,000\n" +
+		"<i>Hello</i> & welcome\n" +
+		"a < b > c\n" +
+		"\n" +
+		"2\n" +
+		"00:00:05,500 --> 01:00:07,250\n" +
+		"<b>Top</b> of the <u>screen</u>\n"
+
+	if got := WriteSRT(cues); got != wantSRT {
+		t.Errorf("got SRT\n%s\nwant\n%s", got, wantSRT)
+	}
+}
+
+func TestVTTToSRT(t *testing.T) {
+	vtt := "WEBVTT\n" +
+		"\n" +
+		"intro\n" +
+		"00:01.000 --> 00:03.000 align:start line:0\n" +
+		"<v Roger>Hi &amp; bye</v> <i.loud>now</i>\n" +
+		"<00:00:02.000><c.yellow>later</c> &lt;3\n"
+
+	cues, err := ParseVTT(vtt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Cue{
+		ID:       "intro",
+		Start:    time.Second,
+		End:      3 * time.Second,
+		Settings: "align:start line:0",
+		Text:     "<v Roger>Hi &amp; bye</v> <i.loud>now</i>\n<00:00:02.000><c.yellow>later</c> &lt;3",
+	}
+
+	if len(cues) != 1 || !reflect.DeepEqual(cues[0], want) {
+		t.Fatalf("got cues %q; want %q", cues, want)
+	}
+
+	// Voice spans, classes and timestamps have no SRT equivalent, and
+	// neither do cue IDs and settings.
+	wantSRT := "1\n" +
+		"00:00:01,000 --> 00:00:03,000\n" +
+		"Hi & bye <i>now</i>\n" +
+		"later <3\n"
+
+	if got := WriteSRT(cues); got != wantSRT {
+		t.Errorf("got SRT\n%s\nwant\n%s", got, wantSRT)
+	}
+
+	if got := WriteVTT(cues); got != "WEBVTT\n\nintro\n00:00:01.000 --> 00:00:03.000 align:start line:0\n"+want.Text+"\n" {
+		t.Errorf("got VTT\n%s", got)
+	}
+
+	if got := CueText(cues[0]); got != "Hi & bye now later <3" {
+		t.Errorf("got cue text %q", got)
+	}
+}
+
+func TestParseLineEndingsAndBOM(t *testing.T) {
+	want := []Cue{
+		{Start: time.Second, End: 2 * time.Second, Text: "First"},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: "Second\nline"},
+	}
+
+	tests := []struct {
+		format string
+		src    string
+	}{
+		{FormatVTT, "\ufeffWEBVTT\r\n\r\n00:01.000 --> 00:02.000\r\nFirst\r\n\r\n00:03.000 --> 00:04.000\r\nSecond\r\nline\r\n"},
+		{FormatVTT, "WEBVTT\r\r00:01.000 --> 00:02.000\rFirst\r\r00:03.000 --> 00:04.000\rSecond\rline\r"},
+		{FormatSRT, "\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nFirst\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nSecond\r\nline\r\n"},
+		{FormatSRT, "\ufeff1\n00:00:01,000 --> 00:00:02,000  \nFirst\n\n\n\n2\n00:00:03,000 --> 00:00:04,000\nSecond\nline"},
+	}
+
+	for _, tt := range tests {
+		cues, err := Parse(tt.format, tt.src)
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.format, tt.src, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(cues, want) {
+			t.Errorf("%s %q: got cues %q; want %q", tt.format, tt.src, cues, want)
+		}
+	}
+}
+
+func TestParseVTTSkipsNonCueBlocks(t *testing.T) {
+	vtt := "WEBVTT - with a title\n" +
+		"Kind: captions\n" +
+		"Language: ar\n" +
+		"\n" +
+		"STYLE\n" +
+		"::cue { color: yellow }\n" +
+		"\n" +
+		"REGION\n" +
+		"id:top width:40% lines:3\n" +
+		"\n" +
+		"NOTE This file was made by hand\n" +
+		"\n" +
+		"NOTE\n" +
+		"it has a second note\n" +
+		"over two lines\n" +
+		"\n" +
+		"00:01.000 --> 00:02.000 region:top\n" +
+		"Hello\n" +
+		"\n" +
+		"NOTE one between cues\n" +
+		"\n" +
+		"00:03.000 --> 00:04.000\n" +
+		"Goodbye\n"
+
+	cues, err := ParseVTT(vtt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Cue{
+		{Start: time.Second, End: 2 * time.Second, Settings: "region:top", Text: "Hello"},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: "Goodbye"},
+	}
+
+	if !reflect.DeepEqual(cues, want) {
+		t.Errorf("got cues %q; want %q", cues, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		src    string
+		line   int
+	}{
+		{"VTT without header", FormatVTT, "00:01.000 --> 00:02.000\nHello\n", 1},
+		{"VTT header with suffix", FormatVTT, "WEBVTTX\n\n00:01.000 --> 00:02.000\nHello\n", 1},
+		{"VTT out of order", FormatVTT, "WEBVTT\n\n00:05.000 --> 00:06.000\nSecond\n\n00:01.000 --> 00:02.000\nFirst\n", 6},
+		{"VTT out of order after an ID", FormatVTT, "WEBVTT\n\n00:05.000 --> 00:06.000\nSecond\n\nfirst\n00:01.000 --> 00:02.000\nFirst\n", 7},
+		{"VTT ends at its start", FormatVTT, "WEBVTT\n\n00:01.000 --> 00:01.000\nHello\n", 3},
+		{"VTT ends before its start", FormatVTT, "WEBVTT\n\nNOTE x\n\n00:02.000 --> 00:01.000\nHello\n", 5},
+		{"VTT invalid timing", FormatVTT, "WEBVTT\n\n00:01 --> 00:02.000\nHello\n", 3},
+		{"VTT ID without timing", FormatVTT, "WEBVTT\n\n00:01.000 --> 00:02.000\nHello\n\norphan\n", 6},
+		{"VTT empty text", FormatVTT, "WEBVTT\n\n00:01.000 --> 00:02.000\n", 3},
+		{"VTT error after CRLF and BOM", FormatVTT, "\ufeffWEBVTT\r\n\r\n00:02.000 --> 00:01.000\r\nHello\r\n", 3},
+		{"SRT out of order", FormatSRT, "1\n00:00:05,000 --> 00:00:06,000\nSecond\n\n2\n00:00:01,000 --> 00:00:02,000\nFirst\n", 6},
+		{"SRT ends at its start", FormatSRT, "1\n00:00:01,000 --> 00:00:01,000\nHello\n", 2},
+		{"SRT ends before its start", FormatSRT, "1\n00:00:01,000 --> 00:00:02,000\nA\n\n00:00:03,000 --> 00:00:02,500\nB\n", 5},
+		{"SRT invalid timing", FormatSRT, "1\n00:00:01 --> 00:00:02,000\nHello\n", 2},
+		{"SRT minutes out of range", FormatSRT, "1\n00:60:01,000 --> 00:61:02,000\nHello\n", 2},
+		{"SRT text without timing", FormatSRT, "Hello\nthere\n", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.format, tt.src)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("got error %v; want a ParseError", err)
+			}
+
+			if parseErr.Line != tt.line {
+				t.Errorf("got error %q on line %d; want line %d", parseErr.Msg, parseErr.Line, tt.line)
+			}
+		})
+	}
+}
+
+func TestParseNoCues(t *testing.T) {
+	tests := []struct {
+		format string
+		src    string
+	}{
+		{FormatVTT, "WEBVTT\n"},
+		{FormatVTT, "WEBVTT"},
+		{FormatVTT, "WEBVTT\nKind: captions\n\nNOTE nothing here yet\n\nSTYLE\n::cue { color: red }\n"},
+		{FormatSRT, ""},
+		{FormatSRT, "\ufeff\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.format, tt.src)
+		if !errors.Is(err, ErrNoCues) {
+			t.Errorf("%s %q: got error %v; want ErrNoCues", tt.format, tt.src, err)
+		}
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	_, err := Parse("ass", "[Script Info]\n")
+	if err == nil {
+		t.Error("got no error for an unknown format")
+	}
+}
@@ -0,0 +1,116 @@
+package captions
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// styleTagRX matches the <b>, <i> and <u> tags that SRT and WebVTT share. In
+// WebVTT they may carry classes, as in <i.loud>.
+var styleTagRX = regexp.MustCompile(`(?i)<(/?)([biu])(?:\.[^>]*)?>`)
+
+// srtOverrideRX matches the {\an8}-style positioning overrides some SRT
+// authoring tools emit.
+var srtOverrideRX = regexp.MustCompile(`\{\\[^}]*\}`)
+
+var escape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var fontTagRX = regexp.MustCompile(`(?i)</?font[^>]*>`)
+
+// srtToVTT converts SRT cue text to WebVTT cue text. SRT has no escaping, so
+// everything but the style tags is escaped, and <font> tags are dropped.
+func srtToVTT(text string) string {
+	return mapUnstyled(srtOverrideRX.ReplaceAllString(text, ""), func(s string) string {
+		return escape.Replace(fontTagRX.ReplaceAllString(s, ""))
+	})
+}
+
+// vttToSRT converts WebVTT cue text to SRT cue text, keeping the style tags
+// and dropping the rest, such as voice spans and timestamps.
+func vttToSRT(text string) string {
+	return mapUnstyled(text, func(s string) string {
+		return unescape.Replace(tagRX.ReplaceAllString(s, ""))
+	})
+}
+
+// mapUnstyled applies fn to the text between the style tags, and writes the
+// tags themselves without classes.
+func mapUnstyled(text string, fn func(string) string) string {
+	var b strings.Builder
+
+	for {
+		m := styleTagRX.FindStringSubmatchIndex(text)
+		if m == nil {
+			break
+		}
+
+		b.WriteString(fn(text[:m[0]]))
+		b.WriteString("<" + text[m[2]:m[3]] + strings.ToLower(text[m[4]:m[5]]) + ">")
+		text = text[m[1]:]
+	}
+
+	b.WriteString(fn(text))
+
+	return b.String()
+}
+
+// ParseSRT parses a SubRip file. Cue numbers are read but not kept, so files
+// with missing or out of sequence numbers are accepted.
+func ParseSRT(src string) ([]Cue, error) {
+	var cues []Cue
+
+	for _, b := range splitBlocks(normalize(src), 1) {
+		lines, line := b.lines, b.line
+
+		if !strings.Contains(lines[0], "-->") {
+			if _, err := strconv.Atoi(strings.TrimSpace(lines[0])); err != nil || len(lines) < 2 {
+				return nil, &ParseError{Line: line, Msg: "expected a cue number followed by a timing line"}
+			}
+
+			lines, line = lines[1:], line+1
+		}
+
+		start, end, _, ok := parseTiming(lines[0])
+		if !ok {
+			return nil, &ParseError{Line: line, Msg: "invalid cue timing, expected hh:mm:ss,ttt --> hh:mm:ss,ttt"}
+		}
+
+		cue := Cue{Start: start, End: end, Text: srtToVTT(strings.Join(lines[1:], "\n"))}
+
+		var prev *Cue
+		if len(cues) > 0 {
+			prev = &cues[len(cues)-1]
+		}
+
+		err := validateCue(cue, prev, line)
+		if err != nil {
+			return nil, err
+		}
+
+		cues = append(cues, cue)
+	}
+
+	if len(cues) == 0 {
+		return nil, ErrNoCues
+	}
+
+	return cues, nil
+}
+
+// WriteSRT renders cues as a SubRip file, numbering them from 1.
+func WriteSRT(cues []Cue) string {
+	var b strings.Builder
+
+	for i, cue := range cues {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		b.WriteString(strconv.Itoa(i+1) + "\n")
+		b.WriteString(formatTimestamp(cue.Start, ",") + " --> " + formatTimestamp(cue.End, ",") + "\n")
+		b.WriteString(vttToSRT(cue.Text) + "\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package captions
+
+import (
+	"strings"
+)
+
+// ParseVTT parses a WebVTT file. NOTE, STYLE and REGION blocks are skipped.
+func ParseVTT(src string) ([]Cue, error) {
+	src = normalize(src)
+
+	header, body, _ := strings.Cut(src, "\n")
+	if header != "WEBVTT" && !strings.HasPrefix(header, "WEBVTT ") && !strings.HasPrefix(header, "WEBVTT\t") {
+		return nil, &ParseError{Line: 1, Msg: `the file must start with "WEBVTT"`}
+	}
+
+	blocks := splitBlocks(body, 2)
+
+	// The rest of the header block holds metadata headers, if any.
+	if len(blocks) > 0 && blocks[0].line == 2 {
+		blocks = blocks[1:]
+	}
+
+	var cues []Cue
+
+	for _, b := range blocks {
+		first := b.lines[0]
+
+		if first == "NOTE" || strings.HasPrefix(first, "NOTE ") || strings.HasPrefix(first, "NOTE\t") ||
+			first == "STYLE" || first == "REGION" {
+			continue
+		}
+
+		var cue Cue
+
+		timing, line := first, b.line
+		text := b.lines[1:]
+
+		if !strings.Contains(first, "-->") {
+			if len(b.lines) < 2 {
+				return nil, &ParseError{Line: b.line, Msg: "expected a cue timing line"}
+			}
+
+			cue.ID = first
+			timing, line = b.lines[1], b.line+1
+			text = b.lines[2:]
+		}
+
+		var ok bool
+
+		cue.Start, cue.End, cue.Settings, ok = parseTiming(timing)
+		if !ok {
+			return nil, &ParseError{Line: line, Msg: "invalid cue timing, expected [hh:]mm:ss.ttt --> [hh:]mm:ss.ttt"}
+		}
+
+		cue.Text = strings.Join(text, "\n")
+
+		var prev *Cue
+		if len(cues) > 0 {
+			prev = &cues[len(cues)-1]
+		}
+
+		err := validateCue(cue, prev, line)
+		if err != nil {
+			return nil, err
+		}
+
+		cues = append(cues, cue)
+	}
+
+	if len(cues) == 0 {
+		return nil, ErrNoCues
+	}
+
+	return cues, nil
+}
+
+// WriteVTT renders cues as a WebVTT file.
+func WriteVTT(cues []Cue) string {
+	var b strings.Builder
+
+	b.WriteString("WEBVTT\n")
+
+	for _, cue := range cues {
+		b.WriteString("\n")
+
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+
+		b.WriteString(formatTimestamp(cue.Start, ".") + " --> " + formatTimestamp(cue.End, "."))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+
+		b.WriteString("\n" + cue.Text + "\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,374 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	AssetStatusUploading = "uploading"
+	AssetStatusComplete  = "complete"
+)
+
+var MediaAssetKinds = []string{"video", "audio"}
+
+// MediaAsset is a media file belonging to a video. The file is uploaded in
+// chunks, each stored as a separate part blob until the last one arrives; the
+// parts are then assembled under StorageKey and the asset becomes complete.
+type MediaAsset struct {
+	ID            int64     `json:"id"`
+	VideoID       string    `json:"video_id"`
+	Kind          string    `json:"kind"`
+	Filename      string    `json:"filename"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	StorageKey    string    `json:"-"`
+	Status        string    `json:"status"`
+	UploadedBytes int64     `json:"uploaded_bytes"`
+	Parts         []int64   `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	Version       int       `json:"version"`
+
+	// Metadata is filled in by probing the file once it is complete.
+	Metadata *MediaMetadata `json:"metadata,omitempty"`
+}
+
+// MediaMetadata describes the contents of a media asset. Fields the file has
+// no stream for, such as the resolution of an audio file, are zero.
+type MediaMetadata struct {
+	DurationMS    int64     `json:"duration_ms"`
+	Width         int       `json:"width,omitempty"`
+	Height        int       `json:"height,omitempty"`
+	VideoCodec    string    `json:"video_codec,omitempty"`
+	AudioCodec    string    `json:"audio_codec,omitempty"`
+	AudioLanguage string    `json:"audio_language,omitempty"`
+	ProbedAt      time.Time `json:"probed_at"`
+}
+
+// PartKey returns the blob key of the chunk starting at offset.
+func (a *MediaAsset) PartKey(offset int64) string {
+	return fmt.Sprintf("%s.parts/%d", a.StorageKey, offset)
+}
+
+// PartKeys returns the blob keys of the uploaded chunks, in order. Parts holds
+// the end offset of each chunk, so chunk i starts where chunk i-1 ended.
+func (a *MediaAsset) PartKeys() []string {
+	keys := make([]string, len(a.Parts))
+
+	var offset int64
+	for i, end := range a.Parts {
+		keys[i] = a.PartKey(offset)
+		offset = end
+	}
+
+	return keys
+}
+
+// BlobKeys returns the keys of every blob the asset may have in storage.
+func (a *MediaAsset) BlobKeys() []string {
+	return append([]string{a.StorageKey}, a.PartKeys()...)
+}
+
+func ValidateMediaAsset(v *validator.Validator, asset *MediaAsset, maxSize int64) {
+	v.Check(asset.Kind != "", "kind", "must be provided")
+	v.Check(asset.Kind == "" || validator.PermittedValue(asset.Kind, MediaAssetKinds...), "kind", "must be one of: "+strings.Join(MediaAssetKinds, ", "))
+
+	v.Check(validator.NotBlank(asset.Filename), "filename", "must be provided")
+	v.Check(validator.MaxRunes(asset.Filename, 255), "filename", "must not be more than 255 characters long")
+	v.Check(validator.ValidUTF8(asset.Filename), "filename", "must be valid UTF-8")
+	v.Check(!strings.ContainsAny(asset.Filename, `/\`), "filename", "must not contain path separators")
+
+	_, _, err := mime.ParseMediaType(asset.ContentType)
+	v.Check(asset.ContentType != "", "content_type", "must be provided")
+	v.Check(asset.ContentType == "" || err == nil, "content_type", "must be a valid media type")
+
+	v.Check(asset.Size > 0, "size", "must be greater than zero")
+	v.Check(asset.Size <= maxSize, "size", fmt.Sprintf("must not be more than %d bytes", maxSize))
+
+	v.Check(asset.SHA256 != "", "sha256", "must be provided")
+	v.Check(asset.SHA256 == "" || validator.Matches(asset.SHA256, validator.SHA256RX), "sha256", "must be a hex-encoded SHA-256 digest")
+}
+
+type MediaAssetModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m MediaAssetModel) WithTx(tx *sql.Tx) MediaAssetModel {
+	m.DB = tx
+	return m
+}
+
+// Insert creates the asset in the uploading state, under a new random
+// storage key.
+func (m MediaAssetModel) Insert(ctx context.Context, asset *MediaAsset) error {
+	query := `
+		INSERT INTO media_assets (video_id, kind, filename, content_type, size, sha256, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, status, uploaded_bytes, created_at, version`
+
+	asset.StorageKey = fmt.Sprintf("videos/%s/assets/%s", asset.VideoID, rand.Text())
+
+	args := []any{asset.VideoID, asset.Kind, asset.Filename, asset.ContentType, asset.Size, asset.SHA256, asset.StorageKey}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "MediaAssetModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&asset.ID,
+		&asset.Status,
+		&asset.UploadedBytes,
+		&asset.CreatedAt,
+		&asset.Version,
+	)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	asset.Parts = []int64{}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+func (m MediaAssetModel) Get(ctx context.Context, videoID string, id int64) (*MediaAsset, error) {
+	if videoID == "" || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, video_id, kind, filename, content_type, size, sha256, storage_key, status, uploaded_bytes, parts, created_at, version,
+			duration_ms, width, height, video_codec, audio_codec, audio_language, probed_at
+		FROM media_assets
+		WHERE video_id = $1 AND id = $2`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "MediaAssetModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	asset, err := scanMediaAsset(m.DB.QueryRowContext(ctx, query, videoID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return asset, nil
+}
+
+func (m MediaAssetModel) GetAllForVideo(ctx context.Context, videoID string) ([]*MediaAsset, error) {
+	query := `
+		SELECT id, video_id, kind, filename, content_type, size, sha256, storage_key, status, uploaded_bytes, parts, created_at, version,
+			duration_ms, width, height, video_codec, audio_codec, audio_language, probed_at
+		FROM media_assets
+		WHERE video_id = $1
+		ORDER BY id`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "MediaAssetModel.GetAllForVideo", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []*MediaAsset{}
+
+	for rows.Next() {
+		asset, err := scanMediaAsset(rows)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		assets = append(assets, asset)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(assets)))
+	return assets, nil
+}
+
+// GetLatestComplete returns the newest complete asset of a video, whose
+// media the video's renditions and length should come from, or
+// ErrRecordNotFound if it has none.
+func (m MediaAssetModel) GetLatestComplete(ctx context.Context, videoID string) (*MediaAsset, error) {
+	query := `
+		SELECT id, video_id, kind, filename, content_type, size, sha256, storage_key, status, uploaded_bytes, parts, created_at, version,
+			duration_ms, width, height, video_codec, audio_codec, audio_language, probed_at
+		FROM media_assets
+		WHERE video_id = $1 AND status = 'complete'
+		ORDER BY id DESC
+		LIMIT 1`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "MediaAssetModel.GetLatestComplete", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	asset, err := scanMediaAsset(m.DB.QueryRowContext(ctx, query, videoID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return asset, nil
+}
+
+// Update saves the upload progress of the asset. Like VideoModel.Update it
+// returns ErrEditConflict if the asset has changed since it was read, which
+// is how two clients uploading the same chunk at once are told apart.
+func (m MediaAssetModel) Update(ctx context.Context, asset *MediaAsset) error {
+	query := `
+		UPDATE media_assets
+		SET status = $1, uploaded_bytes = $2, parts = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []any{asset.Status, asset.UploadedBytes, pq.Array(asset.Parts), asset.ID, asset.Version}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "MediaAssetModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&asset.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrEditConflict
+		default:
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+// SetMetadata stores the probed metadata of the asset and sets its ProbedAt.
+// Only a complete asset is probed, so there is no concurrent upload for the
+// version to guard against; it is still bumped so clients can tell the asset
+// has changed.
+func (m MediaAssetModel) SetMetadata(ctx context.Context, asset *MediaAsset, md *MediaMetadata) error {
+	query := `
+		UPDATE media_assets
+		SET duration_ms = $1, width = $2, height = $3, video_codec = $4, audio_codec = $5, audio_language = $6,
+			probed_at = NOW(), version = version + 1
+		WHERE id = $7
+		RETURNING probed_at, version`
+
+	args := []any{md.DurationMS, md.Width, md.Height, md.VideoCodec, md.AudioCodec, md.AudioLanguage, asset.ID}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "MediaAssetModel.SetMetadata", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&md.ProbedAt, &asset.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	asset.Metadata = md
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMediaAsset(row rowScanner) (*MediaAsset, error) {
+	var (
+		asset    MediaAsset
+		md       MediaMetadata
+		probedAt *time.Time
+	)
+
+	err := row.Scan(
+		&asset.ID,
+		&asset.VideoID,
+		&asset.Kind,
+		&asset.Filename,
+		&asset.ContentType,
+		&asset.Size,
+		&asset.SHA256,
+		&asset.StorageKey,
+		&asset.Status,
+		&asset.UploadedBytes,
+		pq.Array(&asset.Parts),
+		&asset.CreatedAt,
+		&asset.Version,
+		&md.DurationMS,
+		&md.Width,
+		&md.Height,
+		&md.VideoCodec,
+		&md.AudioCodec,
+		&md.AudioLanguage,
+		&probedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if probedAt != nil {
+		md.ProbedAt = *probedAt
+		asset.Metadata = &md
+	}
+
+	return &asset, nil
+}
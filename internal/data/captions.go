@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/captions"
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/lib/pq"
+)
+
+// MaxCaptionCues bounds the number of cues in one caption track.
+const MaxCaptionCues = 20_000
+
+// CaptionTrack summarises the captions of a video in one language.
+type CaptionTrack struct {
+	Language  string    `json:"language"`
+	Cues      int       `json:"cues"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateCaptionLanguage(v *validator.Validator, language string) {
+	v.Check(validator.ValidLanguageTag(language), "language", "must be a BCP 47 language tag, such as ar or en-GB")
+}
+
+// CaptionModel stores caption tracks one row per cue, so the text of each cue
+// is indexed for full-text search on its own.
+type CaptionModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m CaptionModel) WithTx(tx *sql.Tx) CaptionModel {
+	m.DB = tx
+	return m
+}
+
+// Insert adds the cues of a track in one statement. Replacing a track means
+// calling Delete first, in the same transaction.
+func (m CaptionModel) Insert(ctx context.Context, videoID, language string, cues []captions.Cue) error {
+	query := `
+		INSERT INTO video_captions (video_id, language, seq, identifier, start_ms, end_ms, settings, text, plain_text)
+		SELECT $1, $2, cue.*
+		FROM unnest($3::integer[], $4::text[], $5::bigint[], $6::bigint[], $7::text[], $8::text[], $9::text[]) AS cue`
+
+	var (
+		seqs       = make([]int64, len(cues))
+		ids        = make([]string, len(cues))
+		starts     = make([]int64, len(cues))
+		ends       = make([]int64, len(cues))
+		settings   = make([]string, len(cues))
+		texts      = make([]string, len(cues))
+		plainTexts = make([]string, len(cues))
+	)
+
+	for i, cue := range cues {
+		seqs[i] = int64(i)
+		ids[i] = cue.ID
+		starts[i] = cue.Start.Milliseconds()
+		ends[i] = cue.End.Milliseconds()
+		settings[i] = cue.Settings
+		texts[i] = cue.Text
+		plainTexts[i] = captions.CueText(cue)
+	}
+
+	args := []any{
+		videoID,
+		language,
+		pq.Array(seqs),
+		pq.Array(ids),
+		pq.Array(starts),
+		pq.Array(ends),
+		pq.Array(settings),
+		pq.Array(texts),
+		pq.Array(plainTexts),
+	}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "CaptionModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int64("db.row_count", rowsAffected))
+	return nil
+}
+
+// Get returns the cues of a track in order, or ErrRecordNotFound if the video
+// has no captions in that language.
+func (m CaptionModel) Get(ctx context.Context, videoID, language string) ([]captions.Cue, error) {
+	query := `
+		SELECT identifier, start_ms, end_ms, settings, text
+		FROM video_captions
+		WHERE video_id = $1 AND language = $2
+		ORDER BY seq`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "CaptionModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID, language)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cues []captions.Cue
+
+	for rows.Next() {
+		var (
+			cue            captions.Cue
+			startMS, endMS int64
+		)
+
+		err := rows.Scan(&cue.ID, &startMS, &endMS, &cue.Settings, &cue.Text)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		cue.Start = time.Duration(startMS) * time.Millisecond
+		cue.End = time.Duration(endMS) * time.Millisecond
+
+		cues = append(cues, cue)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(cues)))
+
+	if len(cues) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return cues, nil
+}
+
+// GetTracks lists the caption tracks of a video by language.
+func (m CaptionModel) GetTracks(ctx context.Context, videoID string) ([]*CaptionTrack, error) {
+	query := `
+		SELECT language, count(*), min(created_at)
+		FROM video_captions
+		WHERE video_id = $1
+		GROUP BY language
+		ORDER BY language`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "CaptionModel.GetTracks", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []*CaptionTrack{}
+
+	for rows.Next() {
+		var track CaptionTrack
+
+		err := rows.Scan(&track.Language, &track.Cues, &track.CreatedAt)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		tracks = append(tracks, &track)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(tracks)))
+	return tracks, nil
+}
+
+// Delete removes a track, returning ErrRecordNotFound if there was none.
+func (m CaptionModel) Delete(ctx context.Context, videoID, language string) error {
+	query := `
+		DELETE FROM video_captions
+		WHERE video_id = $1 AND language = $2`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "CaptionModel.Delete", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, videoID, language)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int64("db.row_count", rowsAffected))
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/JLL32/thmanyah/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Chapter marks a section of a video. Times are in seconds from the start,
+// like Video.Length. A chapter without an EndTime runs until the next one, or
+// the end of the video.
+type Chapter struct {
+	ID        int64     `json:"id"`
+	VideoID   string    `json:"video_id"`
+	StartTime int       `json:"start_time"`
+	EndTime   *int      `json:"end_time,omitempty"`
+	Title     string    `json:"title"`
+	ImageURL  string    `json:"image_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"version"`
+}
+
+// ValidateChapter checks chapter against the length of its video and the
+// video's other chapters, which may include chapter itself. Chapters must
+// start at different times, and a chapter with an end time must end before
+// the next one starts.
+func ValidateChapter(v *validator.Validator, chapter *Chapter, length int, others []*Chapter) {
+	v.Check(validator.NotBlank(chapter.Title), "title", "must be provided")
+	v.Check(validator.MaxRunes(chapter.Title, 200), "title", "must not be more than 200 characters long")
+	v.Check(validator.ValidUTF8(chapter.Title), "title", "must be valid UTF-8")
+
+	v.Check(chapter.StartTime >= 0, "start_time", "must not be negative")
+	v.Check(chapter.StartTime < length, "start_time", fmt.Sprintf("must be less than the length of the video (%d seconds)", length))
+
+	if chapter.EndTime != nil {
+		v.Check(*chapter.EndTime > chapter.StartTime, "end_time", "must be greater than start_time")
+		v.Check(*chapter.EndTime <= length, "end_time", fmt.Sprintf("must not be greater than the length of the video (%d seconds)", length))
+	}
+
+	if chapter.ImageURL != "" {
+		v.Check(validator.HTTPURL(chapter.ImageURL), "image_url", "must be an absolute http or https URL")
+		v.Check(len(chapter.ImageURL) <= 2048, "image_url", "must not be more than 2048 bytes long")
+	}
+
+	for _, other := range others {
+		switch {
+		case other.ID == chapter.ID:
+			continue
+
+		case other.StartTime == chapter.StartTime:
+			v.AddError("start_time", fmt.Sprintf("must differ from the start of chapter %d", other.ID))
+
+		case other.StartTime < chapter.StartTime && other.EndTime != nil && *other.EndTime > chapter.StartTime:
+			v.AddError("start_time", fmt.Sprintf("must not be before the end of chapter %d (%d seconds)", other.ID, *other.EndTime))
+
+		case other.StartTime > chapter.StartTime && chapter.EndTime != nil && *chapter.EndTime > other.StartTime:
+			v.AddError("end_time", fmt.Sprintf("must not be after the start of chapter %d (%d seconds)", other.ID, other.StartTime))
+		}
+	}
+}
+
+type ChapterModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m ChapterModel) WithTx(tx *sql.Tx) ChapterModel {
+	m.DB = tx
+	return m
+}
+
+// translateChapterError reports a clash with the unique start time of
+// another chapter, which can only happen when two writes race, as a
+// validation error.
+func translateChapterError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == "video_chapters_video_id_start_time_key" {
+		return &FieldError{Field: "start_time", Message: "must differ from the start of every other chapter"}
+	}
+
+	return err
+}
+
+func (m ChapterModel) Insert(ctx context.Context, chapter *Chapter) error {
+	query := `
+		INSERT INTO video_chapters (video_id, start_time, end_time, title, image_url)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, version`
+
+	args := []any{chapter.VideoID, chapter.StartTime, chapter.EndTime, chapter.Title, chapter.ImageURL}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ChapterModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&chapter.ID, &chapter.CreatedAt, &chapter.Version)
+	if err != nil {
+		span.RecordError(err)
+		return translateChapterError(err)
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+// GetAllForVideo returns the chapters of a video in order of start time.
+func (m ChapterModel) GetAllForVideo(ctx context.Context, videoID string) ([]*Chapter, error) {
+	query := `
+		SELECT id, video_id, start_time, end_time, title, image_url, created_at, version
+		FROM video_chapters
+		WHERE video_id = $1
+		ORDER BY start_time`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ChapterModel.GetAllForVideo", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []*Chapter{}
+
+	for rows.Next() {
+		var chapter Chapter
+
+		err := rows.Scan(
+			&chapter.ID,
+			&chapter.VideoID,
+			&chapter.StartTime,
+			&chapter.EndTime,
+			&chapter.Title,
+			&chapter.ImageURL,
+			&chapter.CreatedAt,
+			&chapter.Version,
+		)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		chapters = append(chapters, &chapter)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(chapters)))
+	return chapters, nil
+}
+
+func (m ChapterModel) Get(ctx context.Context, videoID string, id int64) (*Chapter, error) {
+	query := `
+		SELECT id, video_id, start_time, end_time, title, image_url, created_at, version
+		FROM video_chapters
+		WHERE video_id = $1 AND id = $2`
+
+	var chapter Chapter
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ChapterModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, videoID, id).Scan(
+		&chapter.ID,
+		&chapter.VideoID,
+		&chapter.StartTime,
+		&chapter.EndTime,
+		&chapter.Title,
+		&chapter.ImageURL,
+		&chapter.CreatedAt,
+		&chapter.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return &chapter, nil
+}
+
+func (m ChapterModel) Update(ctx context.Context, chapter *Chapter) error {
+	query := `
+		UPDATE video_chapters
+		SET start_time = $1, end_time = $2, title = $3, image_url = $4, version = version + 1
+		WHERE video_id = $5 AND id = $6 AND version = $7
+		RETURNING version`
+
+	args := []any{
+		chapter.StartTime,
+		chapter.EndTime,
+		chapter.Title,
+		chapter.ImageURL,
+		chapter.VideoID,
+		chapter.ID,
+		chapter.Version,
+	}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ChapterModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&chapter.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrEditConflict
+		default:
+			span.RecordError(err)
+			return translateChapterError(err)
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+func (m ChapterModel) Delete(ctx context.Context, videoID string, id int64) error {
+	query := `
+		DELETE FROM video_chapters
+		WHERE video_id = $1 AND id = $2`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ChapterModel.Delete", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, videoID, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int64("db.row_count", rowsAffected))
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
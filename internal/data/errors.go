@@ -0,0 +1,69 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateVideoID = errors.New("duplicate video id")
+
+// FieldError reports a value rejected by a database constraint, in terms of
+// the API field it came from.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// constraintFields maps check constraints to the field and message reported
+// when they are violated.
+var constraintFields = map[string]FieldError{
+	"videos_language_check":     {Field: "language", Message: "must be a two-letter lowercase ISO 639-1 code"},
+	"videos_length_check":       {Field: "length", Message: "must not be negative"},
+	"videos_published_at_check": {Field: "published_at", Message: "must not be in the future"},
+}
+
+// translateVideoError converts PostgreSQL errors raised by writes to the
+// videos table into errors the handlers can report to the client. Anything it
+// doesn't recognise is returned unchanged.
+func translateVideoError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code.Name() {
+	case "unique_violation":
+		if pqErr.Constraint == "videos_pkey" {
+			return ErrDuplicateVideoID
+		}
+
+	case "check_violation":
+		if fe, ok := constraintFields[pqErr.Constraint]; ok {
+			return &fe
+		}
+
+	case "invalid_text_representation":
+		// Postgres doesn't report the column here, but video_type is the
+		// only enum a video write can fail to parse.
+		if strings.Contains(pqErr.Message, "video_type") {
+			return &FieldError{Field: "type", Message: "must be one of: " + strings.Join(VideoTypes, ", ")}
+		}
+
+	case "string_data_right_truncation":
+		switch {
+		case strings.Contains(pqErr.Message, "varying(11)"):
+			return &FieldError{Field: "video_id", Message: "must not be more than 11 bytes long"}
+		case strings.Contains(pqErr.Message, "varying(2)"):
+			return &FieldError{Field: "language", Message: "must not be more than 2 bytes long"}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// IdempotencyKey records a mutating request made with an Idempotency-Key
+// header. StatusCode is zero while the original request is still in flight.
+type IdempotencyKey struct {
+	Key             string
+	Method          string
+	Path            string
+	Fingerprint     []byte
+	StatusCode      int
+	ResponseHeaders http.Header
+	ResponseBody    []byte
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+}
+
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Reserve inserts the key in the in-flight state. It returns false, without
+// error, if the key has already been used and hasn't yet expired.
+func (m IdempotencyKeyModel) Reserve(ctx context.Context, key *IdempotencyKey) (bool, error) {
+	query := `
+		INSERT INTO idempotency_keys (key, method, path, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO UPDATE
+		SET method = EXCLUDED.method, path = EXCLUDED.path, fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL, response_headers = NULL, response_body = NULL,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		RETURNING created_at`
+
+	args := []any{key.Key, key.Method, key.Path, key.Fingerprint, key.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (m IdempotencyKeyModel) Get(ctx context.Context, key string) (*IdempotencyKey, error) {
+	query := `
+		SELECT key, method, path, fingerprint, status_code, response_headers, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE key = $1`
+
+	var (
+		k          IdempotencyKey
+		statusCode sql.NullInt64
+		headers    []byte
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(
+		&k.Key,
+		&k.Method,
+		&k.Path,
+		&k.Fingerprint,
+		&statusCode,
+		&headers,
+		&k.ResponseBody,
+		&k.CreatedAt,
+		&k.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	k.StatusCode = int(statusCode.Int64)
+
+	if headers != nil {
+		err = json.Unmarshal(headers, &k.ResponseHeaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &k, nil
+}
+
+// Complete stores the response so that retries with the same key can be
+// answered without running the request again.
+func (m IdempotencyKeyModel) Complete(ctx context.Context, key *IdempotencyKey) error {
+	headers, err := json.Marshal(key.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, response_headers = $2, response_body = $3
+		WHERE key = $4`
+
+	args := []any{key.StatusCode, headers, key.ResponseBody, key.Key}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m IdempotencyKeyModel) Delete(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+func (m IdempotencyKeyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
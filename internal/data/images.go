@@ -0,0 +1,388 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/lib/pq"
+)
+
+// ImageKinds are the kinds of image a video can have: a poster (landscape
+// thumbnail) for apps, and square artwork for podcast feeds.
+var ImageKinds = []string{"poster", "artwork"}
+
+// ShowImageKinds are the kinds of image a show can have: the square artwork
+// of its podcast feed.
+var ShowImageKinds = []string{"artwork"}
+
+// ImageRendition is one size of an uploaded image, stored as a JPEG.
+type ImageRendition struct {
+	Kind       string
+	Width      int
+	Height     int
+	StorageKey string
+	Size       int64
+	CreatedAt  time.Time
+}
+
+// VideoImage is one rendition of an image of a video.
+type VideoImage struct {
+	VideoID string
+	ImageRendition
+}
+
+// ShowImage is one rendition of an image of a show.
+type ShowImage struct {
+	ShowID int64
+	ImageRendition
+}
+
+// Thumbnail is how an image rendition appears in the Video and Show JSON.
+type Thumbnail struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+type VideoImageModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m VideoImageModel) WithTx(tx *sql.Tx) VideoImageModel {
+	m.DB = tx
+	return m
+}
+
+func (m VideoImageModel) Insert(ctx context.Context, img *VideoImage) error {
+	query := `
+		INSERT INTO video_images (video_id, kind, width, height, storage_key, size)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	args := []any{img.VideoID, img.Kind, img.Width, img.Height, img.StorageKey, img.Size}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "VideoImageModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&img.CreatedAt)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+func (m VideoImageModel) Get(ctx context.Context, videoID, kind string, width, height int) (*VideoImage, error) {
+	query := `
+		SELECT video_id, kind, width, height, storage_key, size, created_at
+		FROM video_images
+		WHERE video_id = $1 AND kind = $2 AND width = $3 AND height = $4`
+
+	var img VideoImage
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "VideoImageModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, videoID, kind, width, height).Scan(
+		&img.VideoID,
+		&img.Kind,
+		&img.Width,
+		&img.Height,
+		&img.StorageKey,
+		&img.Size,
+		&img.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return &img, nil
+}
+
+// GetAllForVideos returns the images of all the given videos in one query,
+// largest first within each kind.
+func (m VideoImageModel) GetAllForVideos(ctx context.Context, videoIDs []string) ([]*VideoImage, error) {
+	query := `
+		SELECT video_id, kind, width, height, storage_key, size, created_at
+		FROM video_images
+		WHERE video_id = ANY($1)
+		ORDER BY video_id, kind, width DESC, height DESC`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "VideoImageModel.GetAllForVideos", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(videoIDs))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*VideoImage{}
+
+	for rows.Next() {
+		var img VideoImage
+
+		err := rows.Scan(
+			&img.VideoID,
+			&img.Kind,
+			&img.Width,
+			&img.Height,
+			&img.StorageKey,
+			&img.Size,
+			&img.CreatedAt,
+		)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		images = append(images, &img)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(images)))
+	return images, nil
+}
+
+// DeleteKind deletes every rendition of one kind of image of a video and
+// returns their storage keys, so the blobs can be removed too.
+func (m VideoImageModel) DeleteKind(ctx context.Context, videoID, kind string) ([]string, error) {
+	query := `
+		DELETE FROM video_images
+		WHERE video_id = $1 AND kind = $2
+		RETURNING storage_key`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "VideoImageModel.DeleteKind", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID, kind)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(keys)))
+	return keys, nil
+}
+
+type ShowImageModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m ShowImageModel) WithTx(tx *sql.Tx) ShowImageModel {
+	m.DB = tx
+	return m
+}
+
+func (m ShowImageModel) Insert(ctx context.Context, img *ShowImage) error {
+	query := `
+		INSERT INTO show_images (show_id, kind, width, height, storage_key, size)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	args := []any{img.ShowID, img.Kind, img.Width, img.Height, img.StorageKey, img.Size}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowImageModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&img.CreatedAt)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+func (m ShowImageModel) Get(ctx context.Context, showID int64, kind string, width, height int) (*ShowImage, error) {
+	query := `
+		SELECT show_id, kind, width, height, storage_key, size, created_at
+		FROM show_images
+		WHERE show_id = $1 AND kind = $2 AND width = $3 AND height = $4`
+
+	var img ShowImage
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowImageModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, showID, kind, width, height).Scan(
+		&img.ShowID,
+		&img.Kind,
+		&img.Width,
+		&img.Height,
+		&img.StorageKey,
+		&img.Size,
+		&img.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return &img, nil
+}
+
+// GetAllForShows returns the images of all the given shows in one query,
+// largest first within each kind.
+func (m ShowImageModel) GetAllForShows(ctx context.Context, showIDs []int64) ([]*ShowImage, error) {
+	query := `
+		SELECT show_id, kind, width, height, storage_key, size, created_at
+		FROM show_images
+		WHERE show_id = ANY($1)
+		ORDER BY show_id, kind, width DESC, height DESC`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowImageModel.GetAllForShows", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(showIDs))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*ShowImage{}
+
+	for rows.Next() {
+		var img ShowImage
+
+		err := rows.Scan(
+			&img.ShowID,
+			&img.Kind,
+			&img.Width,
+			&img.Height,
+			&img.StorageKey,
+			&img.Size,
+			&img.CreatedAt,
+		)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		images = append(images, &img)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(images)))
+	return images, nil
+}
+
+// DeleteKind deletes every rendition of one kind of image of a show and
+// returns their storage keys, so the blobs can be removed too.
+func (m ShowImageModel) DeleteKind(ctx context.Context, showID int64, kind string) ([]string, error) {
+	query := `
+		DELETE FROM show_images
+		WHERE show_id = $1 AND kind = $2
+		RETURNING storage_key`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowImageModel.DeleteKind", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, showID, kind)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(keys)))
+	return keys, nil
+}
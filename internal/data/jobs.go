@@ -0,0 +1,320 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+const (
+	JobKindTranscode = "transcode"
+	JobKindProbe     = "probe"
+)
+
+// Job is a unit of background work about a video. Workers claim queued jobs
+// with a lease; a job whose lease runs out, because its worker died, can be
+// claimed again by another worker.
+type Job struct {
+	ID          int64           `json:"id"`
+	VideoID     string          `json:"video_id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Error       string          `json:"error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// AssetPayload is the payload of the jobs that process a media asset,
+// JobKindTranscode and JobKindProbe.
+type AssetPayload struct {
+	AssetID int64 `json:"asset_id"`
+}
+
+type JobModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m JobModel) WithTx(tx *sql.Tx) JobModel {
+	m.DB = tx
+	return m
+}
+
+const jobColumns = `id, video_id, kind, payload, status, attempts, max_attempts, error, run_at, started_at, finished_at, created_at`
+
+func scanJob(row rowScanner) (*Job, error) {
+	var (
+		job     Job
+		payload []byte
+	)
+
+	err := row.Scan(
+		&job.ID,
+		&job.VideoID,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.Error,
+		&job.RunAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = payload
+
+	return &job, nil
+}
+
+// Enqueue inserts a queued job. An empty Payload is stored as {}, and
+// MaxAttempts and RunAt take their database defaults when zero.
+func (m JobModel) Enqueue(ctx context.Context, job *Job) error {
+	query := `
+		INSERT INTO jobs (video_id, kind, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4, 0), 3), COALESCE($5, NOW()))
+		RETURNING ` + jobColumns
+
+	payload := []byte(job.Payload)
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+
+	var runAt *time.Time
+	if !job.RunAt.IsZero() {
+		runAt = &job.RunAt
+	}
+
+	args := []any{job.VideoID, job.Kind, payload, job.MaxAttempts, runAt}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "JobModel.Enqueue", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	inserted, err := scanJob(m.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	*job = *inserted
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+// Claim marks the next due job as running for the given lease and returns it,
+// or returns ErrRecordNotFound if there is none. SKIP LOCKED lets any number
+// of workers poll concurrently without blocking on or double-claiming a job.
+func (m JobModel) Claim(ctx context.Context, lease time.Duration) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, error = '',
+			locked_until = NOW() + $1 * interval '1 millisecond', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'queued' AND run_at <= NOW())
+			OR (status = 'running' AND locked_until < NOW())
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "JobModel.Claim", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	job, err := scanJob(m.DB.QueryRowContext(ctx, query, lease.Milliseconds()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return job, nil
+}
+
+// Extend renews the lease of a running job. It returns ErrEditConflict if the
+// job is no longer held by this claim, e.g. because the lease ran out and
+// another worker took it over.
+func (m JobModel) Extend(ctx context.Context, job *Job, lease time.Duration) error {
+	query := `
+		UPDATE jobs
+		SET locked_until = NOW() + $1 * interval '1 millisecond'
+		WHERE id = $2 AND attempts = $3 AND status = 'running'`
+
+	return m.execHeld(ctx, "JobModel.Extend", query, lease.Milliseconds(), job.ID, job.Attempts)
+}
+
+// Hold locks the row of a running job until the end of the enclosing
+// transaction, so that its results can be saved knowing no other worker has
+// taken it over. It returns ErrEditConflict if the job is no longer held by
+// this claim, and only makes sense on a model returned by WithTx.
+func (m JobModel) Hold(ctx context.Context, job *Job) error {
+	query := `
+		SELECT id
+		FROM jobs
+		WHERE id = $1 AND attempts = $2 AND status = 'running'
+		FOR UPDATE`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "JobModel.Hold", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query, job.ID, job.Attempts).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrEditConflict
+		default:
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+// Complete marks a running job as succeeded.
+func (m JobModel) Complete(ctx context.Context, job *Job) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', locked_until = NULL, finished_at = NOW()
+		WHERE id = $1 AND attempts = $2 AND status = 'running'`
+
+	return m.execHeld(ctx, "JobModel.Complete", query, job.ID, job.Attempts)
+}
+
+// Fail records the error of a running job. The job is queued again to run at
+// retryAt if it has attempts left, and marked as failed otherwise.
+func (m JobModel) Fail(ctx context.Context, job *Job, jobErr error, retryAt time.Time) error {
+	query := `
+		UPDATE jobs
+		SET status = CASE WHEN attempts < max_attempts THEN 'queued' ELSE 'failed' END,
+			run_at = $1, error = $2, locked_until = NULL,
+			finished_at = CASE WHEN attempts < max_attempts THEN NULL ELSE NOW() END
+		WHERE id = $3 AND attempts = $4 AND status = 'running'`
+
+	return m.execHeld(ctx, "JobModel.Fail", query, retryAt, jobErr.Error(), job.ID, job.Attempts)
+}
+
+// Release puts a running job back in the queue without counting the attempt,
+// for when its worker stops before finishing, e.g. on shutdown.
+func (m JobModel) Release(ctx context.Context, job *Job) error {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = attempts - 1, locked_until = NULL, started_at = NULL
+		WHERE id = $1 AND attempts = $2 AND status = 'running'`
+
+	return m.execHeld(ctx, "JobModel.Release", query, job.ID, job.Attempts)
+}
+
+// execHeld runs an update guarded by the job's claim, returning
+// ErrEditConflict if it matched no row.
+func (m JobModel) execHeld(ctx context.Context, spanName, query string, args ...any) error {
+	ctx, span := startQuerySpan(ctx, m.Tracer, spanName, query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int64("db.row_count", rowsAffected))
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// GetAllForVideo returns the jobs of a video, newest first.
+func (m JobModel) GetAllForVideo(ctx context.Context, videoID string) ([]*Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE video_id = $1
+		ORDER BY id DESC`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "JobModel.GetAllForVideo", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(jobs)))
+	return jobs, nil
+}
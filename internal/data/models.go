@@ -1,21 +1,86 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"strings"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
 )
 
+// SchemaVersion is the migration version this build expects the database to
+// be at. Bump it whenever a migration is added under ./migrations.
+const SchemaVersion = 14
+
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so that model methods can be
+// used inside or outside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
-	Videos VideoModel
+	Videos          VideoModel
+	Schema          SchemaModel
+	IdempotencyKeys IdempotencyKeyModel
+	MediaAssets     MediaAssetModel
+	VideoImages     VideoImageModel
+	Jobs            JobModel
+	Renditions      RenditionModel
+	Captions        CaptionModel
+	Chapters        ChapterModel
+	SyncRuns        SyncRunModel
+	Shows           ShowModel
+	ShowImages      ShowImageModel
 }
 
-func NewModels(db *sql.DB) Models {
+func NewModels(db *sql.DB, tracer *tracing.Tracer) Models {
 	return Models{
-		Videos: VideoModel{DB: db},
+		Videos:          VideoModel{DB: db, Tracer: tracer},
+		Schema:          SchemaModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		MediaAssets:     MediaAssetModel{DB: db, Tracer: tracer},
+		VideoImages:     VideoImageModel{DB: db, Tracer: tracer},
+		Jobs:            JobModel{DB: db, Tracer: tracer},
+		Renditions:      RenditionModel{DB: db, Tracer: tracer},
+		Captions:        CaptionModel{DB: db, Tracer: tracer},
+		Chapters:        ChapterModel{DB: db, Tracer: tracer},
+		SyncRuns:        SyncRunModel{DB: db, Tracer: tracer},
+		Shows:           ShowModel{DB: db, Tracer: tracer},
+		ShowImages:      ShowImageModel{DB: db, Tracer: tracer},
 	}
 }
+
+// WithTx returns a copy of the models whose video-related queries run in tx.
+func (m Models) WithTx(tx *sql.Tx) Models {
+	m.Videos = m.Videos.WithTx(tx)
+	m.MediaAssets = m.MediaAssets.WithTx(tx)
+	m.VideoImages = m.VideoImages.WithTx(tx)
+	m.Jobs = m.Jobs.WithTx(tx)
+	m.Renditions = m.Renditions.WithTx(tx)
+	m.Captions = m.Captions.WithTx(tx)
+	m.Chapters = m.Chapters.WithTx(tx)
+	m.SyncRuns = m.SyncRuns.WithTx(tx)
+	m.Shows = m.Shows.WithTx(tx)
+	m.ShowImages = m.ShowImages.WithTx(tx)
+	return m
+}
+
+func startQuerySpan(ctx context.Context, tracer *tracing.Tracer, name, query string) (context.Context, *tracing.Span) {
+	statement := strings.Join(strings.Fields(query), " ")
+
+	operation, _, _ := strings.Cut(statement, " ")
+
+	return tracer.Start(ctx, name, tracing.SpanKindClient,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.operation", strings.ToUpper(operation)),
+		tracing.String("db.statement", statement),
+	)
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/lib/pq"
+)
+
+// Rendition is one HLS variant of a video, produced by a transcode job. Its
+// media playlist and segments are stored as blobs; BlobKeys lists all of
+// them, playlist included.
+type Rendition struct {
+	VideoID     string    `json:"-"`
+	Name        string    `json:"name"`
+	AssetID     int64     `json:"asset_id"`
+	JobID       int64     `json:"job_id"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	Bandwidth   int       `json:"bandwidth"`
+	Codecs      string    `json:"codecs"`
+	PlaylistKey string    `json:"-"`
+	BlobKeys    []string  `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RenditionModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m RenditionModel) WithTx(tx *sql.Tx) RenditionModel {
+	m.DB = tx
+	return m
+}
+
+func (m RenditionModel) Insert(ctx context.Context, rendition *Rendition) error {
+	query := `
+		INSERT INTO video_renditions (video_id, name, asset_id, job_id, width, height, bandwidth, codecs, playlist_key, blob_keys)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING created_at`
+
+	args := []any{
+		rendition.VideoID,
+		rendition.Name,
+		rendition.AssetID,
+		rendition.JobID,
+		rendition.Width,
+		rendition.Height,
+		rendition.Bandwidth,
+		rendition.Codecs,
+		rendition.PlaylistKey,
+		pq.Array(rendition.BlobKeys),
+	}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "RenditionModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rendition.CreatedAt)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+// GetAllForVideo returns the renditions of a video, highest bandwidth first.
+func (m RenditionModel) GetAllForVideo(ctx context.Context, videoID string) ([]*Rendition, error) {
+	query := `
+		SELECT video_id, name, asset_id, COALESCE(job_id, 0), width, height, bandwidth, codecs, playlist_key, blob_keys, created_at
+		FROM video_renditions
+		WHERE video_id = $1
+		ORDER BY bandwidth DESC`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "RenditionModel.GetAllForVideo", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []*Rendition{}
+
+	for rows.Next() {
+		var rendition Rendition
+
+		err := rows.Scan(
+			&rendition.VideoID,
+			&rendition.Name,
+			&rendition.AssetID,
+			&rendition.JobID,
+			&rendition.Width,
+			&rendition.Height,
+			&rendition.Bandwidth,
+			&rendition.Codecs,
+			&rendition.PlaylistKey,
+			pq.Array(&rendition.BlobKeys),
+			&rendition.CreatedAt,
+		)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		renditions = append(renditions, &rendition)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(renditions)))
+	return renditions, nil
+}
+
+// DeleteAllForVideo deletes the renditions of a video and returns their blob
+// keys, so the blobs can be removed too.
+func (m RenditionModel) DeleteAllForVideo(ctx context.Context, videoID string) ([]string, error) {
+	query := `
+		DELETE FROM video_renditions
+		WHERE video_id = $1
+		RETURNING blob_keys`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "RenditionModel.DeleteAllForVideo", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, videoID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	count := 0
+
+	for rows.Next() {
+		var blobKeys []string
+
+		err := rows.Scan(pq.Array(&blobKeys))
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		keys = append(keys, blobKeys...)
+		count++
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", count))
+	return keys, nil
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type SchemaModel struct {
+	DB *sql.DB
+}
+
+// Version returns the current version recorded by golang-migrate in the
+// schema_migrations table, and whether the last migration left it dirty.
+func (s SchemaModel) Version(ctx context.Context) (int, bool, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		version int
+		dirty   bool
+	)
+
+	err := s.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+	"github.com/JLL32/thmanyah/internal/validator"
+)
+
+// Show is a podcast or series that videos are published under. It carries the
+// square artwork of its podcast feed.
+type Show struct {
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	Version     int       `json:"version"`
+
+	// Thumbnails lists the image renditions of the show by kind. It isn't
+	// stored with the show; handlers fill it in from ShowImageModel.
+	Thumbnails map[string][]Thumbnail `json:"thumbnails,omitempty"`
+}
+
+func ValidateShow(v *validator.Validator, show *Show) {
+	v.Check(validator.NotBlank(show.Title), "title", "must be provided")
+	v.Check(validator.MaxRunes(show.Title, 500), "title", "must not be more than 500 characters long")
+	v.Check(validator.ValidUTF8(show.Title), "title", "must be valid UTF-8")
+
+	v.Check(validator.MaxRunes(show.Description, 5000), "description", "must not be more than 5000 characters long")
+	v.Check(validator.ValidUTF8(show.Description), "description", "must be valid UTF-8")
+}
+
+type ShowModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m ShowModel) WithTx(tx *sql.Tx) ShowModel {
+	m.DB = tx
+	return m
+}
+
+func (m ShowModel) Insert(ctx context.Context, show *Show) error {
+	query := `
+		INSERT INTO shows (title, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, show.Title, show.Description).Scan(&show.ID, &show.CreatedAt, &show.Version)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+func (m ShowModel) Get(ctx context.Context, id int64) (*Show, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, title, description, created_at, version
+		FROM shows
+		WHERE id = $1`
+
+	var show Show
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&show.ID,
+		&show.Title,
+		&show.Description,
+		&show.CreatedAt,
+		&show.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return &show, nil
+}
+
+func (m ShowModel) Update(ctx context.Context, show *Show) error {
+	query := `
+		UPDATE shows
+		SET title = $1, description = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{show.Title, show.Description, show.ID, show.Version}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&show.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrEditConflict
+		default:
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+func (m ShowModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM shows
+		WHERE id = $1`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowModel.Delete", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.SetAttributes(tracing.Int64("db.row_count", rowsAffected))
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll lists shows whose title matches the search. An empty search matches
+// anything.
+func (m ShowModel) GetAll(ctx context.Context, title string, filters Filters) ([]*Show, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, title, description, created_at, version
+		FROM shows
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "ShowModel.GetAll", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, filters.limit(), filters.offset())
+	if err != nil {
+		span.RecordError(err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	shows := []*Show{}
+
+	for rows.Next() {
+		var show Show
+
+		err := rows.Scan(
+			&totalRecords,
+			&show.ID,
+			&show.Title,
+			&show.Description,
+			&show.CreatedAt,
+			&show.Version,
+		)
+		if err != nil {
+			span.RecordError(err)
+			return nil, Metadata{}, err
+		}
+
+		shows = append(shows, &show)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, Metadata{}, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(shows)), tracing.Int("db.total_records", totalRecords))
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return shows, metadata, nil
+}
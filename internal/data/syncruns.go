@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/JLL32/thmanyah/internal/tracing"
+)
+
+const (
+	SyncRunStatusRunning   = "running"
+	SyncRunStatusSucceeded = "succeeded"
+	SyncRunStatusFailed    = "failed"
+)
+
+const (
+	SyncTriggerSchedule = "schedule"
+	SyncTriggerAPI      = "api"
+)
+
+// ErrSyncNotDue is returned by SyncRunModel.Start when another run of the
+// channel is in progress or started too recently.
+var ErrSyncNotDue = errors.New("sync not due")
+
+// SyncRun records one pass over the uploads of a channel. A dry run compares
+// the channel with the catalogue and records what would change, without
+// writing any video.
+type SyncRun struct {
+	ID         int64        `json:"id"`
+	Provider   string       `json:"provider"`
+	ChannelID  string       `json:"channel_id"`
+	Trigger    string       `json:"trigger"`
+	DryRun     bool         `json:"dry_run"`
+	Status     string       `json:"status"`
+	Created    int          `json:"created"`
+	Updated    int          `json:"updated"`
+	Unchanged  int          `json:"unchanged"`
+	Invalid    int          `json:"invalid"`
+	Changes    []SyncChange `json:"changes,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// SyncChange is a video that a sync run created, updated or found invalid.
+// Unchanged videos are only counted.
+type SyncChange struct {
+	VideoID string            `json:"video_id"`
+	Status  string            `json:"status"`
+	Fields  []string          `json:"fields,omitempty"`
+	Error   map[string]string `json:"error,omitempty"`
+}
+
+type SyncRunModel struct {
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (m SyncRunModel) WithTx(tx *sql.Tx) SyncRunModel {
+	m.DB = tx
+	return m
+}
+
+// Start records run as running. It returns ErrSyncNotDue instead if another
+// run of the channel with the same trigger started less than minInterval ago,
+// or any run of it is still running and started less than timeout ago. Older
+// runs still marked as running are taken to have died with their instance,
+// and are marked as failed.
+//
+// Start must be called on a model returned by WithTx: it holds a lock on the
+// channel until the transaction ends, so that concurrent calls from several
+// instances start at most one run.
+func (m SyncRunModel) Start(ctx context.Context, run *SyncRun, minInterval, timeout time.Duration) error {
+	lockQuery := `SELECT pg_advisory_xact_lock(hashtext('sync_runs'), hashtext($1))`
+
+	expireQuery := `
+		UPDATE sync_runs
+		SET status = 'failed', error = 'run stopped without finishing', finished_at = NOW()
+		WHERE channel_id = $1 AND status = 'running'
+		AND started_at <= NOW() - $2 * interval '1 millisecond'`
+
+	insertQuery := `
+		INSERT INTO sync_runs (provider, channel_id, trigger, dry_run)
+		SELECT $1::text, $2::text, $3::text, $4::boolean
+		WHERE NOT EXISTS (
+			SELECT 1 FROM sync_runs
+			WHERE channel_id = $2
+			AND (status = 'running' OR (trigger = $3 AND started_at > NOW() - $5 * interval '1 millisecond'))
+		)
+		RETURNING id, status, started_at`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "SyncRunModel.Start", insertQuery)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, lockQuery, run.ChannelID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, expireQuery, run.ChannelID, timeout.Milliseconds())
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	args := []any{run.Provider, run.ChannelID, run.Trigger, run.DryRun, minInterval.Milliseconds()}
+
+	err = m.DB.QueryRowContext(ctx, insertQuery, args...).Scan(&run.ID, &run.Status, &run.StartedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrSyncNotDue
+		default:
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+// Finish stores the status, counts, changes and error of a running run, and
+// sets its FinishedAt.
+func (m SyncRunModel) Finish(ctx context.Context, run *SyncRun) error {
+	changes, err := json.Marshal(run.Changes)
+	if err != nil {
+		return err
+	}
+	if run.Changes == nil {
+		changes = []byte("[]")
+	}
+
+	query := `
+		UPDATE sync_runs
+		SET status = $1, created = $2, updated = $3, unchanged = $4, invalid = $5,
+			changes = $6, error = $7, finished_at = NOW()
+		WHERE id = $8 AND status = 'running'
+		RETURNING finished_at`
+
+	args := []any{run.Status, run.Created, run.Updated, run.Unchanged, run.Invalid, changes, run.Error, run.ID}
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "SyncRunModel.Finish", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&run.FinishedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Another instance took the run to have died and failed it.
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return ErrEditConflict
+		default:
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
+}
+
+const syncRunColumns = `id, provider, channel_id, trigger, dry_run, status, created, updated, unchanged, invalid, error, started_at, finished_at`
+
+func scanSyncRun(row rowScanner, extra ...any) (*SyncRun, error) {
+	var run SyncRun
+
+	dest := []any{
+		&run.ID,
+		&run.Provider,
+		&run.ChannelID,
+		&run.Trigger,
+		&run.DryRun,
+		&run.Status,
+		&run.Created,
+		&run.Updated,
+		&run.Unchanged,
+		&run.Invalid,
+		&run.Error,
+		&run.StartedAt,
+		&run.FinishedAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// Get returns a run with its changes.
+func (m SyncRunModel) Get(ctx context.Context, id int64) (*SyncRun, error) {
+	query := `
+		SELECT ` + syncRunColumns + `, changes
+		FROM sync_runs
+		WHERE id = $1`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "SyncRunModel.Get", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var changes []byte
+
+	run, err := scanSyncRun(m.DB.QueryRowContext(ctx, query, id), &changes)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
+			return nil, ErrRecordNotFound
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(changes, &run.Changes)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return run, nil
+}
+
+// GetAll returns a page of runs, newest first, optionally only those of one
+// channel. Changes are left out; Get returns them.
+func (m SyncRunModel) GetAll(ctx context.Context, channelID string, filters Filters) ([]*SyncRun, Metadata, error) {
+	query := `
+		SELECT ` + syncRunColumns + `, count(*) OVER()
+		FROM sync_runs
+		WHERE (channel_id = $1 OR $1 = '')
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, span := startQuerySpan(ctx, m.Tracer, "SyncRunModel.GetAll", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, channelID, filters.limit(), filters.offset())
+	if err != nil {
+		span.RecordError(err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	runs := []*SyncRun{}
+
+	for rows.Next() {
+		run, err := scanSyncRun(rows, &totalRecords)
+		if err != nil {
+			span.RecordError(err)
+			return nil, Metadata{}, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, Metadata{}, err
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", len(runs)), tracing.Int("db.total_records", totalRecords))
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return runs, metadata, nil
+}
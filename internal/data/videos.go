@@ -2,11 +2,16 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/JLL32/thmanyah/internal/tracing"
 	"github.com/JLL32/thmanyah/internal/validator"
 )
 
@@ -20,43 +25,140 @@ type Video struct {
 	PublishedAt time.Time `json:"published_at"`
 	CreatedAt   time.Time `json:"created_at"`
 	Version     int       `json:"version"`
+
+	// Thumbnails lists the image renditions of the video by kind. It isn't
+	// stored with the video; handlers fill it in from VideoImageModel.
+	Thumbnails map[string][]Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// Published reports whether the video is public at now. Until its
+// published_at time, a video is only visible through the catalogue API, not
+// streamable.
+func (v *Video) Published(now time.Time) bool {
+	return !v.PublishedAt.IsZero() && !v.PublishedAt.After(now)
+}
+
+// VideoTypes mirrors the video_type enum in the database. VideoModel.CheckTypes
+// verifies at startup that the two haven't drifted apart.
+var VideoTypes = []string{"podcast", "documentary"}
+
+func ValidateVideoID(v *validator.Validator, id string) {
+	v.Check(id != "", "video_id", "must be provided")
+	v.Check(validator.Matches(id, validator.VideoIDRX), "video_id", "must be 11 characters long and contain only letters, digits, '-' and '_'")
 }
 
 func ValidateVideo(v *validator.Validator, video *Video) {
-	v.Check(video.Title != "", "title", "must be provided")
-	v.Check(len(video.Title) <= 500, "title", "must not be more than 100 bytes long")
+	v.Check(validator.NotBlank(video.Title), "title", "must be provided")
+	v.Check(validator.MaxRunes(video.Title, 500), "title", "must not be more than 500 characters long")
+	v.Check(validator.ValidUTF8(video.Title), "title", "must be valid UTF-8")
 
-	v.Check(video.Description != "", "description", "must be provided")
-	v.Check(len(video.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	v.Check(validator.NotBlank(video.Description), "description", "must be provided")
+	v.Check(validator.MaxRunes(video.Description, 5000), "description", "must not be more than 5000 characters long")
+	v.Check(validator.ValidUTF8(video.Description), "description", "must be valid UTF-8")
 
 	v.Check(video.Type != "", "type", "must be provided")
+	v.Check(video.Type == "" || validator.PermittedValue(video.Type, VideoTypes...), "type", "must be one of: "+strings.Join(VideoTypes, ", "))
 
 	v.Check(video.Length > 0, "length", "must be greater than zero")
 
 	v.Check(video.Language != "", "language", "must be provided")
-	v.Check(len(video.Language) <= 2, "language", "must not be more than 50 bytes long")
+	v.Check(video.Language == "" || len(video.Language) == 2, "language", "must be exactly 2 characters long")
+	v.Check(video.Language == "" || validator.ISO6391(video.Language), "language", "must be a lowercase ISO 639-1 language code")
 
+	v.Check(!video.PublishedAt.IsZero(), "published_at", "must be provided")
 	v.Check(video.PublishedAt.Before(time.Now()), "published_at", "must not be in the future")
 }
 
 type VideoModel struct {
-	DB *sql.DB
+	DB     DBTX
+	Tracer *tracing.Tracer
+}
+
+// WithTx returns a copy of the model whose queries run in tx.
+func (v VideoModel) WithTx(tx *sql.Tx) VideoModel {
+	v.DB = tx
+	return v
+}
+
+// maxVideoIDAttempts bounds how many generated IDs Insert tries before giving
+// up. With 64 random bits a single collision is already vanishingly rare.
+const maxVideoIDAttempts = 5
+
+// GenerateVideoID returns a random ID in the format described by
+// validator.VideoIDRX: 8 random bytes encoded as 11 characters of unpadded
+// URL-safe base64.
+func GenerateVideoID() (string, error) {
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Insert creates the video. If video.VideoID is empty an ID is generated,
+// retrying with a new one if it collides with an existing video.
+func (v VideoModel) Insert(ctx context.Context, video *Video) error {
+	if video.VideoID != "" {
+		return v.insert(ctx, video)
+	}
+
+	for attempt := 1; ; attempt++ {
+		id, err := GenerateVideoID()
+		if err != nil {
+			return err
+		}
+
+		video.VideoID = id
+
+		err = v.insert(ctx, video)
+		if errors.Is(err, ErrDuplicateVideoID) && attempt < maxVideoIDAttempts {
+			continue
+		}
+		if err != nil {
+			video.VideoID = ""
+		}
+
+		return err
+	}
 }
 
-func (v VideoModel) Insert(video *Video) error {
+func (v VideoModel) insert(ctx context.Context, video *Video) error {
 	query := `INSERT INTO videos (video_id, title, description, type, length, language, published_at)
 	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING video_id, created_at, version`
 
 	args := []any{video.VideoID, video.Title, video.Description, video.Type, video.Length, video.Language, video.PublishedAt}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, v.Tracer, "VideoModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	return v.DB.QueryRowContext(ctx, query, args...).Scan(&video.VideoID, &video.CreatedAt, &video.Version)
+	err := v.DB.QueryRowContext(ctx, query, args...).Scan(&video.VideoID, &video.CreatedAt, &video.Version)
+	if err != nil {
+		span.RecordError(err)
+		return translateVideoError(err)
+	}
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
+	return nil
 }
 
-func (v VideoModel) Get(id string) (*Video, error) {
+func (v VideoModel) Get(ctx context.Context, id string) (*Video, error) {
+	return v.get(ctx, "VideoModel.Get", id, "")
+}
+
+// GetForUpdate is like Get but locks the row until the end of the enclosing
+// transaction. It only makes sense on a model returned by WithTx.
+func (v VideoModel) GetForUpdate(ctx context.Context, id string) (*Video, error) {
+	return v.get(ctx, "VideoModel.GetForUpdate", id, "FOR UPDATE")
+}
+
+func (v VideoModel) get(ctx context.Context, spanName, id, lock string) (*Video, error) {
 	if id == "" {
 		return nil, ErrRecordNotFound
 	}
@@ -65,11 +167,14 @@ func (v VideoModel) Get(id string) (*Video, error) {
 	SELECT video_id, title, description, type, length, language, published_at, created_at, version
 	FROM videos
 	WHERE video_id = $1
-	`
+	` + lock
 
 	var video Video
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, v.Tracer, spanName, query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := v.DB.QueryRowContext(ctx, query, id).Scan(
@@ -87,22 +192,53 @@ func (v VideoModel) Get(id string) (*Video, error) {
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
 			return nil, ErrRecordNotFound
 		default:
+			span.RecordError(err)
 			return nil, err
 		}
 	}
 
+	span.SetAttributes(tracing.Int("db.row_count", 1))
 	return &video, nil
 }
 
-func (v VideoModel) Update(video *Video) error {
+// Update saves the video if it is still at video.Version. It returns a
+// FieldError if the new length would cut off any of the video's chapters.
+//
+// The chapters are checked after the update has locked the video row, which
+// chapter writes also lock, so a chapter can't be added past the new length
+// in between. An update rejected for its length is rolled back with the
+// transaction it runs in; on a model that isn't in one, Update starts its own.
+func (v VideoModel) Update(ctx context.Context, video *Video) error {
+	if db, ok := v.DB.(*sql.DB); ok {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		err = v.WithTx(tx).Update(ctx, video)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+
 	query := `
 	UPDATE videos
 	SET title = $1, description = $2, type = $3, length = $4, language = $5, published_at = $6, version = version + 1
 	WHERE video_id = $8 AND version = $7
 	RETURNING version`
 
+	// A chapter needs the video to last past its start, and to its end.
+	chaptersQuery := `
+	SELECT coalesce(max(greatest(start_time + 1, end_time)), 0)
+	FROM video_chapters
+	WHERE video_id = $1`
+
 	args := []any{
 		video.Title,
 		video.Description,
@@ -114,23 +250,45 @@ func (v VideoModel) Update(video *Video) error {
 		video.VideoID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, v.Tracer, "VideoModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := v.DB.QueryRowContext(ctx, query, args...).Scan(&video.Version)
+	var version int
+
+	err := v.DB.QueryRowContext(ctx, query, args...).Scan(&version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			span.SetAttributes(tracing.Int("db.row_count", 0))
 			return ErrEditConflict
 		default:
-			return err
+			span.RecordError(err)
+			return translateVideoError(err)
 		}
 	}
 
+	var minLength int
+
+	err = v.DB.QueryRowContext(ctx, chaptersQuery, video.VideoID).Scan(&minLength)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if video.Length < minLength {
+		return &FieldError{Field: "length", Message: fmt.Sprintf("must not cut off any chapter (at least %d seconds)", minLength)}
+	}
+
+	video.Version = version
+
+	span.SetAttributes(tracing.Int("db.row_count", 1))
 	return nil
 }
 
-func (v VideoModel) Delete(id string) error {
+func (v VideoModel) Delete(ctx context.Context, id string) error {
 	if id == "" {
 		return ErrRecordNotFound
 	}
@@ -139,19 +297,26 @@ func (v VideoModel) Delete(id string) error {
 		DELETE FROM videos
 		WHERE video_id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, v.Tracer, "VideoModel.Delete", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := v.DB.ExecContext(ctx, query, id)
 	if err != nil {
+		span.RecordError(err)
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
+		span.RecordError(err)
 		return err
 	}
 
+	span.SetAttributes(tracing.Int64("db.row_count", rowsAffected))
+
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
@@ -159,22 +324,32 @@ func (v VideoModel) Delete(id string) error {
 	return nil
 }
 
-func (v VideoModel) GetAll(title string, description string, filters Filters) ([]*Video, Metadata, error) {
+// GetAll lists videos matching the title and description searches, and the
+// transcript search over their caption tracks. Empty searches match anything.
+func (v VideoModel) GetAll(ctx context.Context, title string, description string, transcript string, filters Filters) ([]*Video, Metadata, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(),  video_id, title, description, type, length, language, published_at, created_at, version
 		FROM videos
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (to_tsvector('simple', description) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND ($3 = '' OR EXISTS (
+			SELECT 1 FROM video_captions c
+			WHERE c.video_id = videos.video_id
+			AND to_tsvector('simple', c.plain_text) @@ plainto_tsquery('simple', $3)))
 		ORDER BY %s %s
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, v.Tracer, "VideoModel.GetAll", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	args := []any{title, description, filters.limit(), filters.offset()}
+	args := []any{title, description, transcript, filters.limit(), filters.offset()}
 
 	rows, err := v.DB.QueryContext(ctx, query, args...)
 	if err != nil {
+		span.RecordError(err)
 		return nil, Metadata{}, err
 	}
 
@@ -199,6 +374,7 @@ func (v VideoModel) GetAll(title string, description string, filters Filters) ([
 			&video.Version,
 		)
 		if err != nil {
+			span.RecordError(err)
 			return nil, Metadata{}, err
 		}
 
@@ -206,9 +382,50 @@ func (v VideoModel) GetAll(title string, description string, filters Filters) ([
 	}
 
 	if err = rows.Err(); err != nil {
+		span.RecordError(err)
 		return nil, Metadata{}, err
 	}
 
+	span.SetAttributes(tracing.Int("db.row_count", len(videos)), tracing.Int("db.total_records", totalRecords))
+
 	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 	return videos, metadata, nil
 }
+
+// CheckTypes returns an error if VideoTypes doesn't list exactly the values of
+// the video_type enum in the database.
+func (v VideoModel) CheckTypes(ctx context.Context) error {
+	query := `SELECT unnest(enum_range(NULL::video_type))::text`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := v.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var types []string
+
+	for rows.Next() {
+		var t string
+
+		err := rows.Scan(&t)
+		if err != nil {
+			return err
+		}
+
+		types = append(types, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if !slices.Equal(slices.Sorted(slices.Values(types)), slices.Sorted(slices.Values(VideoTypes))) {
+		return fmt.Errorf("video_type enum in the database is %v, but the application expects %v", types, VideoTypes)
+	}
+
+	return nil
+}
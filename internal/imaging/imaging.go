@@ -0,0 +1,169 @@
+// Package imaging resizes images using only the standard library.
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a size written as "WIDTHxHEIGHT", e.g. "1280x720".
+func ParseSize(s string) (image.Point, error) {
+	ws, hs, ok := strings.Cut(s, "x")
+	if !ok {
+		return image.Point{}, fmt.Errorf("invalid image size %q: must be WIDTHxHEIGHT", s)
+	}
+
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if err1 != nil || err2 != nil || w < 1 || h < 1 {
+		return image.Point{}, fmt.Errorf("invalid image size %q: must be WIDTHxHEIGHT", s)
+	}
+
+	return image.Pt(w, h), nil
+}
+
+// Covers reports whether an image of size src is large enough to produce an
+// image of size dst with Fill without upscaling.
+func Covers(src, dst image.Point) bool {
+	return src.X >= dst.X && src.Y >= dst.Y
+}
+
+// Fill scales and crops src to exactly size, keeping the centre of the image
+// when the aspect ratios differ. Transparent areas are flattened onto white,
+// so the result can be encoded as JPEG.
+//
+// Downscaling uses area averaging: each destination pixel is the mean of the
+// source pixels it covers, weighted by how much of each it covers.
+func Fill(src image.Image, size image.Point) *image.RGBA {
+	bounds := src.Bounds()
+	crop := centreCrop(bounds, size)
+
+	// Working on an RGBA copy of the crop lets the resampler read Pix
+	// directly; draw.Draw has fast paths for the common decoded formats.
+	rgba := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, crop.Min, draw.Over)
+
+	return resample(rgba, size)
+}
+
+// centreCrop returns the largest rectangle with the aspect ratio of size
+// centred in bounds.
+func centreCrop(bounds image.Rectangle, size image.Point) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Compare w/h with size.X/size.Y without floating point.
+	switch {
+	case w*size.Y > h*size.X:
+		cw := h * size.X / size.Y
+		x := bounds.Min.X + (w-cw)/2
+		return image.Rect(x, bounds.Min.Y, x+cw, bounds.Max.Y)
+	case w*size.Y < h*size.X:
+		ch := w * size.Y / size.X
+		y := bounds.Min.Y + (h-ch)/2
+		return image.Rect(bounds.Min.X, y, bounds.Max.X, y+ch)
+	default:
+		return bounds
+	}
+}
+
+// span lists the source pixels contributing to one destination pixel along an
+// axis, starting at start, with their normalised weights.
+type span struct {
+	start   int
+	weights []float32
+}
+
+// boxSpans computes the area-averaging weights for scaling an axis of srcLen
+// pixels to dstLen pixels.
+func boxSpans(srcLen, dstLen int) []span {
+	scale := float64(srcLen) / float64(dstLen)
+	spans := make([]span, dstLen)
+
+	for i := range spans {
+		lo := float64(i) * scale
+		hi := math.Min(float64(i+1)*scale, float64(srcLen))
+
+		start := int(lo)
+		end := min(int(math.Ceil(hi)), srcLen)
+
+		weights := make([]float32, end-start)
+		var total float64
+
+		for j := start; j < end; j++ {
+			overlap := math.Min(hi, float64(j+1)) - math.Max(lo, float64(j))
+			weights[j-start] = float32(overlap)
+			total += overlap
+		}
+
+		for j := range weights {
+			weights[j] /= float32(total)
+		}
+
+		spans[i] = span{start: start, weights: weights}
+	}
+
+	return spans
+}
+
+// resample scales src to size. For each destination row it first blends the
+// contributing source rows, then blends across each destination column, so
+// only one row of intermediate values is held in memory.
+func resample(src *image.RGBA, size image.Point) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+
+	xSpans := boxSpans(sw, size.X)
+	ySpans := boxSpans(sh, size.Y)
+
+	row := make([]float32, sw*4)
+
+	for y, ys := range ySpans {
+		clear(row)
+
+		for k, wy := range ys.weights {
+			srcRow := src.Pix[(ys.start+k)*src.Stride:]
+			for i := range row {
+				row[i] += float32(srcRow[i]) * wy
+			}
+		}
+
+		dstRow := dst.Pix[y*dst.Stride:]
+
+		for x, xs := range xSpans {
+			var r, g, b, a float32
+
+			for k, wx := range xs.weights {
+				p := row[(xs.start+k)*4:]
+				r += p[0] * wx
+				g += p[1] * wx
+				b += p[2] * wx
+				a += p[3] * wx
+			}
+
+			d := dstRow[x*4:]
+			d[0] = clamp(r)
+			d[1] = clamp(g)
+			d[2] = clamp(b)
+			d[3] = clamp(a)
+		}
+	}
+
+	return dst
+}
+
+func clamp(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	default:
+		return uint8(v + 0.5)
+	}
+}
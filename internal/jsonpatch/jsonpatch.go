@@ -0,0 +1,442 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to values decoded by encoding/json into any.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrTestFailed = errors.New("test operation failed")
+	ErrPath       = errors.New("invalid path")
+)
+
+// Merge applies an RFC 7396 merge patch to doc and returns the result. doc is
+// not modified.
+func Merge(doc, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	docObj, ok := doc.(map[string]any)
+	if !ok {
+		docObj = map[string]any{}
+	}
+
+	result := make(map[string]any, len(docObj))
+	for k, v := range docObj {
+		result[k] = v
+	}
+
+	for k, v := range patchObj {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+
+		result[k] = Merge(result[k], v)
+	}
+
+	return result
+}
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// UnmarshalJSON ignores members other than op, path, from and value, as
+// RFC 6902 requires, even when the caller's decoder disallows unknown fields.
+// A present but null value is kept as the literal null.
+func (o *Operation) UnmarshalJSON(b []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil {
+		return err
+	}
+
+	for name, dst := range map[string]*string{"op": &o.Op, "path": &o.Path, "from": &o.From} {
+		if raw, ok := members[name]; ok {
+			if err := json.Unmarshal(raw, dst); err != nil {
+				return fmt.Errorf("%s must be a string", name)
+			}
+		}
+	}
+
+	if raw, ok := members["value"]; ok {
+		o.Value = raw
+	}
+
+	return nil
+}
+
+// Apply applies an RFC 6902 patch to doc. Operations are applied in order and
+// the first failure aborts the whole patch; doc itself is never modified.
+func Apply(doc any, ops []Operation) (any, error) {
+	doc = deepCopy(doc)
+
+	for i, op := range ops {
+		var err error
+
+		switch op.Op {
+		case "add", "replace", "test":
+			var value any
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d (%s): missing value", i, op.Op)
+			}
+
+			dec := json.NewDecoder(strings.NewReader(string(op.Value)))
+			dec.UseNumber()
+			if err := dec.Decode(&value); err != nil {
+				return nil, fmt.Errorf("operation %d (%s): invalid value: %w", i, op.Op, err)
+			}
+
+			switch op.Op {
+			case "add":
+				doc, err = add(doc, op.Path, value)
+			case "replace":
+				doc, err = replace(doc, op.Path, value)
+			case "test":
+				err = test(doc, op.Path, value)
+			}
+
+		case "remove":
+			doc, _, err = remove(doc, op.Path)
+
+		case "move":
+			if op.From != op.Path && strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("operation %d (move): cannot move a value into one of its children: %w", i, ErrPath)
+			}
+
+			var value any
+			doc, value, err = remove(doc, op.From)
+			if err == nil {
+				doc, err = add(doc, op.Path, value)
+			}
+
+		case "copy":
+			var value any
+			value, err = get(doc, op.From)
+			if err == nil {
+				doc, err = add(doc, op.Path, deepCopy(value))
+			}
+
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrPath
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrPath
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, ErrPath
+	}
+
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i > max {
+		return 0, ErrPath
+	}
+
+	return i, nil
+}
+
+func get(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, token := range tokens {
+		switch c := current.(type) {
+		case map[string]any:
+			v, ok := c[token]
+			if !ok {
+				return nil, ErrPath
+			}
+			current = v
+		case []any:
+			i, err := arrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			current = c[i]
+		default:
+			return nil, ErrPath
+		}
+	}
+
+	return current, nil
+}
+
+// update walks to the parent of the location named by pointer and calls fn
+// to produce the parent's new value, rebuilding the path back to the root.
+func update(doc any, tokens []string, fn func(parent any, last string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	switch c := doc.(type) {
+	case map[string]any:
+		child, ok := c[tokens[0]]
+		if !ok {
+			return nil, ErrPath
+		}
+
+		newChild, err := update(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		c[tokens[0]] = newChild
+		return c, nil
+
+	case []any:
+		i, err := arrayIndex(tokens[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+
+		newChild, err := update(c[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		c[i] = newChild
+		return c, nil
+	}
+
+	return nil, ErrPath
+}
+
+func add(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return update(doc, tokens, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[last] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(last, len(p), true)
+			if err != nil {
+				return nil, err
+			}
+
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+
+		return nil, ErrPath
+	})
+}
+
+func remove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil, ErrPath
+	}
+
+	var removed any
+
+	doc, err = update(doc, tokens, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			v, ok := p[last]
+			if !ok {
+				return nil, ErrPath
+			}
+
+			removed = v
+			delete(p, last)
+			return p, nil
+		case []any:
+			i, err := arrayIndex(last, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		}
+
+		return nil, ErrPath
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return doc, removed, nil
+}
+
+func replace(doc any, pointer string, value any) (any, error) {
+	if _, err := get(doc, pointer); err != nil {
+		return nil, err
+	}
+
+	tokens, _ := parsePointer(pointer)
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return update(doc, tokens, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[last] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(last, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+
+			p[i] = value
+			return p, nil
+		}
+
+		return nil, ErrPath
+	})
+}
+
+func test(doc any, pointer string, value any) error {
+	current, err := get(doc, pointer)
+	if err != nil {
+		return err
+	}
+
+	if !Equal(current, value) {
+		return ErrTestFailed
+	}
+
+	return nil
+}
+
+// Equal compares two decoded JSON values, treating numbers as equal when
+// they represent the same value regardless of how they were written.
+func Equal(a, b any) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			if f, ok := b.(float64); ok {
+				af, err := av.Float64()
+				return err == nil && af == f
+			}
+			return false
+		}
+
+		af, errA := av.Float64()
+		bf, errB := bv.Float64()
+		return errA == nil && errB == nil && af == bf
+
+	case float64:
+		if _, ok := b.(json.Number); ok {
+			return Equal(b, a)
+		}
+		return reflect.DeepEqual(a, b)
+
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !Equal(v, w) {
+				return false
+			}
+		}
+		return true
+
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+
+		for i := range av {
+			if !Equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func deepCopy(v any) any {
+	switch c := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(c))
+		for k, v := range c {
+			m[k] = deepCopy(v)
+		}
+		return m
+	case []any:
+		s := make([]any, len(c))
+		for i, v := range c {
+			s[i] = deepCopy(v)
+		}
+		return s
+	}
+
+	return v
+}
@@ -0,0 +1,203 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// decode decodes a JSON document the way callers of this package do.
+func decode(t *testing.T, s string) any {
+	t.Helper()
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+
+	return v
+}
+
+func decodeOps(t *testing.T, s string) []Operation {
+	t.Helper()
+
+	var ops []Operation
+	if err := json.Unmarshal([]byte(s), &ops); err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+
+	return ops
+}
+
+func TestApply(t *testing.T) {
+	const doc = `{"title":"Episode 1","tags":["a","b","c"],"meta":{"length":600,"lang":"ar"}}`
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"test succeeds", `[{"op":"test","path":"/title","value":"Episode 1"},{"op":"replace","path":"/title","value":"Episode 2"}]`,
+			`{"title":"Episode 2","tags":["a","b","c"],"meta":{"length":600,"lang":"ar"}}`},
+		{"test compares numbers by value", `[{"op":"test","path":"/meta/length","value":6e2}]`, doc},
+		{"test compares objects", `[{"op":"test","path":"/meta","value":{"lang":"ar","length":600}}]`, doc},
+		{"add to the end of an array", `[{"op":"add","path":"/tags/-","value":"d"}]`,
+			`{"title":"Episode 1","tags":["a","b","c","d"],"meta":{"length":600,"lang":"ar"}}`},
+		{"add at an array index", `[{"op":"add","path":"/tags/0","value":"z"}]`,
+			`{"title":"Episode 1","tags":["z","a","b","c"],"meta":{"length":600,"lang":"ar"}}`},
+		{"add just past the last element", `[{"op":"add","path":"/tags/3","value":"d"}]`,
+			`{"title":"Episode 1","tags":["a","b","c","d"],"meta":{"length":600,"lang":"ar"}}`},
+		{"remove from an array", `[{"op":"remove","path":"/tags/1"}]`,
+			`{"title":"Episode 1","tags":["a","c"],"meta":{"length":600,"lang":"ar"}}`},
+		{"remove a member", `[{"op":"remove","path":"/meta/lang"}]`,
+			`{"title":"Episode 1","tags":["a","b","c"],"meta":{"length":600}}`},
+		{"move a member", `[{"op":"move","from":"/meta/lang","path":"/lang"}]`,
+			`{"title":"Episode 1","lang":"ar","tags":["a","b","c"],"meta":{"length":600}}`},
+		{"move to itself", `[{"op":"move","from":"/meta","path":"/meta"}]`, doc},
+		{"move to a sibling with a common prefix", `[{"op":"move","from":"/meta","path":"/metadata"}]`,
+			`{"title":"Episode 1","tags":["a","b","c"],"metadata":{"length":600,"lang":"ar"}}`},
+		{"copy", `[{"op":"copy","from":"/tags/0","path":"/first"}]`,
+			`{"title":"Episode 1","first":"a","tags":["a","b","c"],"meta":{"length":600,"lang":"ar"}}`},
+		{"escaped pointer", `[{"op":"add","path":"/a~1b~0c","value":1}]`,
+			`{"title":"Episode 1","a/b~c":1,"tags":["a","b","c"],"meta":{"length":600,"lang":"ar"}}`},
+		{"replace the whole document", `[{"op":"replace","path":"","value":{"title":"New"}}]`, `{"title":"New"}`},
+		{"unknown members are ignored", `[{"op":"remove","path":"/meta/lang","comment":"drop it"}]`,
+			`{"title":"Episode 1","tags":["a","b","c"],"meta":{"length":600}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := decode(t, doc)
+
+			got, err := Apply(original, decodeOps(t, tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !Equal(got, decode(t, tt.want)) {
+				t.Errorf("got %v; want %s", got, tt.want)
+			}
+
+			if !Equal(original, decode(t, doc)) {
+				t.Errorf("the original document was modified: %v", original)
+			}
+		})
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	const doc = `{"title":"Episode 1","tags":["a","b","c"],"meta":{"length":600}}`
+
+	tests := []struct {
+		name  string
+		patch string
+		err   error
+	}{
+		{"test fails", `[{"op":"test","path":"/title","value":"Episode 2"}]`, ErrTestFailed},
+		{"test of a different type", `[{"op":"test","path":"/meta/length","value":"600"}]`, ErrTestFailed},
+		{"test of a missing path", `[{"op":"test","path":"/missing","value":null}]`, ErrPath},
+		{"move into its own child", `[{"op":"move","from":"/meta","path":"/meta/inner"}]`, ErrPath},
+		{"remove a missing member", `[{"op":"remove","path":"/missing"}]`, ErrPath},
+		{"remove below a missing member", `[{"op":"remove","path":"/missing/child"}]`, ErrPath},
+		{"remove past the end of an array", `[{"op":"remove","path":"/tags/3"}]`, ErrPath},
+		{"remove the end of an array", `[{"op":"remove","path":"/tags/-"}]`, ErrPath},
+		{"remove the whole document", `[{"op":"remove","path":""}]`, ErrPath},
+		{"add beyond the end of an array", `[{"op":"add","path":"/tags/4","value":"e"}]`, ErrPath},
+		{"negative array index", `[{"op":"add","path":"/tags/-1","value":"e"}]`, ErrPath},
+		{"array index with a leading zero", `[{"op":"replace","path":"/tags/01","value":"e"}]`, ErrPath},
+		{"replace past the end of an array", `[{"op":"replace","path":"/tags/3","value":"e"}]`, ErrPath},
+		{"replace a missing member", `[{"op":"replace","path":"/missing","value":1}]`, ErrPath},
+		{"copy from a missing member", `[{"op":"copy","from":"/missing","path":"/title"}]`, ErrPath},
+		{"pointer without a leading slash", `[{"op":"add","path":"title","value":"x"}]`, ErrPath},
+		{"member of a string", `[{"op":"add","path":"/title/x","value":"x"}]`, ErrPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply(decode(t, doc), decodeOps(t, tt.patch))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v; want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyIsAtomic(t *testing.T) {
+	original := decode(t, `{"title":"Episode 1","length":600}`)
+
+	ops := decodeOps(t, `[{"op":"replace","path":"/title","value":"Episode 2"},{"op":"test","path":"/length","value":601}]`)
+
+	got, err := Apply(original, ops)
+	if !errors.Is(err, ErrTestFailed) || got != nil {
+		t.Fatalf("got %v, %v; want no document and ErrTestFailed", got, err)
+	}
+
+	if !Equal(original, decode(t, `{"title":"Episode 1","length":600}`)) {
+		t.Errorf("the original document was modified: %v", original)
+	}
+}
+
+func TestApplyRejectsMalformedOperations(t *testing.T) {
+	for _, patch := range []string{
+		`[{"op":"add","path":"/title"}]`,
+		`[{"op":"replace","path":"/title"}]`,
+		`[{"op":"test","path":"/title"}]`,
+		`[{"op":"frobnicate","path":"/title"}]`,
+		`[{"path":"/title","value":"x"}]`,
+	} {
+		_, err := Apply(decode(t, `{"title":"Episode 1"}`), decodeOps(t, patch))
+		if err == nil {
+			t.Errorf("%s: got no error", patch)
+		}
+	}
+
+	// A present but null value is a value.
+	got, err := Apply(decode(t, `{"title":"Episode 1"}`), decodeOps(t, `[{"op":"add","path":"/note","value":null}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(got, decode(t, `{"title":"Episode 1","note":null}`)) {
+		t.Errorf("got %v; want note set to null", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace a member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add a member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null deletes", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"null deletes a missing member", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"empty string is kept", `{"a":"b"}`, `{"a":""}`, `{"a":""}`},
+		{"arrays are replaced", `{"a":["b","c"]}`, `{"a":["d"]}`, `{"a":["d"]}`},
+		{"nested objects merge", `{"a":{"b":"c","d":"e"}}`, `{"a":{"b":"x","d":null,"f":"g"}}`, `{"a":{"b":"x","f":"g"}}`},
+		{"object replaces a scalar", `{"a":"b"}`, `{"a":{"c":"d","e":null}}`, `{"a":{"c":"d"}}`},
+		{"scalar replaces an object", `{"a":{"b":"c"}}`, `{"a":1}`, `{"a":1}`},
+		{"non-object patch replaces the document", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"empty patch changes nothing", `{"a":{"b":"c"}}`, `{}`, `{"a":{"b":"c"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+
+			got := Merge(doc, decode(t, tt.patch))
+
+			if !Equal(got, decode(t, tt.want)) {
+				t.Errorf("got %v; want %s", got, tt.want)
+			}
+
+			if !Equal(doc, decode(t, tt.doc)) {
+				t.Errorf("the original document was modified: %v", doc)
+			}
+		})
+	}
+}
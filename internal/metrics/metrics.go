@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type Labels map[string]string
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// exposition format (version 0.0.4).
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, c := range collectors {
+		c.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterValue),
+	}
+
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labelValues: slices.Clone(labelValues)}
+		c.values[key] = v
+	}
+
+	v.value += delta
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		writeSample(w, c.name, c.labels, v.labelValues, "", "", v.value)
+	}
+}
+
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{
+			labelValues: slices.Clone(labelValues),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = v
+	}
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			v.counts[i]++
+		}
+	}
+
+	v.sum += value
+	v.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+
+		for i, upper := range h.buckets {
+			writeSample(w, h.name+"_bucket", h.labels, v.labelValues, "le", formatFloat(upper), float64(v.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, v.labelValues, "le", "+Inf", float64(v.count))
+		writeSample(w, h.name+"_sum", h.labels, v.labelValues, "", "", v.sum)
+		writeSample(w, h.name+"_count", h.labels, v.labelValues, "", "", float64(v.count))
+	}
+}
+
+// ValueFunc is a gauge or counter whose value is computed by calling fn each
+// time the registry is written out. It suits values that are already tracked
+// elsewhere, such as sql.DBStats.
+type ValueFunc struct {
+	name   string
+	help   string
+	kind   string
+	labels Labels
+	fn     func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, labels Labels, fn func() float64) *ValueFunc {
+	return r.newValueFunc(name, help, "gauge", labels, fn)
+}
+
+func (r *Registry) NewCounterFunc(name, help string, labels Labels, fn func() float64) *ValueFunc {
+	return r.newValueFunc(name, help, "counter", labels, fn)
+}
+
+func (r *Registry) newValueFunc(name, help, kind string, labels Labels, fn func() float64) *ValueFunc {
+	f := &ValueFunc{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		fn:     fn,
+	}
+
+	r.register(f)
+	return f
+}
+
+func (f *ValueFunc) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+
+	names := make([]string, 0, len(f.labels))
+	for name := range f.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = f.labels[name]
+	}
+
+	writeSample(w, f.name, names, values, "", "", f.fn())
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+
+		sep := ""
+		for i, label := range labels {
+			fmt.Fprintf(w, "%s%s=\"%s\"", sep, label, escapeLabelValue(labelValues[i]))
+			sep = ","
+		}
+
+		if extraLabel != "" {
+			fmt.Fprintf(w, "%s%s=\"%s\"", sep, extraLabel, escapeLabelValue(extraValue))
+		}
+
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+func labelKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,56 @@
+package probe
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// Fake is a Prober that doesn't decode its input. It returns a copy of
+// Metadata for every file that exists, or one hour of 1080p H.264 video with
+// AAC audio if Metadata is nil.
+type Fake struct {
+	Metadata *Metadata
+	// Err, if set, is returned by Probe instead of metadata.
+	Err error
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func (f *Fake) Probe(ctx context.Context, path string) (*Metadata, error) {
+	f.mu.Lock()
+	f.paths = append(f.paths, path)
+	f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Metadata != nil {
+		md := *f.Metadata
+		return &md, nil
+	}
+
+	return &Metadata{
+		Duration:   time.Hour,
+		Width:      1920,
+		Height:     1080,
+		VideoCodec: "h264",
+		AudioCodec: "aac",
+	}, nil
+}
+
+// Paths returns the paths Probe has been called with.
+func (f *Fake) Paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.paths...)
+}
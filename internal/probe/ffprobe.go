@@ -0,0 +1,131 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stderrTail is how much of ffprobe's output is kept for error messages.
+const stderrTail = 2048
+
+// FFprobe probes by running the ffprobe binary.
+type FFprobe struct {
+	// Path is the ffprobe executable; "ffprobe" (looked up in PATH) if empty.
+	Path string
+}
+
+// ffprobeOutput is the subset of ffprobe's JSON output that Probe reads.
+type ffprobeOutput struct {
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		Duration  string `json:"duration"`
+		Tags      struct {
+			Language string `json:"language"`
+		} `json:"tags"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+func (f *FFprobe) Probe(ctx context.Context, path string) (*Metadata, error) {
+	bin := f.Path
+	if bin == "" {
+		bin = "ffprobe"
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, bin, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > stderrTail {
+			output = "..." + output[len(output)-stderrTail:]
+		}
+
+		return nil, fmt.Errorf("probe: %w: %s", err, output)
+	}
+
+	var out ffprobeOutput
+
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return nil, fmt.Errorf("probe: decoding ffprobe output: %w", err)
+	}
+
+	return out.metadata()
+}
+
+func (out *ffprobeOutput) metadata() (*Metadata, error) {
+	var md Metadata
+
+	duration := out.Format.Duration
+
+	for _, stream := range out.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Cover art embedded in audio files shows up as a
+			// single-frame video stream.
+			if md.VideoCodec != "" || stream.Disposition.AttachedPic != 0 {
+				continue
+			}
+
+			md.VideoCodec = stream.CodecName
+			md.Width = stream.Width
+			md.Height = stream.Height
+
+		case "audio":
+			if md.AudioCodec != "" {
+				continue
+			}
+
+			md.AudioCodec = stream.CodecName
+
+			// ffprobe reports "und" for undetermined.
+			if lang := stream.Tags.Language; lang != "und" {
+				md.AudioLanguage = lang
+			}
+		}
+
+		if duration == "" {
+			duration = stream.Duration
+		}
+	}
+
+	if md.VideoCodec == "" && md.AudioCodec == "" {
+		return nil, errors.New("probe: no audio or video stream")
+	}
+
+	if duration != "" {
+		seconds, err := strconv.ParseFloat(duration, 64)
+		if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+			return nil, fmt.Errorf("probe: invalid duration %q", duration)
+		}
+
+		md.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	return &md, nil
+}
@@ -0,0 +1,30 @@
+// Package probe reads technical metadata, such as duration, resolution and
+// codecs, from media files. The Prober interface has an implementation that
+// runs ffprobe and a fake one for tests and local development.
+package probe
+
+import (
+	"context"
+	"time"
+)
+
+// Metadata describes a media file. Fields the file has no stream for, such as
+// the resolution of an audio file, are zero.
+type Metadata struct {
+	Duration time.Duration
+	// Width and Height are of the first video stream.
+	Width  int
+	Height int
+	// VideoCodec and AudioCodec are codec names as ffprobe reports them,
+	// e.g. "h264" and "aac".
+	VideoCodec string
+	AudioCodec string
+	// AudioLanguage is the language tag of the first audio stream, e.g.
+	// "ara" or "eng", if the file declares one.
+	AudioLanguage string
+}
+
+type Prober interface {
+	// Probe returns the metadata of the media file at path.
+	Probe(ctx context.Context, path string) (*Metadata, error)
+}
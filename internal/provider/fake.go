@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Fake is a MetadataProvider that serves videos from memory.
+type Fake struct {
+	// Catalog holds the videos the fake knows, by ID.
+	Catalog map[string]*Video
+	// Channels lists the uploads of each channel the fake knows, by
+	// channel ID.
+	Channels map[string][]string
+	// PageSize is the number of uploads per page; 50 if zero.
+	PageSize int
+	// Err, if set, is returned by every call instead of videos.
+	Err error
+
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func (f *Fake) Videos(ctx context.Context, ids []string) ([]*Video, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, append([]string(nil), ids...))
+	f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	var videos []*Video
+
+	for _, id := range ids {
+		if video, ok := f.Catalog[id]; ok {
+			v := *video
+			videos = append(videos, &v)
+		}
+	}
+
+	return videos, nil
+}
+
+func (f *Fake) ChannelUploads(ctx context.Context, channelID, pageToken string) ([]string, string, error) {
+	if f.Err != nil {
+		return nil, "", f.Err
+	}
+
+	uploads, ok := f.Channels[channelID]
+	if !ok {
+		return nil, "", fmt.Errorf("provider: fake channel %q not found", channelID)
+	}
+
+	// Page tokens are offsets into the uploads.
+	start := 0
+	if pageToken != "" {
+		var err error
+
+		start, err = strconv.Atoi(pageToken)
+		if err != nil || start < 0 || start > len(uploads) {
+			return nil, "", fmt.Errorf("provider: invalid page token %q", pageToken)
+		}
+	}
+
+	end := min(start+cmp.Or(f.PageSize, 50), len(uploads))
+
+	next := ""
+	if end < len(uploads) {
+		next = strconv.Itoa(end)
+	}
+
+	return append([]string(nil), uploads[start:end]...), next, nil
+}
+
+// Requests returns the IDs Videos has been called with, one slice per call.
+func (f *Fake) Requests() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]string(nil), f.requests...)
+}
@@ -0,0 +1,75 @@
+// Package provider fetches video metadata from external platforms, so that
+// videos published there can be imported into the catalogue. The
+// MetadataProvider interface has an implementation for the YouTube Data API
+// and a fake one for tests and local development.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Video is the metadata of a video on an external platform.
+type Video struct {
+	// ID is the platform's ID of the video, which the catalogue uses as
+	// its video_id.
+	ID          string
+	Title       string
+	Description string
+	Duration    time.Duration
+	// Language is the BCP 47 tag of the spoken language, e.g. "ar" or
+	// "en-US", or "" if the platform doesn't declare one.
+	Language    string
+	PublishedAt time.Time
+}
+
+type MetadataProvider interface {
+	// Videos returns the videos with the given IDs, in the same order. IDs
+	// the platform doesn't know, or won't show, are left out.
+	Videos(ctx context.Context, ids []string) ([]*Video, error)
+
+	// ChannelUploads returns a page of the IDs of the videos uploaded to a
+	// channel, starting at pageToken ("" for the first page), and the token
+	// of the next page, which is "" after the last one.
+	ChannelUploads(ctx context.Context, channelID, pageToken string) (ids []string, next string, err error)
+}
+
+// durationRX matches the ISO 8601 durations used by video platforms, such as
+// PT1H2M3S or P1DT2H. Years and months are rejected, as their length varies.
+var durationRX = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses an ISO 8601 duration of weeks, days, hours, minutes
+// and seconds.
+func ParseDuration(s string) (time.Duration, error) {
+	m := durationRX.FindStringSubmatch(s)
+	if m == nil || s == "P" || s[len(s)-1] == 'T' {
+		return 0, fmt.Errorf("provider: invalid ISO 8601 duration %q", s)
+	}
+
+	units := []float64{7 * 24 * 3600, 24 * 3600, 3600, 60, 1}
+
+	var seconds float64
+
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("provider: invalid ISO 8601 duration %q", s)
+		}
+
+		seconds += n * unit
+	}
+
+	if seconds*float64(time.Second) >= math.MaxInt64 {
+		return 0, fmt.Errorf("provider: ISO 8601 duration %q is too long", s)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
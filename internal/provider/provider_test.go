@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"PT1H2M3S", time.Hour + 2*time.Minute + 3*time.Second},
+		{"P1DT2H", 26 * time.Hour},
+		{"P0D", 0},
+		{"PT0S", 0},
+		{"PT45S", 45 * time.Second},
+		{"PT1.5S", 1500 * time.Millisecond},
+		{"P1W", 7 * 24 * time.Hour},
+		{"PT90M", 90 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.s)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %v", tt.s, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v; want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseDurationRejects(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"P",
+		"PT",
+		"P1DT",
+		// Years and months vary in length.
+		"P1Y",
+		"P1M",
+		"P1Y2M",
+		"1H",
+		"PT1H2M3",
+		"PT-1S",
+		"P9999999999999W",
+	} {
+		_, err := ParseDuration(s)
+		if err == nil {
+			t.Errorf("ParseDuration(%q) succeeded; want an error", s)
+		}
+	}
+}
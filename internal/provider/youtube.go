@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// youtubeMaxResults is the most IDs the YouTube Data API accepts in one
+// videos.list request.
+const youtubeMaxResults = 50
+
+// YouTube fetches metadata from the YouTube Data API v3.
+type YouTube struct {
+	APIKey string
+	// BaseURL is the root of the API, "https://www.googleapis.com/youtube/v3"
+	// if empty. Tests point it at a stand-in server.
+	BaseURL string
+	// Client sends the requests; one with a 10 second timeout if nil.
+	Client *http.Client
+
+	mu sync.Mutex
+	// uploads caches the ID of the uploads playlist of each channel.
+	uploads map[string]string
+}
+
+// youtubeVideo is the subset of a videos.list item that Videos reads.
+type youtubeVideo struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title                string    `json:"title"`
+		Description          string    `json:"description"`
+		PublishedAt          time.Time `json:"publishedAt"`
+		DefaultLanguage      string    `json:"defaultLanguage"`
+		DefaultAudioLanguage string    `json:"defaultAudioLanguage"`
+	} `json:"snippet"`
+	ContentDetails struct {
+		Duration string `json:"duration"`
+	} `json:"contentDetails"`
+}
+
+func (y *YouTube) Videos(ctx context.Context, ids []string) ([]*Video, error) {
+	byID := make(map[string]*Video, len(ids))
+
+	for start := 0; start < len(ids); start += youtubeMaxResults {
+		batch := ids[start:min(start+youtubeMaxResults, len(ids))]
+
+		query := url.Values{
+			"part":       {"snippet,contentDetails"},
+			"id":         {strings.Join(batch, ",")},
+			"maxResults": {fmt.Sprint(youtubeMaxResults)},
+		}
+
+		var out struct {
+			Items []youtubeVideo `json:"items"`
+		}
+
+		err := y.get(ctx, "videos", query, &out)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range out.Items {
+			video, err := item.video()
+			if err != nil {
+				return nil, err
+			}
+
+			byID[video.ID] = video
+		}
+	}
+
+	var videos []*Video
+
+	for _, id := range ids {
+		if video, ok := byID[id]; ok {
+			videos = append(videos, video)
+		}
+	}
+
+	return videos, nil
+}
+
+func (y *YouTube) ChannelUploads(ctx context.Context, channelID, pageToken string) ([]string, string, error) {
+	playlistID, err := y.uploadsPlaylist(ctx, channelID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := url.Values{
+		"part":       {"contentDetails"},
+		"playlistId": {playlistID},
+		"maxResults": {fmt.Sprint(youtubeMaxResults)},
+	}
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+
+	var out struct {
+		NextPageToken string `json:"nextPageToken"`
+		Items         []struct {
+			ContentDetails struct {
+				VideoID string `json:"videoId"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+
+	err = y.get(ctx, "playlistItems", query, &out)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids := make([]string, len(out.Items))
+	for i, item := range out.Items {
+		ids[i] = item.ContentDetails.VideoID
+	}
+
+	return ids, out.NextPageToken, nil
+}
+
+// uploadsPlaylist returns the ID of the playlist that lists every upload of a
+// channel.
+func (y *YouTube) uploadsPlaylist(ctx context.Context, channelID string) (string, error) {
+	y.mu.Lock()
+	playlistID, ok := y.uploads[channelID]
+	y.mu.Unlock()
+
+	if ok {
+		return playlistID, nil
+	}
+
+	var out struct {
+		Items []struct {
+			ContentDetails struct {
+				RelatedPlaylists struct {
+					Uploads string `json:"uploads"`
+				} `json:"relatedPlaylists"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+
+	err := y.get(ctx, "channels", url.Values{"part": {"contentDetails"}, "id": {channelID}}, &out)
+	if err != nil {
+		return "", err
+	}
+
+	if len(out.Items) == 0 || out.Items[0].ContentDetails.RelatedPlaylists.Uploads == "" {
+		return "", fmt.Errorf("provider: youtube channel %q not found", channelID)
+	}
+
+	playlistID = out.Items[0].ContentDetails.RelatedPlaylists.Uploads
+
+	y.mu.Lock()
+	if y.uploads == nil {
+		y.uploads = make(map[string]string)
+	}
+	y.uploads[channelID] = playlistID
+	y.mu.Unlock()
+
+	return playlistID, nil
+}
+
+func (item youtubeVideo) video() (*Video, error) {
+	video := &Video{
+		ID:          item.ID,
+		Title:       item.Snippet.Title,
+		Description: item.Snippet.Description,
+		Language:    item.Snippet.DefaultAudioLanguage,
+		PublishedAt: item.Snippet.PublishedAt,
+	}
+
+	// The audio language is the spoken one; the default language is that
+	// of the title and description.
+	if video.Language == "" {
+		video.Language = item.Snippet.DefaultLanguage
+	}
+
+	if item.ContentDetails.Duration != "" {
+		duration, err := ParseDuration(item.ContentDetails.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("video %s: %w", item.ID, err)
+		}
+
+		video.Duration = duration
+	}
+
+	return video, nil
+}
+
+// get sends a GET request for resource and decodes the JSON response into
+// dst.
+func (y *YouTube) get(ctx context.Context, resource string, query url.Values, dst any) error {
+	base := y.BaseURL
+	if base == "" {
+		base = "https://www.googleapis.com/youtube/v3"
+	}
+
+	client := y.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	query.Set("key", y.APIKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(base, "/")+"/"+resource+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error includes the URL, and with it the API key.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("provider: youtube %s: %w", resource, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("provider: youtube %s: %w", resource, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		_ = json.Unmarshal(body, &apiErr)
+
+		return fmt.Errorf("provider: youtube %s: %s: %s", resource, resp.Status, apiErr.Error.Message)
+	}
+
+	err = json.Unmarshal(body, dst)
+	if err != nil {
+		return fmt.Errorf("provider: youtube %s: decoding response: %w", resource, err)
+	}
+
+	return nil
+}
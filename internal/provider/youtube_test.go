@@ -0,0 +1,247 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAPIKey = "test-api-key-0123456789"
+
+// fakeYouTube serves videos.list from a set of known videos, returning them
+// in reverse order of the request to check that Videos restores it.
+type fakeYouTube struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (f *fakeYouTube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("key") != testAPIKey {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"message": "API key not valid"}}`)
+		return
+	}
+
+	if r.URL.Path != "/videos" {
+		http.NotFound(w, r)
+		return
+	}
+
+	ids := strings.Split(r.URL.Query().Get("id"), ",")
+
+	f.mu.Lock()
+	f.batches = append(f.batches, ids)
+	f.mu.Unlock()
+
+	var items []map[string]any
+
+	for _, id := range slices.Backward(ids) {
+		// IDs starting with x stand for private or deleted videos.
+		if strings.HasPrefix(id, "x") {
+			continue
+		}
+
+		items = append(items, map[string]any{
+			"id": id,
+			"snippet": map[string]any{
+				"title":                "Video " + id,
+				"description":          "About " + id,
+				"publishedAt":          "2024-01-01T00:00:00Z",
+				"defaultLanguage":      "en",
+				"defaultAudioLanguage": "ar",
+			},
+			"contentDetails": map[string]any{"duration": "PT1M30S"},
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func TestYouTubeVideos(t *testing.T) {
+	fake := &fakeYouTube{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	yt := &YouTube{APIKey: testAPIKey, BaseURL: srv.URL}
+
+	var ids, want []string
+	for i := range 120 {
+		id := fmt.Sprintf("v%02d", i)
+		if i%7 == 3 {
+			id = fmt.Sprintf("x%02d", i)
+		} else {
+			want = append(want, id)
+		}
+		ids = append(ids, id)
+	}
+
+	videos, err := yt.Videos(context.Background(), ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, video := range videos {
+		got = append(got, video.ID)
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got videos %v; want %v", got, want)
+	}
+
+	var sizes []int
+	for _, batch := range fake.batches {
+		sizes = append(sizes, len(batch))
+	}
+
+	if !slices.Equal(sizes, []int{50, 50, 20}) {
+		t.Errorf("got batches of %v IDs; want [50 50 20]", sizes)
+	}
+
+	video := videos[0]
+
+	if video.Title != "Video v00" || video.Description != "About v00" {
+		t.Errorf("got title %q and description %q", video.Title, video.Description)
+	}
+	if video.Duration != 90*time.Second {
+		t.Errorf("got duration %v; want 1m30s", video.Duration)
+	}
+	// The audio language wins over the language of the title.
+	if video.Language != "ar" {
+		t.Errorf("got language %q; want ar", video.Language)
+	}
+	if !video.PublishedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got published at %v", video.PublishedAt)
+	}
+}
+
+func TestYouTubeVideosErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "api error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"error": {"code": 403, "message": "The request cannot be completed because you have exceeded your quota."}}`)
+			},
+			want: "provider: youtube videos: 403 Forbidden: The request cannot be completed because you have exceeded your quota.",
+		},
+		{
+			name: "error without a body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			want: "provider: youtube videos: 503 Service Unavailable",
+		},
+		{
+			name: "malformed response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"items": [`)
+			},
+			want: "provider: youtube videos: decoding response",
+		},
+		{
+			name: "invalid duration",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"items": [{"id": "v1", "contentDetails": {"duration": "P1Y"}}]}`)
+			},
+			want: `video v1: provider: invalid ISO 8601 duration "P1Y"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			yt := &YouTube{APIKey: testAPIKey, BaseURL: srv.URL}
+
+			_, err := yt.Videos(context.Background(), []string{"v1"})
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q; want it to contain %q", err, tt.want)
+			}
+			if strings.Contains(err.Error(), testAPIKey) {
+				t.Errorf("error %q contains the API key", err)
+			}
+		})
+	}
+}
+
+func TestYouTubeRequestErrorHidesAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	yt := &YouTube{APIKey: testAPIKey, BaseURL: srv.URL}
+
+	_, err := yt.Videos(context.Background(), []string{"v1"})
+	if err == nil {
+		t.Fatal("got no error from a closed server")
+	}
+
+	if strings.Contains(err.Error(), testAPIKey) {
+		t.Errorf("error %q contains the API key", err)
+	}
+}
+
+func TestYouTubeChannelUploads(t *testing.T) {
+	var channelRequests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		switch r.URL.Path {
+		case "/channels":
+			channelRequests++
+			fmt.Fprintf(w, `{"items": [{"contentDetails": {"relatedPlaylists": {"uploads": "UU%s"}}}]}`, q.Get("id"))
+		case "/playlistItems":
+			if q.Get("playlistId") != "UUchannel" {
+				http.NotFound(w, r)
+				return
+			}
+			if q.Get("pageToken") == "" {
+				fmt.Fprint(w, `{"nextPageToken": "page2", "items": [{"contentDetails": {"videoId": "v1"}}, {"contentDetails": {"videoId": "v2"}}]}`)
+			} else {
+				fmt.Fprint(w, `{"items": [{"contentDetails": {"videoId": "v3"}}]}`)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	yt := &YouTube{APIKey: testAPIKey, BaseURL: srv.URL}
+
+	ids, next, err := yt.ChannelUploads(context.Background(), "channel", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{"v1", "v2"}) || next != "page2" {
+		t.Errorf("got %v, next %q; want [v1 v2], next page2", ids, next)
+	}
+
+	ids, next, err = yt.ChannelUploads(context.Background(), "channel", next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{"v3"}) || next != "" {
+		t.Errorf("got %v, next %q; want [v3], no next page", ids, next)
+	}
+
+	if channelRequests != 1 {
+		t.Errorf("looked up the uploads playlist %d times; want it cached after the first", channelRequests)
+	}
+}
@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Store keeps blobs in a bucket of an S3-compatible object store (AWS S3,
+// MinIO, Ceph RGW and the like). Requests use path-style addressing and are
+// signed with AWS Signature Version 4, so any server speaking that protocol,
+// including a local stand-in, can be used.
+type S3Store struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+	// PartSize is the size of the parts of multipart uploads, which store
+	// blobs larger than one part; 64 MiB if zero. S3 requires at least
+	// 5 MiB, and it is raised as needed to stay within 10,000 parts.
+	PartSize int64
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+const (
+	s3DefaultPartSize = 64 << 20
+	s3MaxParts        = 10_000
+)
+
+func (s *S3Store) objectURL(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+
+	return strings.TrimRight(s.Endpoint, "/") + "/" + uriEncode(s.Bucket) + "/" + strings.Join(segments, "/"), nil
+}
+
+func (s *S3Store) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+
+	return http.DefaultClient
+}
+
+func (s *S3Store) partSize(size int64) int64 {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = s3DefaultPartSize
+	}
+
+	return max(partSize, (size+s3MaxParts-1)/s3MaxParts)
+}
+
+// Put stores a blob with a single PutObject request, or with a multipart
+// upload if it is larger than one part: a single PutObject is limited to
+// 5 GiB.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	if size > s.partSize(size) {
+		return s.putMultipart(ctx, u, r, size, contentType)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, r)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads a blob in parts of partSize bytes, aborting the upload
+// if any step fails so that S3 doesn't keep the parts.
+func (s *S3Store) putMultipart(ctx context.Context, u string, r io.Reader, size int64, contentType string) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u+"?uploads=", nil)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	err = s.doXML(req, &initiated)
+	if err != nil {
+		return err
+	}
+	if initiated.UploadID == "" {
+		return fmt.Errorf("storage: s3 POST %s: no upload ID in response", req.URL.Path)
+	}
+
+	uploadURL := u + "?uploadId=" + url.QueryEscape(initiated.UploadID)
+
+	defer func() {
+		if err != nil {
+			// The upload is aborted even when ctx has been cancelled.
+			abortErr := s.abortMultipart(context.WithoutCancel(ctx), uploadURL)
+			if abortErr != nil {
+				err = errors.Join(err, abortErr)
+			}
+		}
+	}()
+
+	partSize := s.partSize(size)
+
+	var parts []s3CompletedPart
+
+	for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+partSize {
+		length := min(partSize, size-offset)
+
+		partURL := fmt.Sprintf("%s?partNumber=%d&uploadId=%s", u, number, url.QueryEscape(initiated.UploadID))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, partURL, io.LimitReader(r, length))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = length
+
+		resp, err := s.do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return s.responseError(resp)
+		}
+
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	// S3 can report a failed completion with a 200 response whose body is
+	// an Error document.
+	var completed struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+
+	err = s.doXML(req, &completed)
+	if err != nil {
+		return err
+	}
+	if completed.XMLName.Local == "Error" {
+		return fmt.Errorf("storage: s3 POST %s: %s: %s", req.URL.Path, completed.Code, completed.Message)
+	}
+
+	return nil
+}
+
+func (s *S3Store) abortMultipart(ctx context.Context, uploadURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, uploadURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s.responseError(resp)
+	}
+}
+
+// doXML sends req and decodes the XML body of a 200 response into dst.
+func (s *S3Store) doXML(req *http.Request, dst any) error {
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+
+	err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("storage: s3 %s %s: decoding response: %w", req.Method, req.URL.Path, err)
+	}
+
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s.responseError(resp)
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s.responseError(resp)
+	}
+}
+
+func (s *S3Store) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	return s.client().Do(req)
+}
+
+func (s *S3Store) responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("storage: s3 %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sign adds AWS Signature Version 4 headers to req. The payload is left
+// unsigned so request bodies can be streamed.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, unsignedPayload, amzDate)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	// url.Values.Encode sorts by key and uses '+' for spaces, which SigV4
+	// doesn't allow.
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+// uriEncode percent-encodes everything except the RFC 3986 unreserved
+// characters, as SigV4 requires.
+func uriEncode(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
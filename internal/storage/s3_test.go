@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+	testBucket    = "media"
+)
+
+// fakeS3 is an in-memory stand-in for the parts of the S3 API that S3Store
+// uses. It rejects requests whose SigV4 signature doesn't verify.
+type fakeS3 struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	types    map[string]string
+	uploads  map[string]map[int][]byte
+	aborted  []string
+	requests []string
+	// failPart, if set, makes uploading that part number fail.
+	failPart int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Store) {
+	f := &fakeS3{
+		t:       t,
+		objects: make(map[string][]byte),
+		types:   make(map[string]string),
+		uploads: make(map[string]map[int][]byte),
+	}
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	store := &S3Store{
+		Endpoint:  srv.URL,
+		Bucket:    testBucket,
+		Region:    testRegion,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+	}
+
+	return f, store
+}
+
+var authorizationRX = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=([0-9a-f]{64})$`)
+
+// verifySignature recomputes the SigV4 signature of r from what arrived on
+// the wire.
+func verifySignature(r *http.Request) error {
+	m := authorizationRX.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return fmt.Errorf("malformed Authorization header %q", r.Header.Get("Authorization"))
+	}
+
+	accessKey, date, region, signedHeaders, signature := m[1], m[2], m[3], m[4], m[5]
+
+	if accessKey != testAccessKey || region != testRegion {
+		return fmt.Errorf("credential for %s in %s", accessKey, region)
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, date) {
+		return fmt.Errorf("X-Amz-Date %q doesn't match the credential date %s", amzDate, date)
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") == "" {
+		return errors.New("missing X-Amz-Content-Sha256 header")
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	var query []string
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			query = append(query, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	sort.Strings(query)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(query, "&"),
+		headers.String(),
+		signedHeaders,
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + date + "/" + region + "/s3/aws4_request\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request", stringToSign} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(part))
+		key = h.Sum(nil)
+	}
+
+	if want := hex.EncodeToString(key); signature != want {
+		return fmt.Errorf("signature %s; want %s", signature, want)
+	}
+
+	return nil
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := verifySignature(r); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.RawQuery)
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := fmt.Sprintf("upload-%d", len(f.uploads)+1)
+		f.uploads[uploadID] = make(map[int][]byte)
+		f.types[key] = r.Header.Get("Content-Type")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, uploadID)
+
+	case r.Method == http.MethodPut && uploadID != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == f.failPart {
+			http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		f.uploads[uploadID][number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+
+	case r.Method == http.MethodPost && uploadID != "":
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			f.t.Errorf("decoding CompleteMultipartUpload: %v", err)
+		}
+
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>bad part</Message></Error>")
+				return
+			}
+			object = append(object, f.uploads[uploadID][part.PartNumber]...)
+		}
+
+		f.objects[key] = object
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		f.aborted = append(f.aborted, uploadID)
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(object)
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3StorePutGetDelete(t *testing.T) {
+	f, store := newFakeS3(t)
+	ctx := context.Background()
+
+	// The key needs escaping in the path, which the signature covers.
+	key := "videos/abc/poster 1280x720.jpg"
+	body := []byte("not really a jpeg")
+
+	err := store.Put(ctx, key, bytes.NewReader(body), int64(len(body)), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.types[key]; got != "image/jpeg" {
+		t.Errorf("Content-Type %q; want image/jpeg", got)
+	}
+
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+
+	if !bytes.Equal(got, body) {
+		t.Errorf("Get returned %q; want %q", got, body)
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete returned %v; want ErrNotFound", err)
+	}
+
+	// Deleting a missing blob is not an error.
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Errorf("deleting a missing blob: %v", err)
+	}
+}
+
+func TestS3StoreInvalidKey(t *testing.T) {
+	_, store := newFakeS3(t)
+
+	for _, key := range []string{"", "/abs", "a/../b", "a//b"} {
+		_, err := store.Get(context.Background(), key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) returned %v; want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestS3StoreMultipartPut(t *testing.T) {
+	f, store := newFakeS3(t)
+	store.PartSize = 10
+
+	body := []byte("0123456789abcdefghijABCDE")
+
+	err := store.Put(context.Background(), "assets/1/original.mp4", bytes.NewReader(body), int64(len(body)), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.objects["assets/1/original.mp4"]; !bytes.Equal(got, body) {
+		t.Errorf("assembled object %q; want %q", got, body)
+	}
+	if got := f.types["assets/1/original.mp4"]; got != "video/mp4" {
+		t.Errorf("Content-Type %q; want video/mp4", got)
+	}
+
+	want := []string{
+		"POST uploads=",
+		"PUT partNumber=1&uploadId=upload-1",
+		"PUT partNumber=2&uploadId=upload-1",
+		"PUT partNumber=3&uploadId=upload-1",
+		"POST uploadId=upload-1",
+	}
+	if fmt.Sprint(f.requests) != fmt.Sprint(want) {
+		t.Errorf("requests %q; want %q", f.requests, want)
+	}
+}
+
+func TestS3StoreMultipartPutAbortsOnFailure(t *testing.T) {
+	f, store := newFakeS3(t)
+	store.PartSize = 10
+	f.failPart = 2
+
+	body := bytes.Repeat([]byte("x"), 25)
+
+	err := store.Put(context.Background(), "assets/1/original.mp4", bytes.NewReader(body), int64(len(body)), "video/mp4")
+	if err == nil {
+		t.Fatal("Put succeeded; want an error")
+	}
+
+	if _, ok := f.objects["assets/1/original.mp4"]; ok {
+		t.Error("object stored despite the failed part")
+	}
+	if len(f.aborted) != 1 || len(f.uploads) != 0 {
+		t.Errorf("aborted %v, %d uploads left; want the upload aborted", f.aborted, len(f.uploads))
+	}
+}
+
+func TestS3StorePartSize(t *testing.T) {
+	store := &S3Store{}
+
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{1 << 20, s3DefaultPartSize},
+		{10 << 30, s3DefaultPartSize},
+		// 1 TiB in 10,000 parts needs parts larger than the default.
+		{1 << 40, (1<<40 + s3MaxParts - 1) / s3MaxParts},
+	}
+
+	for _, tt := range tests {
+		if got := store.partSize(tt.size); got != tt.want {
+			t.Errorf("partSize(%d) = %d; want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestS3StoreSignsWithRequestTime(t *testing.T) {
+	store := &S3Store{AccessKey: testAccessKey, SecretKey: testSecretKey, Region: testRegion}
+
+	req := httptest.NewRequest(http.MethodGet, "http://s3.example.com/media/a%20b?x=1", nil)
+	store.sign(req, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	if got := req.Header.Get("X-Amz-Date"); got != "20240102T030405Z" {
+		t.Errorf("X-Amz-Date %q", got)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != unsignedPayload {
+		t.Errorf("X-Amz-Content-Sha256 %q", got)
+	}
+
+	req.Host = req.URL.Host
+	if err := verifySignature(req); err != nil {
+		t.Error(err)
+	}
+}
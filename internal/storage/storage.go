@@ -0,0 +1,43 @@
+// Package storage provides a BlobStore abstraction for media files, with
+// implementations backed by the local filesystem and by S3-compatible object
+// storage.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing any existing blob.
+	// size is the exact number of bytes r will yield.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the blob stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey reports whether key is a relative, slash-separated path without
+// empty, "." or ".." segments, so it maps safely onto a filesystem or URL.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+)
+
+type Exporter interface {
+	Export(span *Span)
+}
+
+// JSONExporter writes each finished span as a single-line OTLP/JSON
+// ExportTraceServiceRequest, so the output can be replayed into an OTLP/HTTP
+// collector or inspected offline.
+type JSONExporter struct {
+	mu          sync.Mutex
+	enc         *json.Encoder
+	serviceName string
+	onError     func(error)
+}
+
+func NewJSONExporter(w io.Writer, serviceName string, onError func(error)) *JSONExporter {
+	return &JSONExporter{
+		enc:         json.NewEncoder(w),
+		serviceName: serviceName,
+		onError:     onError,
+	}
+}
+
+func (e *JSONExporter) Export(span *Span) {
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{toKeyValue(String("service.name", e.serviceName))},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/JLL32/thmanyah/internal/tracing"},
+				Spans: []otlpSpan{toOTLPSpan(span)},
+			}},
+		}},
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	err := e.enc.Encode(req)
+	if err != nil && e.onError != nil {
+		e.onError(err)
+	}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func toOTLPSpan(s *Span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           s.spanContext.TraceID.String(),
+		SpanID:            s.spanContext.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.statusCode, Message: s.statusMessage},
+	}
+
+	if s.parent.IsValid() {
+		span.ParentSpanID = s.parent.String()
+	}
+
+	for _, attr := range s.attributes {
+		span.Attributes = append(span.Attributes, toKeyValue(attr))
+	}
+
+	return span
+}
+
+func toKeyValue(attr Attribute) otlpKeyValue {
+	var value map[string]any
+
+	switch v := attr.Value.(type) {
+	case string:
+		value = map[string]any{"stringValue": v}
+	case int64:
+		// OTLP/JSON encodes 64-bit integers as decimal strings.
+		value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case bool:
+		value = map[string]any{"boolValue": v}
+	default:
+		value = map[string]any{"stringValue": ""}
+	}
+
+	return otlpKeyValue{Key: attr.Key, Value: value}
+}
@@ -0,0 +1,236 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// exportedSpans decodes the OTLP/JSON requests written by a JSONExporter,
+// one span per line, and checks their resource and scope.
+func exportedSpans(t *testing.T, buf *bytes.Buffer) []otlpSpan {
+	t.Helper()
+
+	var spans []otlpSpan
+
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var req otlpRequest
+
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", scanner.Bytes(), err)
+		}
+
+		if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+			t.Fatalf("got request %s; want one span", scanner.Bytes())
+		}
+
+		rs := req.ResourceSpans[0]
+
+		service := attributes(rs.Resource.Attributes)["service.name"]
+		if service["stringValue"] != "thmanyah" {
+			t.Errorf("got service.name %v; want thmanyah", service)
+		}
+
+		if rs.ScopeSpans[0].Scope.Name != "github.com/JLL32/thmanyah/internal/tracing" {
+			t.Errorf("got scope %q", rs.ScopeSpans[0].Scope.Name)
+		}
+
+		spans = append(spans, rs.ScopeSpans[0].Spans[0])
+	}
+
+	return spans
+}
+
+func attributes(kvs []otlpKeyValue) map[string]map[string]any {
+	attrs := make(map[string]map[string]any, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func newTestTracer(t *testing.T) (*Tracer, *bytes.Buffer) {
+	var buf bytes.Buffer
+
+	exporter := NewJSONExporter(&buf, "thmanyah", func(err error) {
+		t.Errorf("exporting span: %v", err)
+	})
+
+	return NewTracer(exporter), &buf
+}
+
+func TestJSONExporterContinuesTrace(t *testing.T) {
+	tracer, buf := newTestTracer(t)
+
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("valid traceparent rejected")
+	}
+
+	ctx := ContextWithRemoteSpanContext(context.Background(), sc)
+
+	ctx, server := tracer.Start(ctx, "GET", SpanKindServer, String("http.request.method", "GET"))
+	server.SetName("GET /v1/videos/:id")
+
+	_, query := tracer.Start(ctx, "VideoModel.Get", SpanKindClient,
+		String("db.system", "postgresql"),
+		String("db.statement", "SELECT video_id, title FROM videos WHERE video_id = $1"),
+	)
+	query.SetAttributes(Int("db.row_count", 1))
+	query.End()
+
+	server.SetAttributes(Int("http.response.status_code", 500))
+	server.SetStatus(StatusError, "Internal Server Error")
+	server.End()
+
+	// Ending a span again doesn't export it twice.
+	server.End()
+
+	spans := exportedSpans(t, buf)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans; want 2", len(spans))
+	}
+
+	// Spans are exported as they end, so the query comes first.
+	gotQuery, gotServer := spans[0], spans[1]
+
+	for _, span := range spans {
+		if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s has trace ID %s; want the incoming one", span.Name, span.TraceID)
+		}
+
+		if len(span.SpanID) != 16 || span.SpanID == "0000000000000000" {
+			t.Errorf("span %s has span ID %q", span.Name, span.SpanID)
+		}
+
+		start, err1 := strconv.ParseInt(span.StartTimeUnixNano, 10, 64)
+		end, err2 := strconv.ParseInt(span.EndTimeUnixNano, 10, 64)
+		if err1 != nil || err2 != nil || start <= 0 || end < start {
+			t.Errorf("span %s runs from %s to %s", span.Name, span.StartTimeUnixNano, span.EndTimeUnixNano)
+		}
+	}
+
+	if gotServer.SpanID != server.SpanContext().SpanID.String() || gotQuery.SpanID != query.SpanContext().SpanID.String() {
+		t.Errorf("got span IDs %s and %s; want %s and %s", gotServer.SpanID, gotQuery.SpanID, server.SpanContext().SpanID, query.SpanContext().SpanID)
+	}
+
+	if gotServer.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("got server span parent %q; want the incoming span", gotServer.ParentSpanID)
+	}
+
+	if gotQuery.ParentSpanID != gotServer.SpanID {
+		t.Errorf("got query span parent %q; want the server span %s", gotQuery.ParentSpanID, gotServer.SpanID)
+	}
+
+	if gotServer.Name != "GET /v1/videos/:id" || gotServer.Kind != SpanKindServer {
+		t.Errorf("got server span %q of kind %d", gotServer.Name, gotServer.Kind)
+	}
+
+	if gotServer.Status.Code != StatusError || gotServer.Status.Message != "Internal Server Error" {
+		t.Errorf("got server span status %+v", gotServer.Status)
+	}
+
+	if gotQuery.Kind != SpanKindClient || gotQuery.Status.Code != StatusUnset {
+		t.Errorf("got query span of kind %d with status %+v", gotQuery.Kind, gotQuery.Status)
+	}
+
+	attrs := attributes(gotQuery.Attributes)
+
+	if got := attrs["db.statement"]["stringValue"]; got != "SELECT video_id, title FROM videos WHERE video_id = $1" {
+		t.Errorf("got db.statement %v", attrs["db.statement"])
+	}
+
+	// OTLP/JSON encodes 64-bit integers as strings.
+	if got := attrs["db.row_count"]["intValue"]; got != "1" {
+		t.Errorf("got db.row_count %v; want intValue \"1\"", attrs["db.row_count"])
+	}
+
+	if got := attributes(gotServer.Attributes)["http.response.status_code"]["intValue"]; got != "500" {
+		t.Errorf("got http.response.status_code %v", got)
+	}
+}
+
+func TestMalformedTraceparentStartsNewTrace(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"garbage",
+		// All-zero trace and span IDs are invalid.
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		// Upper-case hex, a forbidden version, and trailing data on
+		// version 00.
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		tracer, buf := newTestTracer(t)
+
+		// As traceRequests does: only a valid header continues a trace.
+		ctx := context.Background()
+		if sc, ok := ParseTraceparent(header); ok {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		_, span := tracer.Start(ctx, "GET", SpanKindServer)
+		span.End()
+
+		spans := exportedSpans(t, buf)
+		if len(spans) != 1 {
+			t.Errorf("%q: got %d spans; want 1", header, len(spans))
+			continue
+		}
+
+		if spans[0].ParentSpanID != "" || spans[0].TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%q: got trace %s with parent %q; want a new trace", header, spans[0].TraceID, spans[0].ParentSpanID)
+		}
+
+		if len(spans[0].TraceID) != 32 || spans[0].TraceID == "00000000000000000000000000000000" {
+			t.Errorf("%q: got trace ID %q", header, spans[0].TraceID)
+		}
+	}
+}
+
+func TestUnsampledTraceIsNotExported(t *testing.T) {
+	tracer, buf := newTestTracer(t)
+
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if !ok {
+		t.Fatal("valid traceparent rejected")
+	}
+
+	ctx, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), sc), "GET", SpanKindServer)
+	_, child := tracer.Start(ctx, "VideoModel.Get", SpanKindClient)
+	child.End()
+	span.End()
+
+	if buf.Len() != 0 {
+		t.Errorf("exported %s; want nothing for an unsampled trace", buf.String())
+	}
+
+	// The trace is still propagated.
+	if got := span.SpanContext().Traceparent(); got[:36] != "00-4bf92f3577b34da6a3ce929d0e0e4736-" || got[52:] != "-00" {
+		t.Errorf("got traceparent %q", got)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, span := tracer.Start(context.Background(), "GET", SpanKindServer)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("a nil tracer started a span")
+	}
+
+	// The span methods are no-ops on the nil span.
+	span.SetAttributes(Int("db.row_count", 1))
+	span.RecordError(context.Canceled)
+	span.End()
+}
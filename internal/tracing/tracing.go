@@ -0,0 +1,301 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span and carries the parts of it that are
+// propagated across process boundaries in a W3C traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	Remote  bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 W3C traceparent
+// header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Future versions are
+// accepted as long as they start with the version 00 fields.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	header = strings.TrimSpace(header)
+
+	if len(header) < 55 {
+		return SpanContext{}, false
+	}
+
+	parts := strings.Split(header[:55], "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff {
+		return SpanContext{}, false
+	}
+	if version[0] == 0 && len(header) != 55 {
+		return SpanContext{}, false
+	}
+	if version[0] > 0 && len(header) > 55 && header[55] != '-' {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	if !sc.IsValid() || strings.ToLower(header[:55]) != header[:55] {
+		return SpanContext{}, false
+	}
+
+	sc.Sampled = flags[0]&0x01 == 0x01
+	sc.Remote = true
+
+	return sc, true
+}
+
+type SpanKind int
+
+// Values match the OTLP Span.SpanKind enum.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// Values match the OTLP Status.StatusCode enum.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type Span struct {
+	tracer *Tracer
+
+	mu            sync.Mutex
+	name          string
+	kind          SpanKind
+	spanContext   SpanContext
+	parent        SpanID
+	start         time.Time
+	end           time.Time
+	attributes    []Attribute
+	statusCode    StatusCode
+	statusMessage string
+	ended         bool
+}
+
+// SpanContext returns the span's identifiers. It is safe to call on a nil
+// span, which is what Start returns when tracing is disabled.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.spanContext
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attributes = append(s.attributes, attrs...)
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statusCode = code
+	s.statusMessage = message
+}
+
+// RecordError marks the span as failed. A nil err is ignored so callers can
+// pass through whatever error they are about to return.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.SetStatus(StatusError, err.Error())
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.spanContext.Sampled {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Tracer creates spans and hands finished, sampled spans to its exporter. A
+// nil *Tracer is valid and disables tracing; trace context is then only
+// propagated, never recorded.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type contextKey string
+
+const (
+	spanContextKey   = contextKey("span")
+	remoteContextKey = contextKey("remote")
+)
+
+// ContextWithRemoteSpanContext stores a span context received from a caller
+// so that the next span started from ctx becomes its child.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey, sc)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+func spanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.spanContext
+	}
+
+	sc, _ := ctx.Value(remoteContextKey).(SpanContext)
+	return sc
+}
+
+// Start begins a new span as a child of the span (or remote span context)
+// stored in ctx and returns a context carrying the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil || t.exporter == nil {
+		return ctx, nil
+	}
+
+	parent := spanContextFromContext(ctx)
+
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attrs,
+	}
+
+	if parent.IsValid() {
+		span.spanContext.TraceID = parent.TraceID
+		span.spanContext.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.spanContext.TraceID[:])
+		span.spanContext.Sampled = true
+	}
+
+	rand.Read(span.spanContext.SpanID[:])
+
+	return context.WithValue(ctx, spanContextKey, span), span
+}
@@ -0,0 +1,98 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Fake is a Transcoder that doesn't decode its input. For each rendition it
+// writes a valid media playlist referencing Segments placeholder segments, so
+// the rest of the pipeline can run without ffmpeg.
+type Fake struct {
+	// Segments is the number of segments per rendition; 3 if zero.
+	Segments int
+	// Err, if set, is returned by Transcode instead of producing output.
+	Err error
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+func (f *Fake) Transcode(ctx context.Context, req Request) ([]Output, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	err := validateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(req.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := f.Segments
+	if segments == 0 {
+		segments = 3
+	}
+
+	var outputs []Output
+
+	for _, r := range req.renditions() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		out := newOutput(r, req.AudioOnly)
+
+		dir := filepath.Join(req.OutputDir, out.Dir)
+
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+
+		var playlist strings.Builder
+		fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", segmentSeconds)
+
+		for i := range segments {
+			name := fmt.Sprintf("segment_%05d.ts", i)
+
+			err := os.WriteFile(filepath.Join(dir, name), []byte("fake segment "+r.Name+" "+name+"\n"), 0o644)
+			if err != nil {
+				return nil, err
+			}
+
+			fmt.Fprintf(&playlist, "#EXTINF:%d.000,\n%s\n", segmentSeconds, name)
+		}
+
+		playlist.WriteString("#EXT-X-ENDLIST\n")
+
+		err = os.WriteFile(filepath.Join(dir, out.Playlist), []byte(playlist.String()), 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, out)
+	}
+
+	return outputs, nil
+}
+
+// Requests returns the requests Transcode has been called with.
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Request(nil), f.requests...)
+}
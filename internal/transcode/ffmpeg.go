@@ -0,0 +1,112 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// segmentSeconds is the target HLS segment duration.
+	segmentSeconds = 6
+	// stderrTail is how much of ffmpeg's output is kept for error messages.
+	stderrTail = 2048
+)
+
+// FFmpeg transcodes by running the ffmpeg binary once per rendition.
+type FFmpeg struct {
+	// Path is the ffmpeg executable; "ffmpeg" (looked up in PATH) if empty.
+	Path string
+}
+
+func (f *FFmpeg) Transcode(ctx context.Context, req Request) ([]Output, error) {
+	err := validateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []Output
+
+	for _, r := range req.renditions() {
+		out := newOutput(r, req.AudioOnly)
+
+		dir := filepath.Join(req.OutputDir, out.Dir)
+
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+
+		err = f.run(ctx, ffmpegArgs(req.Input, dir, out, req.AudioOnly))
+		if err != nil {
+			return nil, fmt.Errorf("transcode: rendition %s: %w", r.Name, err)
+		}
+
+		outputs = append(outputs, out)
+	}
+
+	return outputs, nil
+}
+
+func (f *FFmpeg) run(ctx context.Context, args []string) error {
+	path := f.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > stderrTail {
+			output = "..." + output[len(output)-stderrTail:]
+		}
+
+		return fmt.Errorf("%w: %s", err, output)
+	}
+
+	return nil
+}
+
+func ffmpegArgs(input, dir string, out Output, audioOnly bool) []string {
+	args := []string{"-hide_banner", "-nostdin", "-loglevel", "error", "-y", "-i", input}
+
+	if audioOnly {
+		args = append(args, "-vn")
+	} else {
+		// Scale to the size Request.renditions fitted the source to, so
+		// the output reports what was encoded. Keyframes every segment let
+		// players switch renditions at segment boundaries.
+		args = append(args,
+			"-map", "0:v:0", "-map", "0:a:0?",
+			"-vf", fmt.Sprintf("scale=w=%d:h=%d", out.Width, out.Height),
+			"-c:v", "libx264", "-profile:v", "main", "-preset", "veryfast",
+			"-b:v", strconv.Itoa(out.VideoBitrate),
+			"-maxrate", strconv.Itoa(out.VideoBitrate*107/100),
+			"-bufsize", strconv.Itoa(out.VideoBitrate*3/2),
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds), "-sc_threshold", "0",
+		)
+	}
+
+	return append(args,
+		"-c:a", "aac", "-b:a", strconv.Itoa(out.AudioBitrate), "-ac", "2",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "segment_%05d.ts"),
+		filepath.Join(dir, out.Playlist),
+	)
+}
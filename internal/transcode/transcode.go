@@ -0,0 +1,170 @@
+// Package transcode turns uploaded media into HLS renditions. The Transcoder
+// interface has an implementation that runs ffmpeg and a fake one that writes
+// placeholder output, for tests and local development without ffmpeg.
+package transcode
+
+import (
+	"context"
+	"errors"
+	"path"
+)
+
+// Rendition describes one variant of the HLS ladder. In a ladder, Width and
+// Height bound the frame; in an Output they are the size actually encoded.
+type Rendition struct {
+	Name         string
+	Width        int
+	Height       int
+	VideoBitrate int // bits per second
+	AudioBitrate int // bits per second
+}
+
+// DefaultLadder is the set of video renditions produced for every upload.
+var DefaultLadder = []Rendition{
+	{Name: "1080p", Width: 1920, Height: 1080, VideoBitrate: 5_000_000, AudioBitrate: 128_000},
+	{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 2_800_000, AudioBitrate: 128_000},
+	{Name: "480p", Width: 854, Height: 480, VideoBitrate: 1_400_000, AudioBitrate: 128_000},
+	{Name: "360p", Width: 640, Height: 360, VideoBitrate: 800_000, AudioBitrate: 96_000},
+}
+
+// AudioRendition is the only rendition produced for audio-only uploads.
+var AudioRendition = Rendition{Name: "audio", AudioBitrate: 128_000}
+
+const (
+	videoCodecs = "avc1.4d401f,mp4a.40.2" // H.264 Main, AAC-LC
+	audioCodecs = "mp4a.40.2"
+)
+
+// Request is a single transcoding run.
+type Request struct {
+	// Input is the path of the source file.
+	Input string
+	// OutputDir is an existing, empty directory. Each rendition is written
+	// to a subdirectory named after it.
+	OutputDir string
+	// AudioOnly drops any video stream and produces AudioRendition only.
+	AudioOnly bool
+	// Renditions is the video ladder, largest first; DefaultLadder if
+	// empty.
+	Renditions []Rendition
+	// SourceWidth and SourceHeight are the frame size of the input's video
+	// stream, as probed. They are required unless AudioOnly is set.
+	SourceWidth  int
+	SourceHeight int
+}
+
+// renditions returns the renditions to produce for req. Each video rendition
+// is the source scaled to fit inside its ladder frame, with Width and Height
+// set to the result. Frames the source would have to be scaled up to fill
+// are skipped, unless the source is smaller than all of them, in which case
+// the smallest rendition keeps the source's size.
+func (req Request) renditions() []Rendition {
+	if req.AudioOnly {
+		return []Rendition{AudioRendition}
+	}
+
+	ladder := req.Renditions
+	if len(ladder) == 0 {
+		ladder = DefaultLadder
+	}
+
+	var renditions []Rendition
+
+	for _, r := range ladder {
+		if r.Width > req.SourceWidth && r.Height > req.SourceHeight {
+			continue
+		}
+
+		r.Width, r.Height = fitFrame(req.SourceWidth, req.SourceHeight, r.Width, r.Height)
+		renditions = append(renditions, r)
+	}
+
+	if len(renditions) == 0 {
+		r := ladder[len(ladder)-1]
+		r.Width, r.Height = fitFrame(req.SourceWidth, req.SourceHeight, req.SourceWidth, req.SourceHeight)
+		renditions = append(renditions, r)
+	}
+
+	return renditions
+}
+
+// fitFrame scales a width×height frame down to fit inside maxWidth×maxHeight,
+// keeping its aspect ratio, and rounds the result to even dimensions as
+// H.264 requires.
+func fitFrame(width, height, maxWidth, maxHeight int) (int, int) {
+	if width*maxHeight > height*maxWidth {
+		// Wider than the bounds: the width limits the scale.
+		height = height * maxWidth / width
+		width = maxWidth
+	} else {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+
+	return max(2, width&^1), max(2, height&^1)
+}
+
+// Output describes a rendition that was written to disk.
+type Output struct {
+	Rendition
+	// Bandwidth is the peak bit rate to advertise in a master playlist.
+	Bandwidth int
+	// Codecs is the RFC 6381 codecs string for a master playlist.
+	Codecs string
+	// Dir is the rendition's directory, relative to Request.OutputDir.
+	Dir string
+	// Playlist is the media playlist's file name within Dir.
+	Playlist string
+}
+
+func newOutput(r Rendition, audioOnly bool) Output {
+	out := Output{
+		Rendition: r,
+		// Leave headroom above the average bit rates for container
+		// overhead and encoder peaks.
+		Bandwidth: (r.VideoBitrate + r.AudioBitrate) * 11 / 10,
+		Codecs:    videoCodecs,
+		Dir:       r.Name,
+		Playlist:  "index.m3u8",
+	}
+
+	if audioOnly {
+		out.Codecs = audioCodecs
+	}
+
+	return out
+}
+
+type Transcoder interface {
+	// Transcode writes an HLS media playlist and its segments for each
+	// rendition of req, and describes them in the returned outputs.
+	Transcode(ctx context.Context, req Request) ([]Output, error)
+}
+
+// ContentType returns the media type of a file produced by a Transcoder, by
+// its extension.
+func ContentType(name string) string {
+	contentType, ok := contentTypes[path.Ext(name)]
+	if !ok {
+		return "application/octet-stream"
+	}
+
+	return contentType
+}
+
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+}
+
+func validateRequest(req Request) error {
+	if req.Input == "" || req.OutputDir == "" {
+		return errors.New("transcode: Input and OutputDir are required")
+	}
+
+	if !req.AudioOnly && (req.SourceWidth < 1 || req.SourceHeight < 1) {
+		return errors.New("transcode: SourceWidth and SourceHeight are required for video")
+	}
+
+	return nil
+}
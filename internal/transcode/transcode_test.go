@@ -0,0 +1,61 @@
+package transcode
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequestRenditions(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          []string
+	}{
+		{"1080p", 1920, 1080, []string{"1080p 1920x1080", "720p 1280x720", "480p 852x480", "360p 640x360"}},
+		{"4:3 480p", 640, 480, []string{"480p 640x480", "360p 480x360"}},
+		{"vertical", 1080, 1920, []string{"1080p 606x1080", "720p 404x720", "480p 270x480", "360p 202x360"}},
+		{"wider than 16:9", 1920, 800, []string{"1080p 1920x800", "720p 1280x532", "480p 854x354", "360p 640x266"}},
+		{"between rungs", 1000, 562, []string{"480p 854x478", "360p 640x358"}},
+		{"smaller than every rung", 320, 241, []string{"360p 320x240"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := Request{SourceWidth: tt.width, SourceHeight: tt.height}
+
+			var got []string
+			for _, r := range req.renditions() {
+				got = append(got, fmt.Sprintf("%s %dx%d", r.Name, r.Width, r.Height))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFFmpegArgsScaleToFittedSize(t *testing.T) {
+	req := Request{Input: "in.mp4", OutputDir: "out", SourceWidth: 1080, SourceHeight: 1920}
+
+	out := newOutput(req.renditions()[0], false)
+	args := strings.Join(ffmpegArgs(req.Input, "out/1080p", out, false), " ")
+
+	if !strings.Contains(args, "-vf scale=w=606:h=1080 ") {
+		t.Errorf("got args %q; want the frame scaled to 606x1080", args)
+	}
+}
+
+func TestValidateRequestRequiresSourceSize(t *testing.T) {
+	err := validateRequest(Request{Input: "in.mp4", OutputDir: "out"})
+	if err == nil {
+		t.Error("got no error for a video request without the source size")
+	}
+
+	err = validateRequest(Request{Input: "in.m4a", OutputDir: "out", AudioOnly: true})
+	if err != nil {
+		t.Errorf("audio request: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Package urlsign signs URL paths with an expiry time, so a server can hand
+// out links to protected media that stop working after a while and can't be
+// altered to point at anything else. A signature can also be bound to the
+// client's IP address, so a copied link is useless elsewhere.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid URL signature")
+	ErrExpired          = errors.New("signed URL has expired")
+	ErrIPMismatch       = errors.New("signed URL is bound to another IP address")
+)
+
+// MinKeyLength is the minimum length of a signing secret in bytes.
+const MinKeyLength = 32
+
+// Signer signs and verifies URL paths with HMAC-SHA256. The key ID, expiry,
+// bound IP address and signature travel in the "kid", "expires", "ip" and
+// "sig" query parameters.
+//
+// To rotate keys, add the new key to Keys on every server first, then make it
+// the KeyID, and remove the old key once the URLs signed with it have
+// expired.
+type Signer struct {
+	// Keys maps key IDs to secrets. Any of them can verify a signature.
+	Keys map[string][]byte
+	// KeyID is the key that new signatures are made with.
+	KeyID string
+}
+
+// Token is what a verified signature vouches for.
+type Token struct {
+	KeyID   string
+	Expires time.Time
+	// IP is the client address the URL is bound to, or empty if it isn't.
+	IP string
+}
+
+// Sign returns the query parameters that authorise a request for path until
+// expires, from the IP address ip or from anywhere if ip is empty.
+func (s *Signer) Sign(path string, expires time.Time, ip string) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	query := url.Values{
+		"kid":     {s.KeyID},
+		"expires": {exp},
+		"sig":     {signature(s.Keys[s.KeyID], s.KeyID, path, exp, ip)},
+	}
+
+	if ip != "" {
+		query.Set("ip", ip)
+	}
+
+	return query
+}
+
+// SignURL returns path with the query parameters from Sign appended.
+func (s *Signer) SignURL(path string, expires time.Time, ip string) string {
+	return path + "?" + s.Sign(path, expires, ip).Encode()
+}
+
+// Verify checks that query carries a valid signature of path, made with one
+// of the Keys, that hasn't expired at now and isn't bound to an IP address
+// other than ip.
+func (s *Signer) Verify(path string, query url.Values, now time.Time, ip string) (Token, error) {
+	kid := query.Get("kid")
+	exp := query.Get("expires")
+	boundIP := query.Get("ip")
+
+	key, ok := s.Keys[kid]
+	if !ok {
+		return Token{}, ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return Token{}, ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(signature(key, kid, path, exp, boundIP))) {
+		return Token{}, ErrInvalidSignature
+	}
+
+	if now.Unix() >= expires {
+		return Token{}, ErrExpired
+	}
+
+	if boundIP != "" && boundIP != ip {
+		return Token{}, ErrIPMismatch
+	}
+
+	return Token{KeyID: kid, Expires: time.Unix(expires, 0), IP: boundIP}, nil
+}
+
+func signature(key []byte, kid, path, expires, ip string) string {
+	mac := hmac.New(sha256.New, key)
+
+	for _, field := range []string{kid, path, expires, ip} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{'\n'})
+	}
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseKeys parses a space-separated list of "kid:secret" pairs into a
+// Signer that signs with the first key.
+func ParseKeys(val string) (*Signer, error) {
+	s := &Signer{Keys: make(map[string][]byte)}
+
+	for i, field := range strings.Fields(val) {
+		kid, secret, ok := strings.Cut(field, ":")
+		if !ok || kid == "" {
+			// Report the position rather than the field, which may hold
+			// a secret.
+			return nil, fmt.Errorf("urlsign: key %d is not of the form kid:secret", i+1)
+		}
+
+		if len(secret) < MinKeyLength {
+			return nil, fmt.Errorf("urlsign: key %q must be at least %d bytes long", kid, MinKeyLength)
+		}
+
+		if _, exists := s.Keys[kid]; exists {
+			return nil, fmt.Errorf("urlsign: duplicate key ID %q", kid)
+		}
+
+		s.Keys[kid] = []byte(secret)
+
+		if s.KeyID == "" {
+			s.KeyID = kid
+		}
+	}
+
+	if s.KeyID == "" {
+		return nil, errors.New("urlsign: no keys")
+	}
+
+	return s, nil
+}
@@ -0,0 +1,186 @@
+package urlsign
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testPath = "/v1/videos/dQw4w9WgXcQ/hls/1080p/index.m3u8"
+	testIP   = "203.0.113.7"
+)
+
+var (
+	oldSecret = strings.Repeat("o", MinKeyLength)
+	newSecret = strings.Repeat("n", MinKeyLength)
+)
+
+func TestVerify(t *testing.T) {
+	signer := &Signer{Keys: map[string][]byte{"2024-01": []byte(oldSecret)}, KeyID: "2024-01"}
+
+	now := time.Unix(1_704_067_200, 0)
+	expires := now.Add(time.Hour)
+
+	bound := signer.Sign(testPath, expires, testIP)
+	unbound := signer.Sign(testPath, expires, "")
+
+	tests := []struct {
+		name  string
+		path  string
+		query url.Values
+		now   time.Time
+		ip    string
+		err   error
+	}{
+		{"bound, same IP", testPath, bound, now, testIP, nil},
+		{"unbound, any IP", testPath, unbound, now, "198.51.100.1", nil},
+		{"bound, other IP", testPath, bound, now, "198.51.100.1", ErrIPMismatch},
+		{"just before expiry", testPath, bound, expires.Add(-time.Second), testIP, nil},
+		{"at expiry", testPath, bound, expires, testIP, ErrExpired},
+		{"after expiry", testPath, bound, expires.Add(time.Hour), testIP, ErrExpired},
+		{"other path", "/v1/videos/dQw4w9WgXcQ/hls/720p/index.m3u8", bound, now, testIP, ErrInvalidSignature},
+		{"path with suffix", testPath + "x", bound, now, testIP, ErrInvalidSignature},
+		{"extended expiry", testPath, set(bound, "expires", "1804067200"), now, testIP, ErrInvalidSignature},
+		{"malformed expiry", testPath, set(bound, "expires", "soon"), now, testIP, ErrInvalidSignature},
+		{"other bound IP", testPath, set(bound, "ip", "198.51.100.1"), now, "198.51.100.1", ErrInvalidSignature},
+		{"IP binding removed", testPath, set(bound, "ip", ""), now, "198.51.100.1", ErrInvalidSignature},
+		{"IP binding added", testPath, set(unbound, "ip", testIP), now, testIP, ErrInvalidSignature},
+		{"altered signature", testPath, set(bound, "sig", flipLast(bound.Get("sig"))), now, testIP, ErrInvalidSignature},
+		{"missing signature", testPath, set(bound, "sig", ""), now, testIP, ErrInvalidSignature},
+		{"unknown key ID", testPath, set(bound, "kid", "2023-12"), now, testIP, ErrInvalidSignature},
+		{"missing key ID", testPath, set(bound, "kid", ""), now, testIP, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := signer.Verify(tt.path, tt.query, tt.now, tt.ip)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v; want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if token.KeyID != "2024-01" || !token.Expires.Equal(expires) || token.IP != tt.query.Get("ip") {
+				t.Errorf("got token %+v", token)
+			}
+		})
+	}
+}
+
+// set returns a copy of query with key set to value, or removed if value is
+// empty.
+func set(query url.Values, key, value string) url.Values {
+	copied := make(url.Values, len(query))
+	for k, v := range query {
+		copied[k] = append([]string(nil), v...)
+	}
+
+	if value == "" {
+		copied.Del(key)
+	} else {
+		copied.Set(key, value)
+	}
+
+	return copied
+}
+
+// flipLast changes the last character of a base64url signature.
+func flipLast(sig string) string {
+	last := sig[len(sig)-1]
+
+	if last == 'A' {
+		return sig[:len(sig)-1] + "B"
+	}
+
+	return sig[:len(sig)-1] + "A"
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	now := time.Unix(1_704_067_200, 0)
+	expires := now.Add(time.Hour)
+
+	old := &Signer{Keys: map[string][]byte{"2024-01": []byte(oldSecret)}, KeyID: "2024-01"}
+	query := old.Sign(testPath, expires, testIP)
+
+	// The new key is added and made current; the old one still verifies.
+	rotated := &Signer{
+		Keys:  map[string][]byte{"2024-01": []byte(oldSecret), "2024-02": []byte(newSecret)},
+		KeyID: "2024-02",
+	}
+
+	token, err := rotated.Verify(testPath, query, now, testIP)
+	if err != nil {
+		t.Fatalf("verifying a URL signed with the old key: %v", err)
+	}
+	if token.KeyID != "2024-01" {
+		t.Errorf("got key ID %q; want 2024-01", token.KeyID)
+	}
+
+	signed := rotated.Sign(testPath, expires, testIP)
+	if signed.Get("kid") != "2024-02" {
+		t.Errorf("signed with key %q; want 2024-02", signed.Get("kid"))
+	}
+
+	_, err = rotated.Verify(testPath, signed, now, testIP)
+	if err != nil {
+		t.Fatalf("verifying a URL signed with the new key: %v", err)
+	}
+
+	// A signature made with one key doesn't pass for the other's.
+	_, err = rotated.Verify(testPath, set(signed, "kid", "2024-01"), now, testIP)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got error %v for a signature under the wrong key ID; want ErrInvalidSignature", err)
+	}
+
+	// Once the old key is removed, its URLs stop working.
+	retired := &Signer{Keys: map[string][]byte{"2024-02": []byte(newSecret)}, KeyID: "2024-02"}
+
+	_, err = retired.Verify(testPath, query, now, testIP)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got error %v after removing the old key; want ErrInvalidSignature", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	signer, err := ParseKeys("2024-02:" + newSecret + " 2024-01:" + oldSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signer.KeyID != "2024-02" {
+		t.Errorf("got KeyID %q; want the first key, 2024-02", signer.KeyID)
+	}
+
+	if string(signer.Keys["2024-01"]) != oldSecret || string(signer.Keys["2024-02"]) != newSecret {
+		t.Errorf("got keys %q", signer.Keys)
+	}
+}
+
+func TestParseKeysRejects(t *testing.T) {
+	for _, val := range []string{
+		"",
+		"   ",
+		"2024-01:" + oldSecret[1:],
+		"2024-01:",
+		"2024-01",
+		":" + oldSecret,
+		"2024-01:" + oldSecret + " 2024-02:short",
+		"2024-01:" + oldSecret + " 2024-01:" + newSecret,
+	} {
+		_, err := ParseKeys(val)
+		if err == nil {
+			t.Errorf("ParseKeys(%q): got no error", val)
+			continue
+		}
+
+		// Errors may be logged, so they mustn't contain secrets.
+		if strings.Contains(err.Error(), oldSecret[:8]) || strings.Contains(err.Error(), newSecret[:8]) {
+			t.Errorf("ParseKeys(%q): error %q contains the secret", val, err)
+		}
+	}
+}
@@ -1,20 +1,45 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// VideoIDRX matches 11-character YouTube-style IDs drawn from the
+	// URL-safe base64 alphabet (A-Z, a-z, 0-9, '-' and '_'). IDs generated by
+	// the server are 8 random bytes in unpadded URL-safe base64, which always
+	// fits this format; client-supplied IDs, e.g. for imports, must match it
+	// too.
+	VideoIDRX = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+	// LanguageTagRX matches the common subset of BCP 47 language tags: a
+	// primary language subtag, then optional extended language, script,
+	// region and variant subtags (e.g. "ar", "en-GB", "zh-Hant-TW").
+	LanguageTagRX = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{3}){0,3}(-[A-Za-z]{4})?(-([A-Za-z]{2}|[0-9]{3}))?(-([A-Za-z0-9]{5,8}|[0-9][A-Za-z0-9]{3}))*$`)
+
+	// SHA256RX matches a SHA-256 digest in lowercase hex.
+	SHA256RX = regexp.MustCompile(`^[0-9a-f]{64}$`)
 )
 
+// Validator collects validation errors by field. Errors holds the first
+// message for each field, which is what most responses report; FieldErrors
+// holds every message, in the order they were added.
 type Validator struct {
-	Errors map[string]string
+	Errors      map[string]string
+	FieldErrors map[string][]string
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{
+		Errors:      make(map[string]string),
+		FieldErrors: make(map[string][]string),
+	}
 }
 
 func (v *Validator) Valid() bool {
@@ -25,6 +50,10 @@ func (v *Validator) AddError(key, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
+
+	if !slices.Contains(v.FieldErrors[key], message) {
+		v.FieldErrors[key] = append(v.FieldErrors[key], message)
+	}
 }
 
 func (v *Validator) Check(ok bool, key, message string) {
@@ -50,3 +79,73 @@ func Unique[T comparable](values []T) bool {
 
 	return len(values) == len(uniqueValues)
 }
+
+// NotBlank reports whether value contains anything other than whitespace.
+func NotBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+// MaxRunes reports whether value is at most n characters long. Unlike len, it
+// counts Arabic and other multi-byte characters once each.
+func MaxRunes(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+func MinRunes(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+func ValidUTF8(value string) bool {
+	return utf8.ValidString(value)
+}
+
+// HTTPURL reports whether value is an absolute http or https URL.
+func HTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ValidLanguageTag reports whether tag is a well-formed BCP 47 language tag
+// whose primary subtag, if two letters long, is a known ISO 639-1 code.
+func ValidLanguageTag(tag string) bool {
+	if !LanguageTagRX.MatchString(tag) {
+		return false
+	}
+
+	primary, _, _ := strings.Cut(tag, "-")
+	if len(primary) == 2 {
+		return ISO6391(strings.ToLower(primary))
+	}
+
+	return true
+}
+
+// ISO6391 reports whether code is a two-letter lowercase ISO 639-1 language
+// code.
+func ISO6391(code string) bool {
+	_, ok := iso6391Codes[code]
+	return ok
+}
+
+var iso6391Codes = func() map[string]struct{} {
+	codes := strings.Fields(`
+		aa ab ae af ak am an ar as av ay az ba be bg bi bm bn bo br bs ca ce ch
+		co cr cs cu cv cy da de dv dz ee el en eo es et eu fa ff fi fj fo fr fy
+		ga gd gl gn gu gv ha he hi ho hr ht hu hy hz ia id ie ig ii ik io is it
+		iu ja jv ka kg ki kj kk kl km kn ko kr ks ku kv kw ky la lb lg li ln lo
+		lt lu lv mg mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv ny
+		oc oj om or os pa pi pl ps pt qu rm rn ro ru rw sa sc sd se sg si sk sl
+		sm sn so sq sr ss st su sv sw ta te tg th ti tk tl tn to tr ts tt tw ty
+		ug uk ur uz ve vi vo wa wo xh yi yo za zh zu`)
+
+	m := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		m[code] = struct{}{}
+	}
+
+	return m
+}()
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS media_assets (
+   id bigserial PRIMARY KEY,
+   video_id varchar(11) NOT NULL REFERENCES videos ON DELETE CASCADE,
+   kind text NOT NULL,
+   filename text NOT NULL,
+   content_type text NOT NULL,
+   size bigint NOT NULL,
+   sha256 char(64) NOT NULL,
+   storage_key text NOT NULL,
+   status text NOT NULL DEFAULT 'uploading',
+   uploaded_bytes bigint NOT NULL DEFAULT 0,
+   parts bigint[] NOT NULL DEFAULT '{}',
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   version integer NOT NULL DEFAULT 1,
+   CONSTRAINT media_assets_size_check CHECK (size > 0),
+   CONSTRAINT media_assets_status_check CHECK (status IN ('uploading', 'complete')),
+   CONSTRAINT media_assets_uploaded_bytes_check CHECK (uploaded_bytes BETWEEN 0 AND size)
+);
+
+CREATE INDEX IF NOT EXISTS media_assets_video_id_idx ON media_assets (video_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS video_images (
+   video_id varchar(11) NOT NULL REFERENCES videos ON DELETE CASCADE,
+   kind text NOT NULL,
+   width integer NOT NULL,
+   height integer NOT NULL,
+   storage_key text NOT NULL,
+   size bigint NOT NULL,
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   PRIMARY KEY (video_id, kind, width, height)
+);
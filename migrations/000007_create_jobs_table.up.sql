@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS jobs (
+   id bigserial PRIMARY KEY,
+   video_id varchar(11) NOT NULL REFERENCES videos ON DELETE CASCADE,
+   kind text NOT NULL,
+   payload jsonb NOT NULL DEFAULT '{}',
+   status text NOT NULL DEFAULT 'queued',
+   attempts integer NOT NULL DEFAULT 0,
+   max_attempts integer NOT NULL DEFAULT 3,
+   error text NOT NULL DEFAULT '',
+   run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   locked_until timestamp(0) with time zone,
+   started_at timestamp(0) with time zone,
+   finished_at timestamp(0) with time zone,
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'succeeded', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS jobs_video_id_idx ON jobs (video_id);
+CREATE INDEX IF NOT EXISTS jobs_claimable_idx ON jobs (run_at, id) WHERE status IN ('queued', 'running');
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS video_renditions (
+   video_id varchar(11) NOT NULL REFERENCES videos ON DELETE CASCADE,
+   name text NOT NULL,
+   asset_id bigint NOT NULL REFERENCES media_assets ON DELETE CASCADE,
+   job_id bigint REFERENCES jobs ON DELETE SET NULL,
+   width integer NOT NULL DEFAULT 0,
+   height integer NOT NULL DEFAULT 0,
+   bandwidth integer NOT NULL,
+   codecs text NOT NULL,
+   playlist_key text NOT NULL,
+   blob_keys text[] NOT NULL,
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   PRIMARY KEY (video_id, name)
+);
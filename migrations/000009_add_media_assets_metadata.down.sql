@@ -0,0 +1,8 @@
+ALTER TABLE media_assets
+   DROP COLUMN IF EXISTS duration_ms,
+   DROP COLUMN IF EXISTS width,
+   DROP COLUMN IF EXISTS height,
+   DROP COLUMN IF EXISTS video_codec,
+   DROP COLUMN IF EXISTS audio_codec,
+   DROP COLUMN IF EXISTS audio_language,
+   DROP COLUMN IF EXISTS probed_at;
@@ -0,0 +1,8 @@
+ALTER TABLE media_assets
+   ADD COLUMN duration_ms bigint NOT NULL DEFAULT 0,
+   ADD COLUMN width integer NOT NULL DEFAULT 0,
+   ADD COLUMN height integer NOT NULL DEFAULT 0,
+   ADD COLUMN video_codec text NOT NULL DEFAULT '',
+   ADD COLUMN audio_codec text NOT NULL DEFAULT '',
+   ADD COLUMN audio_language text NOT NULL DEFAULT '',
+   ADD COLUMN probed_at timestamp(0) with time zone;
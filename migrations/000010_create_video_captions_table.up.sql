@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS video_captions (
+   video_id varchar(11) NOT NULL REFERENCES videos ON DELETE CASCADE,
+   language text NOT NULL,
+   seq integer NOT NULL,
+   identifier text NOT NULL DEFAULT '',
+   start_ms bigint NOT NULL,
+   end_ms bigint NOT NULL,
+   settings text NOT NULL DEFAULT '',
+   text text NOT NULL,
+   plain_text text NOT NULL,
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   PRIMARY KEY (video_id, language, seq),
+   CONSTRAINT video_captions_timing_check CHECK (start_ms >= 0 AND end_ms > start_ms)
+);
+
+CREATE INDEX IF NOT EXISTS video_captions_plain_text_idx ON video_captions USING GIN (to_tsvector('simple', plain_text));
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS video_chapters (
+   id bigserial PRIMARY KEY,
+   video_id varchar(11) NOT NULL REFERENCES videos ON DELETE CASCADE,
+   start_time integer NOT NULL,
+   end_time integer,
+   title text NOT NULL,
+   image_url text NOT NULL DEFAULT '',
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   version integer NOT NULL DEFAULT 1,
+   CONSTRAINT video_chapters_video_id_start_time_key UNIQUE (video_id, start_time),
+   CONSTRAINT video_chapters_time_check CHECK (start_time >= 0 AND (end_time IS NULL OR end_time > start_time))
+);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS sync_runs (
+   id bigserial PRIMARY KEY,
+   provider text NOT NULL,
+   channel_id text NOT NULL,
+   trigger text NOT NULL,
+   dry_run boolean NOT NULL DEFAULT false,
+   status text NOT NULL DEFAULT 'running',
+   created integer NOT NULL DEFAULT 0,
+   updated integer NOT NULL DEFAULT 0,
+   unchanged integer NOT NULL DEFAULT 0,
+   invalid integer NOT NULL DEFAULT 0,
+   changes jsonb NOT NULL DEFAULT '[]',
+   error text NOT NULL DEFAULT '',
+   started_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   finished_at timestamp(0) with time zone,
+   CONSTRAINT sync_runs_status_check CHECK (status IN ('running', 'succeeded', 'failed')),
+   CONSTRAINT sync_runs_trigger_check CHECK (trigger IN ('schedule', 'api'))
+);
+
+CREATE INDEX IF NOT EXISTS sync_runs_channel_id_started_at_idx ON sync_runs (channel_id, started_at DESC);
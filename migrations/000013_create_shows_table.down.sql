@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS show_images;
+DROP TABLE IF EXISTS shows;
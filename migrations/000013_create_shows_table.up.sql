@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS shows (
+   id bigserial PRIMARY KEY,
+   title text NOT NULL,
+   description text NOT NULL DEFAULT '',
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS show_images (
+   show_id bigint NOT NULL REFERENCES shows ON DELETE CASCADE,
+   kind text NOT NULL,
+   width integer NOT NULL,
+   height integer NOT NULL,
+   storage_key text NOT NULL,
+   size bigint NOT NULL,
+   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+   PRIMARY KEY (show_id, kind, width, height)
+);
@@ -0,0 +1,3 @@
+-- The sizes cleared by the up migration can't be recovered; transcoding the
+-- video again records them.
+SELECT 1;
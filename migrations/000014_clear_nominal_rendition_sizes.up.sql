@@ -0,0 +1,10 @@
+-- Renditions used to record the ladder's frame size rather than the size
+-- encoded, which differs for sources that aren't 16:9 or are smaller than the
+-- frame. Clear the sizes that can't be trusted so master playlists leave out
+-- RESOLUTION for them until the video is transcoded again.
+UPDATE video_renditions r
+SET width = 0, height = 0
+FROM media_assets a
+WHERE a.id = r.asset_id
+   AND r.width > 0
+   AND (a.probed_at IS NULL OR a.width * 9 <> a.height * 16 OR r.height > a.height);